@@ -13,7 +13,7 @@ type LLMResponse struct {
 
 type AgentResponse struct {
 	LLMReply LLMResponse
-	Playlist []Music `json:"playlist"`
+	Playlist []Recommendation `json:"playlist"`
 }
 
 type Agent struct {
@@ -76,7 +76,7 @@ func (agent *Agent) Call(input string) (*LLMResponse, error) {
 	return &llmRes, nil
 }
 
-func AgentWorkflow(input string) (*AgentResponse, error) {
+func AgentWorkflow(input string, userID int64) (*AgentResponse, error) {
 	agent := NewAgent()
 	llmRes, err := agent.Call(input)
 	if err != nil {
@@ -85,7 +85,7 @@ func AgentWorkflow(input string) (*AgentResponse, error) {
 
 	var reply AgentResponse
 	reply.LLMReply = *llmRes
-	reply.Playlist, err = SearchMusicByLabels(labelsToStrings(reply.LLMReply.Labels), 20)
+	reply.Playlist, err = RecommendByLabels(userID, labelsToStrings(reply.LLMReply.Labels), 20)
 	if err != nil {
 		return nil, err
 	}
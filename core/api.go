@@ -2,20 +2,25 @@
 package core
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 const MusicRoot string = "/music"
-const StarPlaylistID int64 = 1 // 预定义“收藏”歌单 ID
+const StarPlaylistID int64 = 1 // 早期所有用户共用的“收藏”歌单 ID，现在每个用户有自己的收藏歌单，见 GetFavoritesPlaylist
 
 func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 	// 健康检查
@@ -25,7 +30,8 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 
 	// 用户登陆
 	router.GET("/api/user/login", func(c *gin.Context) {
-		lists, err := GetAllPlaylists()
+		userID := currentUserID(c)
+		lists, err := GetAllPlaylists(userID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"code":    http.StatusInternalServerError,
@@ -40,7 +46,7 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 			"code":    http.StatusOK,
 			"message": "登录成功",
 			"data": gin.H{
-				"userId":    1,
+				"userId":    userID,
 				"username":  "testuser",
 				"playlists": lists,
 			},
@@ -69,9 +75,9 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 		})
 	})
 
-	// 查询所有歌单
+	// 查询当前用户创建的和作为成员加入的歌单
 	router.GET("/api/check/playlist", func(c *gin.Context) {
-		lists, err := GetAllPlaylists()
+		lists, err := GetAllPlaylists(currentUserID(c))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"code":    http.StatusInternalServerError,
@@ -104,6 +110,9 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 			})
 			return
 		}
+		if !requirePlaylistRole(c, id, PlaylistRoleViewer) {
+			return
+		}
 		playlist, err := GetPlaylistByID(id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -135,7 +144,18 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 			})
 			return
 		}
-		err := AddSongToPlaylist(req.PlaylistID, req.MusicID)
+		if !requirePlaylistRole(c, req.PlaylistID, PlaylistRoleEditor) {
+			return
+		}
+		err := AddSongToPlaylist(req.PlaylistID, req.MusicID, currentUserID(c))
+		if errors.Is(err, ErrPlaylistReadOnly) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    http.StatusForbidden,
+				"message": "系统生成的歌单不能修改",
+				"error":   err.Error(),
+			})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"code":    http.StatusInternalServerError,
@@ -164,7 +184,18 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 			})
 			return
 		}
-		err := RemoveSongFromPlaylist(req.PlaylistID, req.MusicID)
+		if !requirePlaylistRole(c, req.PlaylistID, PlaylistRoleEditor) {
+			return
+		}
+		err := RemoveSongFromPlaylist(req.PlaylistID, req.MusicID, currentUserID(c))
+		if errors.Is(err, ErrPlaylistReadOnly) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    http.StatusForbidden,
+				"message": "系统生成的歌单不能修改",
+				"error":   err.Error(),
+			})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"code":    http.StatusInternalServerError,
@@ -193,7 +224,7 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 			})
 			return
 		}
-		playlist, err := CreatePlaylist(req.Name, req.Description)
+		playlist, err := CreatePlaylist(req.Name, req.Description, currentUserID(c))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"code":    http.StatusInternalServerError,
@@ -209,6 +240,23 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 		})
 	})
 
+	// 修改歌单的名称和描述
+	router.POST("/api/playlist/update", func(c *gin.Context) {
+		var req struct {
+			PlaylistID  int64  `json:"playlistId"`
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		if !requirePlaylistRole(c, req.PlaylistID, PlaylistRoleEditor) {
+			return
+		}
+		playlist, err := RenamePlaylist(req.PlaylistID, currentUserID(c), req.Name, req.Description)
+		respondPlaylistHistory(c, playlist, err)
+	})
+
 	// 删除歌单
 	router.POST("/api/playlist/delete", func(c *gin.Context) {
 		var req struct {
@@ -222,7 +270,18 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 			})
 			return
 		}
-		err := DeletePlaylist(req.PlaylistID)
+		if !requirePlaylistRole(c, req.PlaylistID, PlaylistRoleOwner) {
+			return
+		}
+		err := DeletePlaylist(req.PlaylistID, currentUserID(c))
+		if errors.Is(err, ErrPlaylistReadOnly) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    http.StatusForbidden,
+				"message": "系统生成的歌单不能修改",
+				"error":   err.Error(),
+			})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"code":    http.StatusInternalServerError,
@@ -233,17 +292,79 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 		}
 		c.JSON(http.StatusOK, gin.H{
 			"code":    http.StatusOK,
-			"message": "删除成功",
+			"message": "已移入回收站",
 		})
 	})
 
-	// 根据标签检索音乐
-	router.POST("/api/music/search/labels", func(c *gin.Context) {
+	// 回收站中的歌单
+	router.GET("/api/playlist/trash", func(c *gin.Context) {
+		list, err := GetTrashedPlaylists(currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询回收站失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data": gin.H{
+				"list":      list,
+				"total":     len(list),
+				"retention": PlaylistTrashDays,
+			},
+		})
+	})
+
+	// 从回收站恢复歌单
+	router.POST("/api/playlist/restore", func(c *gin.Context) {
 		var req struct {
-			Labels []string `json:"labels"`
-			Limit  *int     `json:"limit"`
+			PlaylistID int64 `json:"playlistId"`
 		}
-		if err := c.ShouldBindJSON(&req); err != nil {
+		if !bindRequest(c, &req) {
+			return
+		}
+		playlist, err := RestorePlaylist(req.PlaylistID, currentUserID(c))
+		respondPlaylistHistory(c, playlist, err)
+	})
+
+	// 彻底删除回收站中的歌单
+	router.POST("/api/playlist/purge", func(c *gin.Context) {
+		var req struct {
+			PlaylistID int64 `json:"playlistId"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		err := PurgePlaylist(req.PlaylistID, currentUserID(c))
+		respondPlaylistHistory(c, nil, err)
+	})
+
+	// 调整歌单中歌曲的顺序
+	router.POST("/api/playlist/reorder", func(c *gin.Context) {
+		var req struct {
+			PlaylistID int64   `json:"playlistId"`
+			MusicIDs   []int64 `json:"musicIds"`
+			Version    int64   `json:"version"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		if !requirePlaylistRole(c, req.PlaylistID, PlaylistRoleEditor) {
+			return
+		}
+		playlist, err := ReorderPlaylist(req.PlaylistID, currentUserID(c), req.MusicIDs, req.Version)
+		if errors.Is(err, ErrPlaylistConflict) {
+			c.JSON(http.StatusConflict, gin.H{
+				"code":    http.StatusConflict,
+				"message": "歌单已被其他人修改，请刷新后重试",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, ErrInvalidReorder) {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"code":    http.StatusBadRequest,
 				"message": "请求参数格式错误",
@@ -251,224 +372,169 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 			})
 			return
 		}
-
-		numLimit := 20 // 默认限制20首
-		if req.Limit != nil {
-			numLimit = *req.Limit
+		if errors.Is(err, ErrPlaylistReadOnly) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    http.StatusForbidden,
+				"message": "系统生成的歌单不能修改",
+				"error":   err.Error(),
+			})
+			return
 		}
-
-		songs, err := SearchMusicByLabels(req.Labels, numLimit)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"code":    http.StatusInternalServerError,
-				"message": "根据标签检索音乐失败",
+				"message": "调整歌单顺序失败",
 				"error":   err.Error(),
 			})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{
 			"code":    http.StatusOK,
-			"message": "检索成功",
-			"data":    songs,
+			"message": "调整成功",
+			"data":    playlist,
 		})
 	})
 
-	// 搜索框：模糊搜索音乐
-	router.GET("/api/music/search/text/:txt", func(c *gin.Context) {
-		queryStr := c.Param("txt")
-		songs, err := SearchMusicByText(queryStr)
+	// 其他用户共享给当前用户的歌单
+	router.GET("/api/playlist/shared", func(c *gin.Context) {
+		lists, err := GetSharedPlaylists(currentUserID(c))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"code":    http.StatusInternalServerError,
-				"message": "模糊搜索音乐失败",
+				"message": "查询歌单数据失败",
 				"error":   err.Error(),
 			})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{
 			"code":    http.StatusOK,
-			"message": "搜索成功",
-			"data":    songs,
-			"total":   len(songs),
+			"message": "查询成功",
+			"data":    lists,
 		})
 	})
 
-	// AI对话式生成歌单
-	router.POST("/api/ai-recommend", func(c *gin.Context) {
-		var req struct {
-			Message string `json:"message"`
-		}
-		if err := c.ShouldBindJSON(&req); err != nil {
+	// 查询歌单成员
+	router.GET("/api/playlist/members", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Query("playlistId"), 10, 64)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"code":    http.StatusBadRequest,
-				"message": "参数解析错误",
+				"message": "歌单ID格式错误",
 				"error":   err.Error(),
 			})
 			return
 		}
-
-		// call LLM
-		reply, err := AgentWorkflow(req.Message)
-
+		if !requirePlaylistRole(c, id, PlaylistRoleViewer) {
+			return
+		}
+		members, err := GetPlaylistMembers(id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"code":    http.StatusInternalServerError,
-				"message": "大模型推荐失败",
+				"message": "查询歌单成员失败",
 				"error":   err.Error(),
 			})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{
 			"code":    http.StatusOK,
-			"message": "检索成功",
-			"data":    reply,
+			"message": "查询成功",
+			"data":    members,
 		})
 	})
 
-	// 播放音乐
-	router.GET("/api/music/play/:id", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
-		if err != nil {
-			c.String(http.StatusBadRequest, "Invalid id")
-			return
-		}
-
-		var m Music
-		if err := db.First(&m, id).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.String(http.StatusNotFound, "Music not found")
-				return
-			}
-			log.Printf("DB error when fetching music id=%d: %v", id, err)
-			c.String(http.StatusInternalServerError, "Internal server error:"+err.Error())
-			return
+	// 邀请歌单成员或修改成员的角色（仅创建者）
+	router.POST("/api/playlist/members/set", func(c *gin.Context) {
+		var req struct {
+			PlaylistID int64  `json:"playlistId"`
+			UserID     int64  `json:"userId"`
+			Role       string `json:"role"`
 		}
-
-		go RecordPlayHistory(&m) // 记录
-
-		serveAsset(c, m.AudioURL)
-	})
-
-	// 提供歌词
-	router.GET("/api/music/lyrics/:id", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
-		if err != nil {
-			c.String(http.StatusBadRequest, "Invalid id")
+		if !bindRequest(c, &req) {
 			return
 		}
-
-		var m Music
-		if err := db.First(&m, id).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.String(http.StatusNotFound, "Music not found")
-				return
-			}
-			log.Printf("DB error when fetching music id=%d: %v", id, err)
-			c.String(http.StatusInternalServerError, "Internal server error:"+err.Error())
+		if !requirePlaylistRole(c, req.PlaylistID, PlaylistRoleOwner) {
 			return
 		}
-
-		serveAsset(c, m.LyricsURL)
+		member, err := SetPlaylistMember(req.PlaylistID, currentUserID(c), req.UserID, req.Role)
+		respondPlaylistMember(c, member, err)
 	})
 
-	// 提供歌曲海报
-	router.GET("/api/music/cover/:id", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
-		if err != nil {
-			c.String(http.StatusBadRequest, "Invalid id")
-			return
+	// 移除歌单成员（创建者），或成员自己退出
+	router.POST("/api/playlist/members/remove", func(c *gin.Context) {
+		var req struct {
+			PlaylistID int64 `json:"playlistId"`
+			UserID     int64 `json:"userId"`
 		}
-
-		var m Music
-		if err := db.First(&m, id).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.String(http.StatusNotFound, "Music not found:"+err.Error())
-				return
-			}
-			log.Printf("DB error when fetching music id=%d: %v", id, err)
-			c.String(http.StatusInternalServerError, "Internal server error:"+err.Error())
+		if !bindRequest(c, &req) {
 			return
 		}
-
-		serveAsset(c, m.CoverURL)
-	})
-
-	// 歌曲反馈：喜欢
-	router.GET("/api/music/like/:id", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
-		if err != nil {
-			c.String(http.StatusBadRequest, "Invalid id:"+err.Error())
+		userID := currentUserID(c)
+		if req.UserID == 0 {
+			req.UserID = userID
+		}
+		if req.UserID != userID && !requirePlaylistRole(c, req.PlaylistID, PlaylistRoleOwner) {
 			return
 		}
-
-		// 这里只是示例，实际应用中应记录用户反馈到数据库
-		log.Printf("User like music id=%d", id)
-		c.String(http.StatusOK, "Like")
+		respondPlaylistMember(c, nil, RemovePlaylistMember(req.PlaylistID, userID, req.UserID))
 	})
 
-	// 歌曲反馈：不喜欢
-	router.GET("/api/music/dislike/:id", func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
+	// 歌单动态
+	router.GET("/api/playlist/activity", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Query("playlistId"), 10, 64)
 		if err != nil {
-			c.String(http.StatusBadRequest, "Invalid id:"+err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "歌单ID格式错误",
+				"error":   err.Error(),
+			})
 			return
 		}
-
-		// 这里只是示例，实际应用中应记录用户反馈到数据库
-		log.Printf("User dislike music id=%d", id)
-		c.String(http.StatusOK, "Dislike")
-	})
-
-	// 歌曲反馈：收藏
-	router.GET("/api/music/star/:id", func(c *gin.Context) {
-		idStr := c.Param("id")
-		musicID, err := strconv.ParseInt(idStr, 10, 64)
-		if err != nil {
-			c.String(http.StatusBadRequest, "Invalid id:"+err.Error())
+		if !requirePlaylistRole(c, id, PlaylistRoleViewer) {
 			return
 		}
-
-		// 添加到固定的收藏歌单
-		err = AddSongToPlaylist(StarPlaylistID, musicID)
+		page, pageSize := bindPage(c)
+		list, total, err := GetPlaylistActivities(id, page, pageSize)
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Failed to star music:"+err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询歌单动态失败",
+				"error":   err.Error(),
+			})
 			return
 		}
-		log.Printf("User star music id=%d", musicID)
-		c.String(http.StatusOK, "Star!")
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data": gin.H{
+				"total":    total,
+				"page":     page,
+				"pageSize": pageSize,
+				"list":     list,
+			},
+		})
 	})
 
-	// 歌曲反馈：取消收藏
-	router.GET("/api/music/unstar/:id", func(c *gin.Context) {
-		idStr := c.Param("id")
-		musicID, err := strconv.ParseInt(idStr, 10, 64)
+	// 歌单的修改历史
+	router.GET("/api/playlist/history", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Query("playlistId"), 10, 64)
 		if err != nil {
-			c.String(http.StatusBadRequest, "Invalid id:"+err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "歌单ID格式错误",
+				"error":   err.Error(),
+			})
 			return
 		}
-
-		// 从固定的收藏歌单移除
-		err = RemoveSongFromPlaylist(StarPlaylistID, musicID)
-		if err != nil {
-			c.String(http.StatusInternalServerError, "Failed to unstar music:"+err.Error())
+		if !requirePlaylistRole(c, id, PlaylistRoleViewer) {
 			return
 		}
-		log.Printf("User unstar music id=%d", musicID)
-		c.String(http.StatusOK, "Unstar!")
-	})
-
-	// 查询播放历史记录
-	router.GET("/api/music/play/history", func(c *gin.Context) {
-		history, err := GetPlayHistory(50) // 默认返回最近50条
+		page, pageSize := bindPage(c)
+		list, total, err := GetPlaylistVersions(id, page, pageSize)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"code":    http.StatusInternalServerError,
-				"message": "查询播放记录失败",
+				"message": "查询歌单历史失败",
 				"error":   err.Error(),
 			})
 			return
@@ -476,24 +542,2302 @@ func RegisterRoutes(router *gin.Engine, db *gorm.DB) {
 		c.JSON(http.StatusOK, gin.H{
 			"code":    http.StatusOK,
 			"message": "查询成功",
-			"data":    history,
+			"data": gin.H{
+				"total":    total,
+				"page":     page,
+				"pageSize": pageSize,
+				"list":     list,
+			},
 		})
 	})
-}
-
-// 提供 /music 目录下的文件
-func serveAsset(c *gin.Context, assetPath string) {
-	// URL 解码
-	decodedPath, err := url.PathUnescape(assetPath)
-	if err != nil {
-		c.String(http.StatusBadRequest, "Invalid path")
-		return
-	}
 
-	fullPath := filepath.Join(MusicRoot, filepath.Clean(decodedPath))
+	// 歌单某个历史版本的歌曲
+	router.GET("/api/playlist/history/:version", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Query("playlistId"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "歌单ID格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "版本号格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if !requirePlaylistRole(c, id, PlaylistRoleViewer) {
+			return
+		}
+		v, err := GetPlaylistVersion(id, version)
+		respondPlaylistHistory(c, v, err)
+	})
+
+	// 把歌单回滚到之前的版本
+	router.POST("/api/playlist/rollback", func(c *gin.Context) {
+		var req struct {
+			PlaylistID int64 `json:"playlistId"`
+			Version    int64 `json:"version"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		if !requirePlaylistRole(c, req.PlaylistID, PlaylistRoleEditor) {
+			return
+		}
+		playlist, err := RollbackPlaylist(req.PlaylistID, currentUserID(c), req.Version)
+		respondPlaylistHistory(c, playlist, err)
+	})
+
+	// 歌单运算：合并、交集、差集，结果写入目标歌单或新歌单
+	router.POST("/api/playlist/combine", func(c *gin.Context) {
+		var req struct {
+			Op          string  `json:"op"`
+			PlaylistIDs []int64 `json:"playlistIds"`
+			TargetID    int64   `json:"targetId"`
+			Name        string  `json:"name"`
+			Description string  `json:"description"`
+			DryRun      bool    `json:"dryRun"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		for _, id := range req.PlaylistIDs {
+			if !requirePlaylistRole(c, id, PlaylistRoleViewer) {
+				return
+			}
+		}
+		if req.TargetID != 0 {
+			need := PlaylistRoleEditor
+			if req.DryRun {
+				need = PlaylistRoleViewer
+			}
+			if !requirePlaylistRole(c, req.TargetID, need) {
+				return
+			}
+		}
+		result, err := CombinePlaylists(currentUserID(c), req.Op, req.PlaylistIDs, req.TargetID, req.Name, req.Description, req.DryRun)
+		respondPlaylistOp(c, result, err)
+	})
+
+	// 清理歌单中标题和歌手相同的重复歌曲
+	router.POST("/api/playlist/dedupe", func(c *gin.Context) {
+		var req struct {
+			PlaylistID int64 `json:"playlistId"`
+			DryRun     bool  `json:"dryRun"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		need := PlaylistRoleEditor
+		if req.DryRun {
+			need = PlaylistRoleViewer
+		}
+		if !requirePlaylistRole(c, req.PlaylistID, need) {
+			return
+		}
+		result, err := DedupePlaylist(req.PlaylistID, currentUserID(c), req.DryRun)
+		respondPlaylistOp(c, result, err)
+	})
+
+	// 侧边栏的歌单树：文件夹和歌单
+	router.GET("/api/playlist/tree", func(c *gin.Context) {
+		tree, err := GetPlaylistTree(currentUserID(c))
+		respondFolder(c, tree, err)
+	})
+
+	// 新建文件夹
+	router.POST("/api/playlist/folder/create", func(c *gin.Context) {
+		var req struct {
+			Name     string `json:"name"`
+			ParentID int64  `json:"parentId"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		folder, err := CreateFolder(currentUserID(c), req.ParentID, req.Name)
+		respondFolder(c, folder, err)
+	})
+
+	// 重命名文件夹
+	router.POST("/api/playlist/folder/rename", func(c *gin.Context) {
+		var req struct {
+			FolderID int64  `json:"folderId"`
+			Name     string `json:"name"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		folder, err := RenameFolder(currentUserID(c), req.FolderID, req.Name)
+		respondFolder(c, folder, err)
+	})
+
+	// 移动文件夹或歌单，返回新的歌单树
+	router.POST("/api/playlist/folder/move", func(c *gin.Context) {
+		var req struct {
+			Type     string `json:"type"`
+			ID       int64  `json:"id"`
+			ParentID int64  `json:"parentId"`
+			Position *int   `json:"position"` // 省略时移动到最后
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		position := -1
+		if req.Position != nil {
+			position = *req.Position
+		}
+		tree, err := MoveTreeNode(currentUserID(c), req.Type, req.ID, req.ParentID, position)
+		respondFolder(c, tree, err)
+	})
+
+	// 删除文件夹，其中的内容移到上一级，返回新的歌单树
+	router.POST("/api/playlist/folder/delete", func(c *gin.Context) {
+		var req struct {
+			FolderID int64 `json:"folderId"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		tree, err := DeleteFolder(currentUserID(c), req.FolderID)
+		respondFolder(c, tree, err)
+	})
+
+	// 智能歌单：新建
+	router.POST("/api/playlist/smart/create", func(c *gin.Context) {
+		var req struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			SmartPlaylistDef
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		playlist, err := CreateSmartPlaylist(currentUserID(c), req.Name, req.Description, req.SmartPlaylistDef)
+		respondSmartPlaylist(c, playlist, err)
+	})
+
+	// 智能歌单：修改名称、描述和规则
+	router.POST("/api/playlist/smart/update", func(c *gin.Context) {
+		var req struct {
+			PlaylistID  int64  `json:"playlistId"`
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			SmartPlaylistDef
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		if !requirePlaylistRole(c, req.PlaylistID, PlaylistRoleEditor) {
+			return
+		}
+		playlist, err := UpdateSmartPlaylist(req.PlaylistID, currentUserID(c), req.Name, req.Description, req.SmartPlaylistDef)
+		respondSmartPlaylist(c, playlist, err)
+	})
+
+	// 智能歌单：预览规则的结果，不保存
+	router.POST("/api/playlist/smart/preview", func(c *gin.Context) {
+		var req SmartPlaylistDef
+		if !bindRequest(c, &req) {
+			return
+		}
+		songs, err := PreviewSmartPlaylist(currentUserID(c), req)
+		respondSmartPlaylist(c, songs, err)
+	})
+
+	// 导出歌单为 M3U8、XSPF 或 JSON 文件
+	router.GET("/api/playlist/export/:id", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "歌单ID格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if !requirePlaylistRole(c, id, PlaylistRoleViewer) {
+			return
+		}
+		format := c.DefaultQuery("format", PlaylistFormatM3U8)
+		location := c.DefaultQuery("location", PlaylistLocationPath)
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+			scheme = proto
+		}
+		file, err := ExportPlaylist(id, format, location, scheme+"://"+c.Request.Host)
+		if errors.Is(err, ErrUnknownPlaylistFormat) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "不支持的歌单格式",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "导出歌单失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+		c.Data(http.StatusOK, file.ContentType, file.Data)
+	})
+
+	// 从 M3U8、XSPF 或 JSON 文件导入歌单
+	// 以 multipart 上传 file，或直接把文件内容作为请求体
+	router.POST("/api/playlist/import", func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPlaylistImportSize)
+
+		var file *ImportFile
+		if fh, err := c.FormFile("file"); err == nil {
+			if f, err := fh.Open(); err == nil {
+				data, err := io.ReadAll(f)
+				f.Close()
+				if err == nil {
+					file = &ImportFile{Name: fh.Filename, Data: data}
+				}
+			}
+		} else if data, err := c.GetRawData(); err == nil && len(data) > 0 {
+			file = &ImportFile{Name: "body", Data: data}
+		}
+		if file == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请上传歌单文件",
+			})
+			return
+		}
+
+		dryRun := c.Query("dryRun") == "true"
+		result, err := ImportPlaylist(currentUserID(c), *file, c.Query("format"), c.Query("name"), dryRun)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "导入歌单失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "导入成功",
+			"data":    result,
+		})
+	})
+
+	// 分享：创建歌单或歌曲的分享链接
+	router.POST("/api/share/create", func(c *gin.Context) {
+		var req struct {
+			Kind      string     `json:"kind"`
+			TargetID  int64      `json:"targetId"`
+			ExpiresAt *time.Time `json:"expiresAt"`
+			ExpiresIn int64      `json:"expiresIn"` // 有效期（秒），expiresAt 为空时使用
+			MaxAccess *int64     `json:"maxAccess"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		if req.ExpiresAt == nil && req.ExpiresIn > 0 {
+			t := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+			req.ExpiresAt = &t
+		}
+		link, err := CreateShareLink(currentUserID(c), req.Kind, req.TargetID, req.ExpiresAt, req.MaxAccess)
+		respondShare(c, link, err)
+	})
+
+	// 分享：当前用户创建的分享链接
+	router.GET("/api/share/list", func(c *gin.Context) {
+		links, err := GetShareLinks(currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询分享链接失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    links,
+		})
+	})
+
+	// 分享：撤销分享链接
+	router.POST("/api/share/revoke", func(c *gin.Context) {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		link, err := RevokeShareLink(currentUserID(c), req.Token)
+		respondShare(c, link, err)
+	})
+
+	// 分享：打开分享链接（无需登录，只读）
+	router.GET("/api/share/:token", func(c *gin.Context) {
+		view, err := OpenShareLink(c.Param("token"))
+		respondShare(c, view, err)
+	})
+
+	// 分享：播放分享的歌曲（无需登录，不记录播放历史）
+	router.GET("/api/share/:token/play/:musicId", func(c *gin.Context) {
+		musicID, err := strconv.ParseInt(c.Param("musicId"), 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid id")
+			return
+		}
+		m, err := GetSharedMusic(c.Param("token"), musicID)
+		switch {
+		case errors.Is(err, ErrShareNotFound):
+			c.String(http.StatusNotFound, "Music not found")
+		case errors.Is(err, ErrShareExpired), errors.Is(err, ErrShareRevoked), errors.Is(err, ErrShareExhausted):
+			c.String(http.StatusGone, err.Error())
+		case err != nil:
+			c.String(http.StatusInternalServerError, "Internal server error:"+err.Error())
+		default:
+			serveAsset(c, m.AudioURL)
+		}
+	})
+
+	// 根据标签检索音乐
+	router.POST("/api/music/search/labels", func(c *gin.Context) {
+		var req struct {
+			Labels []string `json:"labels"`
+			Limit  *int     `json:"limit"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请求参数格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		numLimit := 20 // 默认限制20首
+		if req.Limit != nil {
+			numLimit = *req.Limit
+		}
+
+		songs, err := SearchMusicByLabels(req.Labels, numLimit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "根据标签检索音乐失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "检索成功",
+			"data":    explainLabelSearch(songs, req.Labels),
+		})
+	})
+
+	// 搜索框：模糊搜索音乐
+	router.GET("/api/music/search/text/:txt", func(c *gin.Context) {
+		queryStr := c.Param("txt")
+		songs, err := SearchMusicByText(queryStr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "模糊搜索音乐失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "搜索成功",
+			"data":    songs,
+			"total":   len(songs),
+		})
+	})
+
+	// AI对话式生成歌单
+	router.POST("/api/ai-recommend", func(c *gin.Context) {
+		var req struct {
+			Message string `json:"message"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "参数解析错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		// call LLM
+		reply, err := AgentWorkflow(req.Message, currentUserID(c))
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "大模型推荐失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "检索成功",
+			"data":    reply,
+		})
+	})
+
+	// 每日推荐：查询当前用户的推荐歌单，还没有生成过时立即生成
+	router.GET("/api/recommend/daily", func(c *gin.Context) {
+		userID := currentUserID(c)
+		mixes, err := GetDailyMixes(userID)
+		if err == nil && len(mixes) == 0 {
+			mixes, err = RefreshDailyMixes(userID)
+		}
+		var result []DailyMixWithReasons
+		if err == nil {
+			result, err = ExplainDailyMixes(userID, mixes)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询每日推荐失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    result,
+		})
+	})
+
+	// 此刻推荐：结合时段、活动和天气
+	router.GET("/api/recommend/now", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		result, err := RecommendNow(currentUserID(c), c.Query("activity"), c.Query("weather"), limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "推荐失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "推荐成功",
+			"data":    result,
+		})
+	})
+
+	// 每日推荐：立即重新生成
+	router.POST("/api/recommend/daily/refresh", func(c *gin.Context) {
+		userID := currentUserID(c)
+		mixes, err := RefreshDailyMixes(userID)
+		var result []DailyMixWithReasons
+		if err == nil {
+			result, err = ExplainDailyMixes(userID, mixes)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "生成每日推荐失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "生成成功",
+			"data":    result,
+		})
+	})
+
+	// 相似歌曲
+	router.GET("/api/music/similar/:id", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "歌曲ID格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		limit := 20
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= similarTopK {
+			limit = l
+		}
+		if _, err := GetMusicByID(id); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "歌曲不存在",
+				"error":   err.Error(),
+			})
+			return
+		}
+		list, err := GetSimilarRecommendations(id, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询相似歌曲失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    list,
+		})
+	})
+
+	// 电台：当前用户的电台列表
+	router.GET("/api/radio", func(c *gin.Context) {
+		stations, err := GetRadioStations(currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询电台失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    stations,
+		})
+	})
+
+	// 电台：从歌曲、歌手、标签或歌单创建电台，并返回第一批歌曲
+	router.POST("/api/radio/start", func(c *gin.Context) {
+		var req struct {
+			RadioSeed
+			Count int `json:"count"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请求参数格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		userID := currentUserID(c)
+		station, err := StartRadio(userID, req.RadioSeed)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "创建电台失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		tracks, err := NextRadioTracks(userID, station.ID, req.Count)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "获取电台歌曲失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "创建成功",
+			"data": gin.H{
+				"station": station,
+				"tracks":  tracks,
+			},
+		})
+	})
+
+	// 电台：查询电台状态，用于在其他设备上继续收听
+	router.GET("/api/radio/:id", func(c *gin.Context) {
+		id, ok := bindRadioID(c)
+		if !ok {
+			return
+		}
+		station, err := GetRadioStation(currentUserID(c), id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "电台不存在",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    station,
+		})
+	})
+
+	// 电台：获取接下来的歌曲
+	router.GET("/api/radio/:id/next", func(c *gin.Context) {
+		id, ok := bindRadioID(c)
+		if !ok {
+			return
+		}
+		count, _ := strconv.Atoi(c.Query("count"))
+		tracks, err := NextRadioTracks(currentUserID(c), id, count)
+		if errors.Is(err, ErrRadioNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "电台不存在",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "获取电台歌曲失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    tracks,
+		})
+	})
+
+	// 电台：喜欢 / 跳过 / 不喜欢，影响之后推送的歌曲
+	router.POST("/api/radio/:id/feedback", func(c *gin.Context) {
+		id, ok := bindRadioID(c)
+		if !ok {
+			return
+		}
+		var req struct {
+			MusicID int64  `json:"musicId"`
+			Action  string `json:"action"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请求参数格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		station, err := RadioFeedback(currentUserID(c), id, req.MusicID, req.Action)
+		if errors.Is(err, ErrRadioNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "电台不存在",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "电台反馈失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "反馈成功",
+			"data":    station,
+		})
+	})
+
+	// 电台：删除
+	router.POST("/api/radio/delete", func(c *gin.Context) {
+		var req struct {
+			StationID int64 `json:"stationId"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请求参数格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if err := DeleteRadioStation(currentUserID(c), req.StationID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "删除电台失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "删除成功",
+		})
+	})
+
+	// 播放队列：查询当前设备的队列
+	router.GET("/api/queue", func(c *gin.Context) {
+		state, err := GetPlayQueue(currentUserID(c), currentDeviceID(c))
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：当前用户所有设备上的队列
+	router.GET("/api/queue/devices", func(c *gin.Context) {
+		queues, err := GetPlayQueues(currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询播放队列失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    queues,
+		})
+	})
+
+	// 播放队列：用歌单、搜索结果、推荐结果或指定的歌曲替换队列
+	router.POST("/api/queue/set", func(c *gin.Context) {
+		var req QueueSource
+		if !bindRequest(c, &req) {
+			return
+		}
+		userID := currentUserID(c)
+		ids, err := ResolveQueueSource(userID, req)
+		if err != nil {
+			respondQueue(c, nil, err)
+			return
+		}
+		state, err := SetPlayQueue(userID, currentDeviceID(c), ids, req.StartIndex)
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：追加到末尾
+	router.POST("/api/queue/append", func(c *gin.Context) {
+		var req struct {
+			MusicIDs []int64 `json:"musicIds"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		ids, err := ResolveQueueSource(0, QueueSource{Type: QueueSourceTracks, MusicIDs: req.MusicIDs})
+		if err != nil {
+			respondQueue(c, nil, err)
+			return
+		}
+		state, err := AppendToQueue(currentUserID(c), currentDeviceID(c), ids)
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：插入为下一首
+	router.POST("/api/queue/insert-next", func(c *gin.Context) {
+		var req struct {
+			MusicIDs []int64 `json:"musicIds"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		ids, err := ResolveQueueSource(0, QueueSource{Type: QueueSourceTracks, MusicIDs: req.MusicIDs})
+		if err != nil {
+			respondQueue(c, nil, err)
+			return
+		}
+		state, err := InsertNextInQueue(currentUserID(c), currentDeviceID(c), ids)
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：移除条目
+	router.POST("/api/queue/remove", func(c *gin.Context) {
+		var req struct {
+			ItemIDs []int64 `json:"itemIds"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		state, err := RemoveFromQueue(currentUserID(c), currentDeviceID(c), req.ItemIDs)
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：移动条目
+	router.POST("/api/queue/move", func(c *gin.Context) {
+		var req struct {
+			ItemID int64 `json:"itemId"`
+			To     int   `json:"to"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		state, err := MoveInQueue(currentUserID(c), currentDeviceID(c), req.ItemID, req.To)
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：清空
+	router.POST("/api/queue/clear", func(c *gin.Context) {
+		state, err := ClearQueue(currentUserID(c), currentDeviceID(c))
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：随机播放和循环模式
+	router.POST("/api/queue/mode", func(c *gin.Context) {
+		var req struct {
+			Shuffle *bool   `json:"shuffle"`
+			Seed    *int64  `json:"seed"`
+			Repeat  *string `json:"repeat"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		state, err := SetQueueMode(currentUserID(c), currentDeviceID(c), req.Shuffle, req.Seed, req.Repeat)
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：下一首，auto 为 true 表示当前歌曲自然播放结束
+	router.POST("/api/queue/next", func(c *gin.Context) {
+		var req struct {
+			Auto bool `json:"auto"`
+		}
+		// 请求体可以为空
+		_ = c.ShouldBindJSON(&req)
+		state, err := NextInQueue(currentUserID(c), currentDeviceID(c), req.Auto)
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：上一首
+	router.POST("/api/queue/previous", func(c *gin.Context) {
+		state, err := PreviousInQueue(currentUserID(c), currentDeviceID(c))
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：跳到指定条目
+	router.POST("/api/queue/jump", func(c *gin.Context) {
+		var req struct {
+			ItemID int64 `json:"itemId"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		state, err := JumpInQueue(currentUserID(c), currentDeviceID(c), req.ItemID)
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：上报播放进度
+	router.POST("/api/queue/position", func(c *gin.Context) {
+		var req struct {
+			ItemID     int64 `json:"itemId"`
+			PositionMs int64 `json:"positionMs"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		state, err := SetQueuePosition(currentUserID(c), currentDeviceID(c), req.ItemID, req.PositionMs)
+		respondQueue(c, state, err)
+	})
+
+	// 播放队列：在当前设备上继续另一台设备的队列
+	router.POST("/api/queue/copy", func(c *gin.Context) {
+		var req struct {
+			FromDeviceID string `json:"fromDeviceId"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		state, err := CopyPlayQueue(currentUserID(c), req.FromDeviceID, currentDeviceID(c))
+		respondQueue(c, state, err)
+	})
+
+	// 多设备：WebSocket 连接，每个连接注册为一个设备
+	router.GET("/api/devices/ws", func(c *gin.Context) {
+		info := DeviceInfo{DeviceID: currentDeviceID(c), Name: c.Query("name"), Type: c.Query("type")}
+		if info.Name == "" {
+			info.Name = info.DeviceID
+		}
+		ServeDeviceSocket(c.Writer, c.Request, currentUserID(c), info)
+	})
+
+	// 多设备：当前用户的在线设备及其播放状态
+	router.GET("/api/devices", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    Devices.List(currentUserID(c)),
+		})
+	})
+
+	// 多设备：向其他设备发送控制命令（没有 WebSocket 连接的客户端使用）
+	router.POST("/api/devices/command", func(c *gin.Context) {
+		var req DeviceCommand
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请求参数格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		err := Devices.SendCommand(currentUserID(c), currentDeviceID(c), req)
+		if errors.Is(err, ErrDeviceOffline) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "设备不在线",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, ErrUnknownCommand) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "未知的命令",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "发送命令失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "发送成功",
+		})
+	})
+
+	// 一起听：创建房间，创建者为主持人
+	router.POST("/api/rooms", func(c *gin.Context) {
+		var req struct {
+			Name       string `json:"name"`
+			MemberName string `json:"memberName"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		respondRoom(c, CreateRoom(currentUserID(c), req.Name, req.MemberName), nil)
+	})
+
+	// 一起听：服务端时间，供客户端估算时钟偏差
+	router.GET("/api/rooms/time", func(c *gin.Context) {
+		clientTime, _ := strconv.ParseInt(c.Query("clientTime"), 10, 64)
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    gin.H{"clientTime": clientTime, "serverTime": nowMs()},
+		})
+	})
+
+	// 一起听：用邀请码加入房间
+	router.POST("/api/rooms/join", func(c *gin.Context) {
+		var req struct {
+			Code       string `json:"code" binding:"required"`
+			MemberName string `json:"memberName"`
+		}
+		if !bindRequest(c, &req) {
+			return
+		}
+		room, err := JoinRoom(req.Code, currentUserID(c), req.MemberName)
+		respondRoom(c, room, err)
+	})
+
+	// 一起听：查询房间状态
+	router.GET("/api/rooms/:code", func(c *gin.Context) {
+		room, err := GetRoom(c.Param("code"))
+		respondRoom(c, room, err)
+	})
+
+	// 一起听：离开房间
+	router.POST("/api/rooms/:code/leave", func(c *gin.Context) {
+		respondRoom(c, nil, LeaveRoom(c.Param("code"), currentUserID(c)))
+	})
+
+	// 一起听：执行操作（播放控制、推荐、投票、转交主持人）
+	router.POST("/api/rooms/:code/action", func(c *gin.Context) {
+		var req RoomAction
+		if !bindRequest(c, &req) {
+			return
+		}
+		room, err := RoomAct(c.Param("code"), currentUserID(c), req)
+		respondRoom(c, room, err)
+	})
+
+	// 一起听：WebSocket 连接，接收房间状态和播放进度，未加入时自动加入
+	router.GET("/api/rooms/:code/ws", func(c *gin.Context) {
+		ServeRoomSocket(c.Writer, c.Request, c.Param("code"), currentUserID(c), c.Query("name"))
+	})
+
+	// 播放音乐
+	router.GET("/api/music/play/:id", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		var m Music
+		if err := db.First(&m, id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.String(http.StatusNotFound, "Music not found")
+				return
+			}
+			log.Printf("DB error when fetching music id=%d: %v", id, err)
+			c.String(http.StatusInternalServerError, "Internal server error:"+err.Error())
+			return
+		}
+
+		// 只有从头开始的请求才记录，拖动进度产生的 Range 请求不算新的播放
+		if isInitialRangeRequest(c.Request) {
+			go RecordPlayHistory(&m, currentUserID(c), sessionID(c))
+		}
+
+		serveAsset(c, m.AudioURL)
+	})
+
+	// 上报播放事件：开始、进度心跳、结束
+	router.POST("/api/music/play/event", func(c *gin.Context) {
+		var req PlayEvent
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请求参数格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		play, err := ReportPlayEvent(req, currentUserID(c), sessionID(c))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "上报播放事件失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if play == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"code":    http.StatusOK,
+				"message": "隐私收听中，未记录",
+				"data":    nil,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "上报成功",
+			"data":    play,
+		})
+	})
+
+	// HLS 流式播放：播放列表与分片
+	router.GET("/api/music/hls/:id/:file", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid id")
+			return
+		}
+		file := c.Param("file")
+		if file != hlsPlaylistName && !hlsSegmentPattern.MatchString(file) {
+			c.String(http.StatusNotFound, "Segment not found")
+			return
+		}
+
+		var m Music
+		if err := db.First(&m, id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.String(http.StatusNotFound, "Music not found")
+				return
+			}
+			log.Printf("DB error when fetching music id=%d: %v", id, err)
+			c.String(http.StatusInternalServerError, "Internal server error:"+err.Error())
+			return
+		}
+
+		dir, err := PrepareHLS(&m)
+		if err != nil {
+			if err == ErrHLSUnsupported {
+				// 客户端应回退到 /api/music/play/:id
+				c.String(http.StatusUnsupportedMediaType, "HLS not available for this track")
+				return
+			}
+			log.Printf("failed to prepare hls for music id=%d: %v", id, err)
+			c.String(http.StatusInternalServerError, "Internal server error:"+err.Error())
+			return
+		}
+
+		if file == hlsPlaylistName {
+			// 每次播放只拉取一次播放列表，分片请求不再重复记录
+			go RecordPlayHistory(&m, currentUserID(c), sessionID(c))
+			c.Header("Content-Type", "application/vnd.apple.mpegurl")
+		} else {
+			c.Header("Content-Type", "audio/mpeg")
+		}
+		http.ServeFile(c.Writer, c.Request, filepath.Join(dir, file))
+	})
+
+	// 提供歌词
+	router.GET("/api/music/lyrics/:id", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		var m Music
+		if err := db.First(&m, id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.String(http.StatusNotFound, "Music not found")
+				return
+			}
+			log.Printf("DB error when fetching music id=%d: %v", id, err)
+			c.String(http.StatusInternalServerError, "Internal server error:"+err.Error())
+			return
+		}
+
+		serveAsset(c, m.LyricsURL)
+	})
+
+	// 提供歌曲海报
+	router.GET("/api/music/cover/:id", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		var m Music
+		if err := db.First(&m, id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.String(http.StatusNotFound, "Music not found:"+err.Error())
+				return
+			}
+			log.Printf("DB error when fetching music id=%d: %v", id, err)
+			c.String(http.StatusInternalServerError, "Internal server error:"+err.Error())
+			return
+		}
+
+		serveAsset(c, m.CoverURL)
+	})
+
+	// 提供歌曲波形（用于进度条可视化）
+	router.GET("/api/music/waveform/:id", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "歌曲ID格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		points := 0
+		if p := c.Query("points"); p != "" {
+			if points, err = strconv.Atoi(p); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"code":    http.StatusBadRequest,
+					"message": "分辨率格式错误",
+					"error":   err.Error(),
+				})
+				return
+			}
+		}
+
+		music, err := GetMusicByID(id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "歌曲不存在",
+				"error":   err.Error(),
+			})
+			return
+		}
+		waveform, err := GetMusicWaveform(music, points)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrUnsupportedAudio) {
+				status = http.StatusUnsupportedMediaType
+			}
+			c.JSON(status, gin.H{
+				"code":    status,
+				"message": "生成波形失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    waveform,
+		})
+	})
+
+	// 歌曲反馈：喜欢
+	router.GET("/api/music/like/:id", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid id:"+err.Error())
+			return
+		}
+
+		if err := SetMusicFeedback(currentUserID(c), id, FeedbackLike); err != nil {
+			c.String(http.StatusInternalServerError, "Failed to like music:"+err.Error())
+			return
+		}
+		log.Printf("User like music id=%d", id)
+		c.String(http.StatusOK, "Like")
+	})
+
+	// 歌曲反馈：不喜欢
+	router.GET("/api/music/dislike/:id", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid id:"+err.Error())
+			return
+		}
+
+		if err := SetMusicFeedback(currentUserID(c), id, FeedbackDislike); err != nil {
+			c.String(http.StatusInternalServerError, "Failed to dislike music:"+err.Error())
+			return
+		}
+		log.Printf("User dislike music id=%d", id)
+		c.String(http.StatusOK, "Dislike")
+	})
+
+	// 歌曲反馈：收藏
+	router.GET("/api/music/star/:id", func(c *gin.Context) {
+		idStr := c.Param("id")
+		musicID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid id:"+err.Error())
+			return
+		}
+
+		// 添加到当前用户的收藏歌单
+		userID := currentUserID(c)
+		favorites, err := GetFavoritesPlaylist(userID)
+		if err == nil {
+			err = AddSongToPlaylist(favorites.ID, musicID, userID)
+		}
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Failed to star music:"+err.Error())
+			return
+		}
+		log.Printf("User star music id=%d", musicID)
+		c.String(http.StatusOK, "Star!")
+	})
+
+	// 歌曲反馈：取消收藏
+	router.GET("/api/music/unstar/:id", func(c *gin.Context) {
+		idStr := c.Param("id")
+		musicID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid id:"+err.Error())
+			return
+		}
+
+		// 从当前用户的收藏歌单移除
+		userID := currentUserID(c)
+		favorites, err := GetFavoritesPlaylist(userID)
+		if err == nil {
+			err = RemoveSongFromPlaylist(favorites.ID, musicID, userID)
+		}
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Failed to unstar music:"+err.Error())
+			return
+		}
+		log.Printf("User unstar music id=%d", musicID)
+		c.String(http.StatusOK, "Unstar!")
+	})
+
+	// 查询播放历史记录
+	router.GET("/api/music/play/history", func(c *gin.Context) {
+		limit := 50 // 默认返回最近50首
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+			limit = l
+		}
+		history, err := GetPlayHistory(currentUserID(c), limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询播放记录失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    history,
+		})
+	})
+
+	// 响度分析：重新分析单首歌曲或整个曲库
+	router.POST("/api/music/loudness/analyze", func(c *gin.Context) {
+		var req struct {
+			MusicID int64 `json:"musicId"`
+			All     bool  `json:"all"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请求参数格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		// 全库分析耗时较长，放到后台执行
+		if req.All {
+			if err := StartLibraryLoudnessAnalysis(true); err != nil {
+				c.JSON(http.StatusConflict, gin.H{
+					"code":    http.StatusConflict,
+					"message": "已有响度分析任务在运行",
+					"error":   err.Error(),
+				})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"code":    http.StatusOK,
+				"message": "已开始重新分析全部歌曲",
+			})
+			return
+		}
+
+		if err := AnalyzeMusicLoudness([]int64{req.MusicID}); err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, ErrLoudnessBusy):
+				status = http.StatusConflict
+			case errors.Is(err, ErrUnsupportedAudio):
+				status = http.StatusUnsupportedMediaType
+			case errors.Is(err, ErrAudioUndecodable):
+				status = http.StatusUnprocessableEntity
+			}
+			c.JSON(status, gin.H{
+				"code":    status,
+				"message": "响度分析失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		music, err := GetMusicByID(req.MusicID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询歌曲失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "分析完成",
+			"data":    music,
+		})
+	})
+
+	// 播放历史：逐条时间线
+	router.GET("/api/history/timeline", func(c *gin.Context) {
+		r, ok := bindStatsRange(c)
+		if !ok {
+			return
+		}
+		page, pageSize := bindPage(c)
+		entries, total, err := GetPlayTimeline(r, page, pageSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询播放记录失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data": gin.H{
+				"total":    total,
+				"page":     page,
+				"pageSize": pageSize,
+				"list":     entries,
+			},
+		})
+	})
+
+	// 播放历史：按歌曲汇总播放次数
+	router.GET("/api/history/tracks", func(c *gin.Context) {
+		r, ok := bindStatsRange(c)
+		if !ok {
+			return
+		}
+		page, pageSize := bindPage(c)
+		entries, total, err := GetTrackHistory(r, page, pageSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询播放记录失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data": gin.H{
+				"total":    total,
+				"page":     page,
+				"pageSize": pageSize,
+				"list":     entries,
+			},
+		})
+	})
+
+	// 播放历史：删除单条记录或一段时间内的记录
+	router.POST("/api/history/delete", func(c *gin.Context) {
+		var req struct {
+			IDs  []int64 `json:"ids"`
+			From string  `json:"from"`
+			To   string  `json:"to"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请求参数格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		var deleted int64
+		var err error
+		if len(req.IDs) > 0 {
+			deleted, err = DeletePlayHistory(currentUserID(c), req.IDs)
+		} else {
+			var r StatsRange
+			if r, err = ParseStatsRange(req.From, req.To); err == nil {
+				r.UserID = currentUserID(c)
+				deleted, err = DeletePlayHistoryRange(r)
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "删除播放记录失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "删除成功",
+			"data": gin.H{
+				"deleted": deleted,
+			},
+		})
+	})
+
+	// 播放历史：导入 Spotify、Last.fm、ListenBrainz 的收听记录
+	// 以 multipart 上传一个或多个 file，或直接把文件内容作为请求体
+	router.POST("/api/history/import", func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxHistoryImportSize)
+
+		// 上传的文件超过 gin 的 MaxMultipartMemory 时保存在临时文件中，请求体也先写入临时文件，解析时按需读取
+		var files []ListenExportFile
+		if form, err := c.MultipartForm(); err == nil {
+			defer form.RemoveAll()
+			for _, fh := range form.File["file"] {
+				f, err := fh.Open()
+				if err != nil {
+					continue
+				}
+				defer f.Close()
+				files = append(files, ListenExportFile{Name: fh.Filename, Data: f, Size: fh.Size})
+			}
+		} else if tmp, err := os.CreateTemp("", "nmp-history-*"); err == nil {
+			defer os.Remove(tmp.Name())
+			defer tmp.Close()
+			if size, err := io.Copy(tmp, c.Request.Body); err == nil && size > 0 {
+				files = append(files, ListenExportFile{Name: "body", Data: tmp, Size: size})
+			}
+		}
+		if len(files) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请上传收听记录文件",
+			})
+			return
+		}
+
+		minPlayedMs := int64(defaultImportMinPlayedMs)
+		if v, err := strconv.ParseInt(c.Query("minPlayedMs"), 10, 64); err == nil && v >= 0 {
+			minPlayedMs = v
+		}
+		dryRun := c.Query("dryRun") == "true"
+
+		result, err := ImportPlayHistory(currentUserID(c), files, minPlayedMs, dryRun)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "导入收听记录失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "导入成功",
+			"data":    result,
+		})
+	})
+
+	// 隐私收听：查询当前会话状态
+	router.GET("/api/history/private", func(c *gin.Context) {
+		sid := sessionID(c)
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data": gin.H{
+				"sessionId": sid,
+				"enabled":   IsPrivateSession(sid),
+			},
+		})
+	})
+
+	// 隐私收听：开启后本会话的播放不计入历史、统计和推荐
+	router.POST("/api/history/private", func(c *gin.Context) {
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请求参数格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		sid := sessionID(c)
+		SetPrivateSession(sid, req.Enabled)
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "设置成功",
+			"data": gin.H{
+				"sessionId": sid,
+				"enabled":   req.Enabled,
+			},
+		})
+	})
+
+	// 收听上报：配置与队列状态
+	router.GET("/api/scrobble/status", func(c *gin.Context) {
+		status, err := GetScrobbleStatus()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询上报状态失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    status,
+		})
+	})
+
+	// 收听上报：立即上报队列中的记录
+	router.POST("/api/scrobble/flush", func(c *gin.Context) {
+		if err := FlushScrobbles(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "上报失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "已开始上报",
+		})
+	})
+
+	// 收听上报：重试失败的记录
+	router.POST("/api/scrobble/retry", func(c *gin.Context) {
+		n, err := RetryFailedScrobbles()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "重试失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "已重新加入队列",
+			"data": gin.H{
+				"retried": n,
+			},
+		})
+	})
+
+	// 收听上报：导出为 ListenBrainz / Last.fm 兼容文件
+	router.GET("/api/scrobble/export", func(c *gin.Context) {
+		r, ok := bindStatsRange(c)
+		if !ok {
+			return
+		}
+		service := c.DefaultQuery("service", ScrobbleListenBrainz)
+		format := c.DefaultQuery("format", "json")
+		if (service != ScrobbleListenBrainz && service != ScrobbleLastFM) || (format != "json" && format != "csv") {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "不支持的导出格式",
+				"error":   "service must be listenbrainz or lastfm, format must be json or csv",
+			})
+			return
+		}
+
+		// 默认只导出达到上报门槛的收听，all=true 时导出全部播放记录
+		listens, err := ListListens(r, c.Query("all") != "true")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "导出失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		contentType := "application/json"
+		if format == "csv" {
+			contentType = "text/csv; charset=utf-8"
+		}
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="nmp-listens-%s.%s"`, service, format))
+		if err := ExportListens(c.Writer, listens, service, format); err != nil {
+			log.Printf("failed to export listens: %v", err)
+		}
+	})
 
-	if !strings.HasPrefix(fullPath, MusicRoot) {
-		c.String(http.StatusForbidden, "Forbidden")
+	// 收听统计：概况
+	router.GET("/api/stats/summary", func(c *gin.Context) {
+		r, ok := bindStatsRange(c)
+		if !ok {
+			return
+		}
+		summary, err := GetListeningSummary(r)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询收听统计失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    summary,
+		})
+	})
+
+	// 收听统计：歌曲、歌手、标签排行
+	router.GET("/api/stats/top/:kind", func(c *gin.Context) {
+		r, ok := bindStatsRange(c)
+		if !ok {
+			return
+		}
+		limit := 10
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+
+		var data interface{}
+		var err error
+		switch c.Param("kind") {
+		case "tracks":
+			data, err = GetTopTracks(r, limit)
+		case "singers":
+			data, err = GetTopSingers(r, limit)
+		case "labels":
+			data, err = GetTopLabels(r, limit)
+		default:
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    http.StatusNotFound,
+				"message": "不支持的排行类型",
+				"error":   "kind must be tracks, singers or labels",
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询排行失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    data,
+		})
+	})
+
+	// 收听统计：按小时、星期分布
+	router.GET("/api/stats/distribution", func(c *gin.Context) {
+		r, ok := bindStatsRange(c)
+		if !ok {
+			return
+		}
+		dist, err := GetListeningDistribution(r)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询收听分布失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    dist,
+		})
+	})
+
+	// 收听统计：连续收听天数
+	router.GET("/api/stats/streaks", func(c *gin.Context) {
+		r, ok := bindStatsRange(c)
+		if !ok {
+			return
+		}
+		streaks, err := GetListeningStreaks(r)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询连续收听天数失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    streaks,
+		})
+	})
+
+	// 收听统计：年度报告
+	router.GET("/api/stats/year/:year", func(c *gin.Context) {
+		year, err := strconv.Atoi(c.Param("year"))
+		if err != nil || year < 1970 || year > 9999 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "年份格式错误",
+				"error":   "invalid year: " + c.Param("year"),
+			})
+			return
+		}
+		review, err := GetYearInReview(currentUserID(c), year)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "生成年度报告失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data":    review,
+		})
+	})
+
+	// 管理：重新计算全部相似歌曲
+	router.POST("/api/admin/similarity/rebuild", func(c *gin.Context) {
+		if err := RefreshSimilarity(true); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "重新计算相似歌曲失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "计算完成",
+		})
+	})
+
+	// 管理：查询内容重复的歌曲
+	router.GET("/api/admin/music/duplicates", func(c *gin.Context) {
+		groups, err := FindDuplicateMusic()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "查询重复歌曲失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "查询成功",
+			"data": gin.H{
+				"total": len(groups),
+				"list":  groups,
+			},
+		})
+	})
+
+	// 管理：合并重复歌曲
+	router.POST("/api/admin/music/merge", func(c *gin.Context) {
+		var req struct {
+			KeepID   int64   `json:"keepId"`
+			MergeIDs []int64 `json:"mergeIds"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": "请求参数格式错误",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if err := MergeMusic(req.KeepID, req.MergeIDs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": "合并歌曲失败",
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "合并成功",
+		})
+	})
+}
+
+// 解析统计接口的 from/to 查询参数并限定为当前用户，格式错误时直接返回 400
+func bindStatsRange(c *gin.Context) (StatsRange, bool) {
+	r, err := ParseStatsRange(c.Query("from"), c.Query("to"))
+	r.UserID = currentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "时间范围格式错误",
+			"error":   err.Error(),
+		})
+		return r, false
+	}
+	return r, true
+}
+
+// 解析路径中的电台 ID，格式错误时直接返回 400
+func bindRadioID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "电台ID格式错误",
+			"error":   err.Error(),
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// 解析 JSON 请求体，格式错误时直接返回 400
+func bindRequest(c *gin.Context, req any) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "请求参数格式错误",
+			"error":   err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// 返回播放队列操作的结果
+func respondQueue(c *gin.Context, state *QueueState, err error) {
+	switch {
+	case errors.Is(err, ErrQueueItemNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "队列中没有该条目",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrInvalidQueue):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "播放队列操作失败",
+			"error":   err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "播放队列操作失败",
+			"error":   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "操作成功",
+			"data":    state,
+		})
+	}
+}
+
+// 检查当前用户在歌单中至少具有 need 角色，没有权限时直接返回 403
+func requirePlaylistRole(c *gin.Context, playlistID int64, need string) bool {
+	err := RequirePlaylistRole(playlistID, currentUserID(c), need)
+	switch {
+	case errors.Is(err, ErrPlaylistNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "歌单不存在",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrPlaylistForbidden):
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "没有权限",
+			"error":   err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "查询歌单权限失败",
+			"error":   err.Error(),
+		})
+	default:
+		return true
+	}
+	return false
+}
+
+// 返回歌单成员操作的结果
+func respondPlaylistMember(c *gin.Context, member *PlaylistMember, err error) {
+	switch {
+	case errors.Is(err, ErrPlaylistNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "歌单不存在",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrPlaylistReadOnly):
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "系统生成的歌单不能修改",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrInvalidMember):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "歌单成员操作失败",
+			"error":   err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "歌单成员操作失败",
+			"error":   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "操作成功",
+			"data":    member,
+		})
+	}
+}
+
+// 返回歌单修改历史和回收站操作的结果
+func respondPlaylistHistory(c *gin.Context, data any, err error) {
+	switch {
+	case errors.Is(err, ErrPlaylistNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "歌单不存在",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrPlaylistVersionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "歌单版本不存在",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrPlaylistForbidden):
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "没有权限",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrPlaylistReadOnly):
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "系统生成的歌单不能修改",
+			"error":   err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "歌单操作失败",
+			"error":   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "操作成功",
+			"data":    data,
+		})
+	}
+}
+
+// 返回歌单运算和清理重复歌曲的结果
+func respondPlaylistOp(c *gin.Context, data any, err error) {
+	switch {
+	case errors.Is(err, ErrPlaylistNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "歌单不存在",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrPlaylistReadOnly):
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "系统生成的歌单不能修改",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrPlaylistConflict):
+		c.JSON(http.StatusConflict, gin.H{
+			"code":    http.StatusConflict,
+			"message": "歌单已被其他人修改，请刷新后重试",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrInvalidPlaylistOp):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "请求参数格式错误",
+			"error":   err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "歌单操作失败",
+			"error":   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "操作成功",
+			"data":    data,
+		})
+	}
+}
+
+// 返回歌单文件夹操作的结果
+func respondFolder(c *gin.Context, data any, err error) {
+	switch {
+	case errors.Is(err, ErrFolderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "文件夹不存在",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrPlaylistNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "歌单不存在",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrInvalidFolder), errors.Is(err, ErrInvalidFolderMove):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "请求参数格式错误",
+			"error":   err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "文件夹操作失败",
+			"error":   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "操作成功",
+			"data":    data,
+		})
+	}
+}
+
+// 返回智能歌单接口的结果
+func respondSmartPlaylist(c *gin.Context, data any, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidSmartRule):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "智能歌单规则错误",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrPlaylistNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "歌单不存在",
+			"error":   err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "智能歌单操作失败",
+			"error":   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "操作成功",
+			"data":    data,
+		})
+	}
+}
+
+// 返回分享接口的结果
+func respondShare(c *gin.Context, data any, err error) {
+	switch {
+	case errors.Is(err, ErrShareNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "分享链接不存在",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrPlaylistNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "歌单不存在",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrShareExpired), errors.Is(err, ErrShareRevoked), errors.Is(err, ErrShareExhausted):
+		c.JSON(http.StatusGone, gin.H{
+			"code":    http.StatusGone,
+			"message": "分享链接已失效",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrPlaylistForbidden):
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "没有权限",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrInvalidShare):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "请求参数格式错误",
+			"error":   err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "分享操作失败",
+			"error":   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "操作成功",
+			"data":    data,
+		})
+	}
+}
+
+// 返回一起听接口的结果
+func respondRoom(c *gin.Context, room *RoomView, err error) {
+	switch {
+	case errors.Is(err, ErrRoomNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    http.StatusNotFound,
+			"message": "房间不存在",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrNotRoomHost), errors.Is(err, ErrNotRoomMember):
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": "没有权限",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, ErrInvalidRoomAction):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "操作失败",
+			"error":   err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    http.StatusInternalServerError,
+			"message": "操作失败",
+			"error":   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": "操作成功",
+			"data":    room,
+		})
+	}
+}
+
+// 解析分页参数 page（从 1 开始）和 pageSize（默认 20，最大 100）
+func bindPage(c *gin.Context) (int, int) {
+	page, pageSize := 1, 20
+	if p, err := strconv.Atoi(c.Query("page")); err == nil && p > 0 {
+		page = p
+	}
+	if ps, err := strconv.Atoi(c.Query("pageSize")); err == nil && ps > 0 && ps <= 100 {
+		pageSize = ps
+	}
+	return page, pageSize
+}
+
+// 提供 /music 目录下的文件
+func serveAsset(c *gin.Context, assetPath string) {
+	fullPath, err := resolveAssetPath(assetPath)
+	if err != nil {
+		if err == errForbiddenAsset {
+			c.String(http.StatusForbidden, "Forbidden")
+			return
+		}
+		c.String(http.StatusBadRequest, "Invalid path")
 		return
 	}
 
@@ -505,3 +2849,21 @@ func serveAsset(c *gin.Context, assetPath string) {
 	// ServeFile 会自动处理 Range Requests 和 Content-Type
 	http.ServeFile(c.Writer, c.Request, fullPath)
 }
+
+var errForbiddenAsset = errors.New("asset path outside music root")
+
+// 将数据库中记录的资源路径解析为 /music 目录下的绝对路径
+func resolveAssetPath(assetPath string) (string, error) {
+	// URL 解码
+	decodedPath, err := url.PathUnescape(assetPath)
+	if err != nil {
+		return "", err
+	}
+
+	fullPath := filepath.Join(MusicRoot, filepath.Clean(decodedPath))
+
+	if !strings.HasPrefix(fullPath, MusicRoot) {
+		return "", errForbiddenAsset
+	}
+	return fullPath, nil
+}
@@ -0,0 +1,222 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+var ErrUnsupportedAudio = errors.New("unsupported audio format")
+
+// audioDecoder 以交错排列的 float64 采样（范围 -1~1）输出 PCM 数据
+type audioDecoder interface {
+	SampleRate() int
+	Channels() int
+	// ReadSamples 读取若干采样到 buf，返回读取的采样数，结束时返回 io.EOF
+	ReadSamples(buf []float64) (int, error)
+	Close() error
+}
+
+// 根据扩展名打开音频解码器，目前支持 MP3 和 PCM WAV
+func openAudioDecoder(fullPath string) (audioDecoder, error) {
+	switch strings.ToLower(filepath.Ext(fullPath)) {
+	case ".mp3":
+		return openMP3Decoder(fullPath)
+	case ".wav":
+		return openWAVDecoder(fullPath)
+	default:
+		return nil, ErrUnsupportedAudio
+	}
+}
+
+// ==== MP3 ====
+
+type mp3Decoder struct {
+	f   *os.File
+	d   *mp3.Decoder
+	raw []byte
+}
+
+func openMP3Decoder(fullPath string) (*mp3Decoder, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	d, err := mp3.NewDecoder(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to decode mp3: %w", err)
+	}
+	return &mp3Decoder{f: f, d: d}, nil
+}
+
+func (m *mp3Decoder) SampleRate() int { return m.d.SampleRate() }
+
+// go-mp3 总是输出 16bit 双声道
+func (m *mp3Decoder) Channels() int { return 2 }
+
+func (m *mp3Decoder) ReadSamples(buf []float64) (int, error) {
+	if cap(m.raw) < len(buf)*2 {
+		m.raw = make([]byte, len(buf)*2)
+	}
+	raw := m.raw[:len(buf)*2]
+	n, err := io.ReadFull(m.d, raw)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	samples := n / 2
+	for i := 0; i < samples; i++ {
+		buf[i] = float64(int16(binary.LittleEndian.Uint16(raw[i*2:]))) / 32768
+	}
+	if samples > 0 && err == io.EOF {
+		err = nil
+	}
+	return samples, err
+}
+
+func (m *mp3Decoder) Close() error { return m.f.Close() }
+
+// ==== WAV ====
+
+type wavDecoder struct {
+	f          *os.File
+	r          *bufio.Reader
+	sampleRate int
+	channels   int
+	bits       int
+	float      bool
+	remaining  int64 // data 块剩余字节数
+	raw        []byte
+}
+
+func openWAVDecoder(fullPath string) (*wavDecoder, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	w := &wavDecoder{f: f, r: bufio.NewReader(f)}
+	if err := w.readHeader(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *wavDecoder) readHeader() error {
+	var riff [12]byte
+	if _, err := io.ReadFull(w.r, riff[:]); err != nil {
+		return err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return errors.New("invalid wav header")
+	}
+
+	gotFmt := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(w.r, chunk[:]); err != nil {
+			return errors.New("wav data chunk not found")
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch string(chunk[0:4]) {
+		case "fmt ":
+			body := make([]byte, size)
+			if _, err := io.ReadFull(w.r, body); err != nil {
+				return err
+			}
+			if len(body) < 16 {
+				return errors.New("invalid wav fmt chunk")
+			}
+			format := binary.LittleEndian.Uint16(body[0:2])
+			w.channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			w.sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			w.bits = int(binary.LittleEndian.Uint16(body[14:16]))
+			if format == 0xFFFE && len(body) >= 26 { // WAVE_FORMAT_EXTENSIBLE
+				format = binary.LittleEndian.Uint16(body[24:26])
+			}
+			switch {
+			case format == 1 && (w.bits == 8 || w.bits == 16 || w.bits == 24 || w.bits == 32):
+			case format == 3 && (w.bits == 32 || w.bits == 64):
+				w.float = true
+			default:
+				return ErrUnsupportedAudio
+			}
+			if w.channels <= 0 || w.sampleRate <= 0 {
+				return errors.New("invalid wav fmt chunk")
+			}
+			gotFmt = true
+		case "data":
+			if !gotFmt {
+				return errors.New("wav fmt chunk missing")
+			}
+			w.remaining = size
+			return nil
+		default:
+			if _, err := w.r.Discard(int(size + size%2)); err != nil {
+				return err
+			}
+			continue
+		}
+		if size%2 == 1 {
+			w.r.Discard(1)
+		}
+	}
+}
+
+func (w *wavDecoder) SampleRate() int { return w.sampleRate }
+
+func (w *wavDecoder) Channels() int { return w.channels }
+
+func (w *wavDecoder) ReadSamples(buf []float64) (int, error) {
+	if w.remaining <= 0 {
+		return 0, io.EOF
+	}
+	width := w.bits / 8
+	want := int64(len(buf) * width)
+	if want > w.remaining {
+		want = w.remaining - w.remaining%int64(width)
+	}
+	if cap(w.raw) < int(want) {
+		w.raw = make([]byte, want)
+	}
+	raw := w.raw[:want]
+	n, err := io.ReadFull(w.r, raw)
+	w.remaining -= int64(n)
+	if err == io.ErrUnexpectedEOF {
+		w.remaining = 0
+	}
+
+	samples := n / width
+	for i := 0; i < samples; i++ {
+		b := raw[i*width:]
+		switch {
+		case w.float && width == 4:
+			buf[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		case w.float:
+			buf[i] = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		case width == 1:
+			buf[i] = (float64(b[0]) - 128) / 128
+		case width == 2:
+			buf[i] = float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+		case width == 3:
+			v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+			buf[i] = float64(v) / 8388608
+		default:
+			buf[i] = float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648
+		}
+	}
+	if samples == 0 {
+		return 0, io.EOF
+	}
+	return samples, nil
+}
+
+func (w *wavDecoder) Close() error { return w.f.Close() }
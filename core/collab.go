@@ -0,0 +1,382 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 歌单成员的角色，权限依次递增
+const (
+	PlaylistRoleViewer = "viewer" // 只能查看
+	PlaylistRoleEditor = "editor" // 可以添加、移除歌曲和调整顺序
+	PlaylistRoleOwner  = "owner"  // 歌单的创建者，另外可以管理成员、删除歌单
+)
+
+var playlistRoleRank = map[string]int{
+	PlaylistRoleViewer: 1,
+	PlaylistRoleEditor: 2,
+	PlaylistRoleOwner:  3,
+}
+
+// 歌单动态的类型
+const (
+	PlaylistActionCreate   = "create"
+	PlaylistActionAdd      = "add"
+	PlaylistActionRemove   = "remove"
+	PlaylistActionReorder  = "reorder"
+	PlaylistActionImport   = "import"   // 导入歌单时批量添加歌曲
+	PlaylistActionRename   = "rename"   // 修改名称或描述
+	PlaylistActionRollback = "rollback" // 回滚到之前的版本
+	PlaylistActionMerge    = "merge"    // 与其他歌单合并、求交集或差集
+	PlaylistActionDedupe   = "dedupe"   // 清理重复歌曲
+	PlaylistActionRules    = "rules"    // 修改智能歌单的名称、描述或规则
+	PlaylistActionDelete   = "delete"   // 移入回收站
+	PlaylistActionRestore  = "restore"  // 从回收站恢复
+	PlaylistActionInvite   = "invite"   // 邀请成员或修改成员的角色
+	PlaylistActionKick     = "kick"     // 移除成员
+	PlaylistActionLeave    = "leave"    // 成员自己退出
+)
+
+var (
+	ErrPlaylistNotFound  = errors.New("playlist not found")
+	ErrPlaylistForbidden = errors.New("permission denied")
+	ErrPlaylistConflict  = errors.New("playlist has been modified by someone else")
+	ErrInvalidMember     = errors.New("invalid playlist member")
+	ErrInvalidReorder    = errors.New("musicIds must list every song in the playlist exactly once")
+)
+
+// 歌单成员（不包括创建者，创建者由 Playlist.OwnerUserID 表示）
+type PlaylistMember struct {
+	PlaylistID int64     `json:"playlistId" gorm:"primaryKey;column:playlist_id"`
+	UserID     int64     `json:"userId" gorm:"primaryKey;column:user_id;index"`
+	Role       string    `json:"role" gorm:"column:role;type:varchar(16);not null"`
+	InvitedBy  int64     `json:"invitedBy" gorm:"column:invited_by;not null"`
+	CreatedAt  time.Time `json:"createdAt" gorm:"column:created_at;autoCreateTime"`
+}
+
+func (PlaylistMember) TableName() string {
+	return "playlist_members"
+}
+
+// 歌单动态：谁在什么时候对歌单做了什么
+type PlaylistActivity struct {
+	ID            int64     `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	PlaylistID    int64     `json:"playlistId" gorm:"column:playlist_id;not null;index:idx_playlist_activity,priority:1"`
+	UserID        int64     `json:"userId" gorm:"column:user_id;not null"`
+	Action        string    `json:"action" gorm:"column:action;type:varchar(16);not null"`
+	MusicID       int64     `json:"musicId,omitempty" gorm:"column:music_id"`
+	TargetUserID  int64     `json:"targetUserId,omitempty" gorm:"column:target_user_id"` // 成员变动时被邀请或移除的用户
+	Role          string    `json:"role,omitempty" gorm:"column:role;type:varchar(16)"`
+	TargetVersion int64     `json:"targetVersion,omitempty" gorm:"column:target_version"` // 回滚时恢复到的版本
+	Version       int64     `json:"version" gorm:"column:version;not null"`               // 操作后歌单的版本
+	CreatedAt     time.Time `json:"createdAt" gorm:"column:created_at;autoCreateTime;index:idx_playlist_activity,priority:2"`
+}
+
+func (PlaylistActivity) TableName() string {
+	return "playlist_activities"
+}
+
+// 用户在歌单中的角色，没有权限时返回空字符串
+func GetPlaylistRole(playlistID, userID int64) (string, error) {
+	var playlist Playlist
+	if err := DB.Select("id", "owner_user_id").First(&playlist, playlistID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrPlaylistNotFound
+		}
+		return "", err
+	}
+	if playlist.OwnerUserID == userID {
+		return PlaylistRoleOwner, nil
+	}
+	var member PlaylistMember
+	err := DB.Where("playlist_id = ? AND user_id = ?", playlistID, userID).Limit(1).Find(&member).Error
+	return member.Role, err
+}
+
+// 检查用户在歌单中至少具有 need 角色
+func RequirePlaylistRole(playlistID, userID int64, need string) error {
+	role, err := GetPlaylistRole(playlistID, userID)
+	if err != nil {
+		return err
+	}
+	if playlistRoleRank[role] < playlistRoleRank[need] {
+		return fmt.Errorf("%w: %s role required", ErrPlaylistForbidden, need)
+	}
+	return nil
+}
+
+// 在事务中递增歌单的版本并记录一条动态，修改歌曲或名称时同时保存快照（见 PlaylistVersion）
+func recordPlaylistActivity(tx *gorm.DB, a *PlaylistActivity) error {
+	err := tx.Model(&Playlist{}).Where("id = ?", a.PlaylistID).
+		UpdateColumn("version", gorm.Expr("version + 1")).Error
+	if err != nil {
+		return err
+	}
+	return savePlaylistActivity(tx, a)
+}
+
+// 在事务中确认歌单仍是 version 版本并递增版本，之后用 savePlaylistActivity 记录动态
+// 比较和递增在同一条 UPDATE 中完成：并发的修改只有一个能更新到这一行，其余返回 ErrPlaylistConflict；
+// Postgres 上这一行在事务结束前保持锁定，SQLite 的写事务本身是串行的
+func claimPlaylistVersion(tx *gorm.DB, playlistID, version int64) error {
+	result := tx.Model(&Playlist{}).Where("id = ? AND version = ?", playlistID, version).
+		UpdateColumn("version", gorm.Expr("version + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		var current []int64
+		if err := tx.Model(&Playlist{}).Where("id = ?", playlistID).Pluck("version", &current).Error; err != nil {
+			return err
+		}
+		if len(current) == 0 {
+			return ErrPlaylistNotFound
+		}
+		return fmt.Errorf("%w: current version is %d", ErrPlaylistConflict, current[0])
+	}
+	return nil
+}
+
+// 记录一条动态，歌单的版本已由调用方递增
+func savePlaylistActivity(tx *gorm.DB, a *PlaylistActivity) error {
+	if err := tx.Model(&Playlist{}).Where("id = ?", a.PlaylistID).Pluck("version", &a.Version).Error; err != nil {
+		return err
+	}
+	if err := tx.Create(a).Error; err != nil {
+		return err
+	}
+	return snapshotPlaylist(tx, a)
+}
+
+// 歌单的创建者和所有成员
+func playlistAudience(playlistID int64) ([]int64, error) {
+	var owner []int64
+	if err := DB.Model(&Playlist{}).Where("id = ?", playlistID).Pluck("owner_user_id", &owner).Error; err != nil {
+		return nil, err
+	}
+	var members []int64
+	if err := DB.Model(&PlaylistMember{}).Where("playlist_id = ?", playlistID).Pluck("user_id", &members).Error; err != nil {
+		return nil, err
+	}
+	return append(owner, members...), nil
+}
+
+// 把歌单的变化推送给创建者和成员的所有在线设备（见 DeviceHub）
+// audience 为空时按歌单当前的成员查询；歌单删除或成员被移除时由调用方传入变化前的成员
+func broadcastPlaylistActivity(a *PlaylistActivity, audience []int64) {
+	if a == nil {
+		return
+	}
+	if audience == nil {
+		var err error
+		if audience, err = playlistAudience(a.PlaylistID); err != nil {
+			return
+		}
+	}
+	for _, userID := range audience {
+		Devices.SendToUser(userID, "", wsMessage{Type: wsMsgPlaylist, Playlist: a})
+	}
+}
+
+// ==== 成员 ====
+
+// 歌单的成员，创建者排在最前
+func GetPlaylistMembers(playlistID int64) ([]PlaylistMember, error) {
+	var playlist Playlist
+	if err := DB.Select("id", "owner_user_id", "created_at").First(&playlist, playlistID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlaylistNotFound
+		}
+		return nil, err
+	}
+	members := []PlaylistMember{{
+		PlaylistID: playlistID,
+		UserID:     playlist.OwnerUserID,
+		Role:       PlaylistRoleOwner,
+		InvitedBy:  playlist.OwnerUserID,
+		CreatedAt:  playlist.CreatedAt,
+	}}
+	var rows []PlaylistMember
+	if err := DB.Where("playlist_id = ?", playlistID).Order("created_at, user_id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return append(members, rows...), nil
+}
+
+// 邀请用户成为歌单的编辑者或查看者，已是成员时修改其角色
+func SetPlaylistMember(playlistID, actorID, userID int64, role string) (*PlaylistMember, error) {
+	if role != PlaylistRoleEditor && role != PlaylistRoleViewer {
+		return nil, fmt.Errorf("%w: role must be %s or %s", ErrInvalidMember, PlaylistRoleEditor, PlaylistRoleViewer)
+	}
+	member := PlaylistMember{PlaylistID: playlistID, UserID: userID, Role: role, InvitedBy: actorID}
+	var activity *PlaylistActivity
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var playlist Playlist
+		if err := tx.First(&playlist, playlistID).Error; err != nil {
+			return ErrPlaylistNotFound
+		}
+		if playlist.ReadOnly() {
+			return ErrPlaylistReadOnly
+		}
+		if userID == playlist.OwnerUserID {
+			return fmt.Errorf("%w: user %d is the owner", ErrInvalidMember, userID)
+		}
+
+		var existing PlaylistMember
+		if err := tx.Where("playlist_id = ? AND user_id = ?", playlistID, userID).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		if existing.UserID != 0 {
+			if existing.Role == role {
+				member = existing
+				return nil
+			}
+			if err := tx.Model(&existing).Update("role", role).Error; err != nil {
+				return err
+			}
+			member = existing
+			member.Role = role
+		} else if err := tx.Create(&member).Error; err != nil {
+			return err
+		}
+
+		activity = &PlaylistActivity{PlaylistID: playlistID, UserID: actorID, Action: PlaylistActionInvite, TargetUserID: userID, Role: role}
+		return recordPlaylistActivity(tx, activity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	broadcastPlaylistActivity(activity, nil)
+	return &member, nil
+}
+
+// 移除歌单成员；actorID 与 userID 相同时为成员自己退出
+func RemovePlaylistMember(playlistID, actorID, userID int64) error {
+	audience, err := playlistAudience(playlistID)
+	if err != nil {
+		return err
+	}
+	activity := &PlaylistActivity{PlaylistID: playlistID, UserID: actorID, Action: PlaylistActionKick, TargetUserID: userID}
+	if actorID == userID {
+		activity.Action = PlaylistActionLeave
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("playlist_id = ? AND user_id = ?", playlistID, userID).Delete(&PlaylistMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: user %d is not a member", ErrInvalidMember, userID)
+		}
+		return recordPlaylistActivity(tx, activity)
+	})
+	if err != nil {
+		return err
+	}
+	broadcastPlaylistActivity(activity, audience)
+	return nil
+}
+
+// 其他用户共享给该用户的歌单，附带该用户的角色
+type SharedPlaylist struct {
+	Playlist
+	Role string `json:"role"`
+}
+
+func GetSharedPlaylists(userID int64) ([]SharedPlaylist, error) {
+	return sharedPlaylists(DB, userID)
+}
+
+func sharedPlaylists(tx *gorm.DB, userID int64) ([]SharedPlaylist, error) {
+	var members []PlaylistMember
+	if err := tx.Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	list := make([]SharedPlaylist, 0, len(members))
+	if len(members) == 0 {
+		return list, nil
+	}
+	roles := make(map[int64]string, len(members))
+	ids := make([]int64, 0, len(members))
+	for _, m := range members {
+		roles[m.PlaylistID] = m.Role
+		ids = append(ids, m.PlaylistID)
+	}
+	var playlists []Playlist
+	if err := tx.Where("id IN ?", ids).Order("id").Find(&playlists).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range playlists {
+		list = append(list, SharedPlaylist{Playlist: p, Role: roles[p.ID]})
+	}
+	return list, nil
+}
+
+// ==== 动态 ====
+
+// 歌单动态，按时间倒序分页
+func GetPlaylistActivities(playlistID int64, page, pageSize int) ([]PlaylistActivity, int64, error) {
+	var total int64
+	query := DB.Model(&PlaylistActivity{}).Where("playlist_id = ?", playlistID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	list := []PlaylistActivity{}
+	err := query.Order("created_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&list).Error
+	return list, total, err
+}
+
+// ==== 调整顺序 ====
+
+// 按 musicIDs 的顺序重排歌单，musicIDs 必须恰好包含歌单中的所有歌曲
+// version 必须与歌单当前的版本一致，否则说明歌单已被其他人修改，返回 ErrPlaylistConflict
+func ReorderPlaylist(playlistID, userID int64, musicIDs []int64, version int64) (*Playlist, error) {
+	var activity *PlaylistActivity
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var playlist Playlist
+		if err := tx.First(&playlist, playlistID).Error; err != nil {
+			return ErrPlaylistNotFound
+		}
+		if playlist.ReadOnly() {
+			return ErrPlaylistReadOnly
+		}
+		if err := claimPlaylistVersion(tx, playlistID, version); err != nil {
+			return err
+		}
+
+		var current []int64
+		if err := tx.Model(&PlaylistItem{}).Where("playlist_id = ?", playlistID).Pluck("music_id", &current).Error; err != nil {
+			return err
+		}
+		seen := make(map[int64]bool, len(musicIDs))
+		for _, id := range musicIDs {
+			seen[id] = true
+		}
+		if len(seen) != len(musicIDs) || len(musicIDs) != len(current) {
+			return ErrInvalidReorder
+		}
+		for _, id := range current {
+			if !seen[id] {
+				return ErrInvalidReorder
+			}
+		}
+
+		for i, id := range musicIDs {
+			err := tx.Model(&PlaylistItem{}).Where("playlist_id = ? AND music_id = ?", playlistID, id).
+				Update("track_order", i+1).Error
+			if err != nil {
+				return err
+			}
+		}
+		activity = &PlaylistActivity{PlaylistID: playlistID, UserID: userID, Action: PlaylistActionReorder}
+		return savePlaylistActivity(tx, activity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	broadcastPlaylistActivity(activity, nil)
+	return GetPlaylistByID(playlistID)
+}
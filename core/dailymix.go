@@ -0,0 +1,385 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	dailyMixCount       = 3   // 每个用户生成的推荐歌单数
+	dailyMixSize        = 25  // 每个推荐歌单的歌曲数
+	dailyMixFamiliar    = 0.6 // 听过的歌曲所占比例，其余为没听过的歌曲
+	dailyMixHistoryDays = 90  // 统计口味时回看的天数
+	dailyMixHalfLife    = 30  // 播放次数的衰减半衰期（天）
+	dailyMixDefaultHour = 4   // 默认每天凌晨 4 点重新生成
+)
+
+// 用户的口味画像
+type tasteProfile struct {
+	familiar map[int64]float64  // 近期常听歌曲的得分（播放次数按时间衰减，加上收藏和喜欢）
+	heard    map[int64]bool     // 听过的全部歌曲
+	disliked map[int64]bool     // 不喜欢的歌曲，不会出现在推荐中
+	affinity map[string]float64 // 标签偏好
+	plays    map[int64]int      // 近期完整播放（未跳过）的次数
+	starred  map[int64]bool     // 收藏的歌曲
+	liked    map[int64]bool     // 喜欢的歌曲
+}
+
+func buildTasteProfile(userID int64, songs map[int64]Music, now time.Time) (*tasteProfile, error) {
+	p := &tasteProfile{
+		familiar: map[int64]float64{},
+		heard:    map[int64]bool{},
+		disliked: map[int64]bool{},
+		affinity: map[string]float64{},
+		plays:    map[int64]int{},
+		starred:  map[int64]bool{},
+		liked:    map[int64]bool{},
+	}
+
+	var heard []int64
+	if err := DB.Model(&PlayHistory{}).Where("user_id = ?", userID).Distinct().Pluck("music_id", &heard).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range heard {
+		p.heard[id] = true
+	}
+
+	var plays []PlayHistory
+	err := DB.Select("music_id, played_at, end_reason").
+		Where("user_id = ? AND played_at > ?", userID, now.AddDate(0, 0, -dailyMixHistoryDays)).
+		Find(&plays).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, ph := range plays {
+		if ph.EndReason == PlayEndSkipped {
+			p.familiar[ph.MusicID] -= 0.5
+			continue
+		}
+		age := now.Sub(ph.PlayedAt).Hours() / 24
+		p.familiar[ph.MusicID] += math.Pow(0.5, age/dailyMixHalfLife)
+		p.plays[ph.MusicID]++
+	}
+
+	starred, err := starredMusicIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range starred {
+		p.familiar[id] += 2
+		p.starred[id] = true
+	}
+
+	feedback, err := GetMusicFeedback(userID)
+	if err != nil {
+		return nil, err
+	}
+	for id, v := range feedback {
+		if v == FeedbackDislike {
+			p.disliked[id] = true
+			delete(p.familiar, id)
+			for _, l := range songs[id].Labels {
+				p.affinity[l] -= 2
+			}
+			continue
+		}
+		p.familiar[id] += 2
+		p.liked[id] = true
+	}
+
+	for id, score := range p.familiar {
+		if score <= 0 {
+			delete(p.familiar, id)
+			continue
+		}
+		for _, l := range songs[id].Labels {
+			p.affinity[l] += score
+		}
+	}
+	return p, nil
+}
+
+// 没听过的歌曲按标签偏好打分，带有偏好标签的“不适合”标签（delabels）会扣分
+func (p *tasteProfile) discoveryScore(m Music) float64 {
+	var score float64
+	for _, l := range m.Labels {
+		score += p.affinity[l]
+	}
+	for _, l := range m.DeLabels {
+		if a := p.affinity[l]; a > 0 {
+			score -= a
+		}
+	}
+	return score
+}
+
+// 一个推荐歌单
+type dailyMix struct {
+	label    string
+	familiar int
+	tracks   []int64
+}
+
+// 按标签偏好选出几个主题，每个主题混合常听的歌曲和带有相同标签、但没听过的歌曲
+func buildDailyMixes(userID int64, songs map[int64]Music, p *tasteProfile, now time.Time) []dailyMix {
+	type scored struct {
+		id    int64
+		score float64
+	}
+	topLabels := make([]string, 0, len(p.affinity))
+	for l, a := range p.affinity {
+		if a > 0 {
+			topLabels = append(topLabels, l)
+		}
+	}
+	sort.Slice(topLabels, func(i, j int) bool {
+		if p.affinity[topLabels[i]] != p.affinity[topLabels[j]] {
+			return p.affinity[topLabels[i]] > p.affinity[topLabels[j]]
+		}
+		return topLabels[i] < topLabels[j]
+	})
+
+	// 同一用户同一天生成的结果固定，第二天换一批
+	day := now.Year()*1000 + now.YearDay()
+	rng := rand.New(rand.NewSource(userID*1000003 + int64(day)))
+
+	// 从得分最高的 2n 首里随机取 n 首，保证每天有变化
+	pick := func(candidates []scored, n int) []int64 {
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].score != candidates[j].score {
+				return candidates[i].score > candidates[j].score
+			}
+			return candidates[i].id < candidates[j].id
+		})
+		if len(candidates) > 2*n {
+			candidates = candidates[:2*n]
+		}
+		rng.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+		if len(candidates) > n {
+			candidates = candidates[:n]
+		}
+		ids := make([]int64, len(candidates))
+		for i, c := range candidates {
+			ids[i] = c.id
+		}
+		return ids
+	}
+
+	used := map[int64]bool{}
+	var mixes []dailyMix
+	for _, label := range topLabels {
+		if len(mixes) == dailyMixCount {
+			break
+		}
+		var familiar, fresh []scored
+		for id, m := range songs {
+			if used[id] || p.disliked[id] || !containsString(m.Labels, label) {
+				continue
+			}
+			if score, ok := p.familiar[id]; ok {
+				familiar = append(familiar, scored{id, score})
+			} else if !p.heard[id] {
+				fresh = append(fresh, scored{id, p.discoveryScore(m)})
+			}
+		}
+		if len(familiar) == 0 {
+			continue
+		}
+
+		nFamiliar := int(math.Round(dailyMixSize * dailyMixFamiliar))
+		if len(fresh) < dailyMixSize-nFamiliar {
+			nFamiliar = dailyMixSize - len(fresh)
+		}
+		known := pick(familiar, nFamiliar)
+		unheard := pick(fresh, dailyMixSize-len(known))
+
+		mix := dailyMix{label: label, familiar: len(known), tracks: interleave(known, unheard)}
+		for _, id := range mix.tracks {
+			used[id] = true
+		}
+		mixes = append(mixes, mix)
+	}
+	return mixes
+}
+
+// 按比例交替排列两组歌曲，让没听过的歌曲均匀分布在歌单中
+func interleave(a, b []int64) []int64 {
+	out := make([]int64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		// 始终让 a 已取出的比例不低于其总体比例
+		if j >= len(b) || (i < len(a) && i*(len(a)+len(b)) <= len(a)*(i+j)) {
+			out = append(out, a[i])
+			i++
+		} else {
+			out = append(out, b[j])
+			j++
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 保存推荐歌单：复用已有的系统歌单以保持 ID 不变，多余的删除
+func saveDailyMixes(userID int64, mixes []dailyMix, now time.Time) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var existing []Playlist
+		if err := tx.Where("owner_user_id = ? AND kind = ?", userID, PlaylistKindDailyMix).Order("id ASC").Find(&existing).Error; err != nil {
+			return err
+		}
+
+		for i, mix := range mixes {
+			var playlist Playlist
+			if i < len(existing) {
+				playlist = existing[i]
+			}
+			playlist.Name = fmt.Sprintf("每日推荐 %d · %s", i+1, mix.label)
+			playlist.Description = fmt.Sprintf("根据你的收听记录每天更新：%d 首常听的歌曲，%d 首新发现", mix.familiar, len(mix.tracks)-mix.familiar)
+			playlist.OwnerUserID = userID
+			playlist.Kind = PlaylistKindDailyMix
+			playlist.RefreshedAt = &now
+			if err := tx.Omit("Items").Save(&playlist).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Where("playlist_id = ?", playlist.ID).Delete(&PlaylistItem{}).Error; err != nil {
+				return err
+			}
+			items := make([]PlaylistItem, len(mix.tracks))
+			for k, id := range mix.tracks {
+				items[k] = PlaylistItem{PlaylistID: playlist.ID, MusicID: id, TrackOrder: k + 1}
+			}
+			if len(items) > 0 {
+				if err := tx.Omit("Music").Create(&items).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, stale := range existing[min(len(mixes), len(existing)):] {
+			if err := tx.Where("playlist_id = ?", stale.ID).Delete(&PlaylistItem{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Delete(&Playlist{}, stale.ID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 生成推荐歌单时加锁，避免定时任务和手动刷新同时执行
+var dailyMixMu sync.Mutex
+
+// 重新生成某个用户的推荐歌单
+func RefreshDailyMixes(userID int64) ([]Playlist, error) {
+	dailyMixMu.Lock()
+	err := refreshDailyMixes(userID, time.Now())
+	dailyMixMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return GetDailyMixes(userID)
+}
+
+func refreshDailyMixes(userID int64, now time.Time) error {
+	all, err := GetAllSongs()
+	if err != nil {
+		return err
+	}
+	songs := make(map[int64]Music, len(all))
+	for _, m := range all {
+		songs[m.Id] = m
+	}
+
+	profile, err := buildTasteProfile(userID, songs, now)
+	if err != nil {
+		return err
+	}
+	return saveDailyMixes(userID, buildDailyMixes(userID, songs, profile, now), now)
+}
+
+// 为所有有收听记录或反馈的用户重新生成推荐歌单
+func RefreshAllDailyMixes() error {
+	dailyMixMu.Lock()
+	defer dailyMixMu.Unlock()
+
+	var users []int64
+	err := DB.Raw("SELECT user_id FROM play_history UNION SELECT user_id FROM music_feedback UNION SELECT CAST(? AS BIGINT)", DefaultUserID).
+		Scan(&users).Error
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, id := range users {
+		if err := refreshDailyMixes(id, now); err != nil {
+			return fmt.Errorf("user %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// 查询用户的推荐歌单（包含歌曲）
+func GetDailyMixes(userID int64) ([]Playlist, error) {
+	playlists := []Playlist{}
+	err := DB.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("track_order ASC")
+	}).Preload("Items.Music").
+		Where("owner_user_id = ? AND kind = ?", userID, PlaylistKindDailyMix).
+		Order("id ASC").
+		Find(&playlists).Error
+	return playlists, err
+}
+
+// 启动每日推荐的定时任务：启动时若今天还没生成则立即生成，之后每天在 DAILY_MIX_HOUR 点（默认 4 点）重新生成
+func StartDailyMixScheduler() {
+	hour := dailyMixDefaultHour
+	if h, err := strconv.Atoi(os.Getenv("DAILY_MIX_HOUR")); err == nil && h >= 0 && h < 24 {
+		hour = h
+	}
+
+	go func() {
+		var last Playlist
+		err := DB.Where("kind = ?", PlaylistKindDailyMix).Order("refreshed_at DESC").First(&last).Error
+		if err != nil || last.RefreshedAt == nil || !sameDay(*last.RefreshedAt, time.Now()) {
+			if err := RefreshAllDailyMixes(); err != nil {
+				log.Printf("daily mix: %v", err)
+			}
+		}
+
+		for {
+			now := time.Now()
+			next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+			time.Sleep(time.Until(next))
+			if err := RefreshAllDailyMixes(); err != nil {
+				log.Printf("daily mix: %v", err)
+			}
+		}
+	}()
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
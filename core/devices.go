@@ -0,0 +1,290 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 设备之间的控制命令
+const (
+	DeviceCommandPlay     = "play"
+	DeviceCommandPause    = "pause"
+	DeviceCommandSeek     = "seek"
+	DeviceCommandNext     = "next"
+	DeviceCommandPrevious = "previous"
+	DeviceCommandVolume   = "volume"
+	DeviceCommandTransfer = "transfer" // 把播放转移到目标设备
+)
+
+// WebSocket 消息类型
+const (
+	wsMsgState       = "state"        // 客户端 -> 服务端：上报本设备的播放状态
+	wsMsgCommand     = "command"      // 双向：发送 / 收到控制命令
+	wsMsgPing        = "ping"         // 客户端 -> 服务端：应用层心跳
+	wsMsgPong        = "pong"         // 服务端 -> 客户端：心跳回复
+	wsMsgDevices     = "devices"      // 服务端 -> 客户端：同一用户的在线设备列表
+	wsMsgDeviceState = "device_state" // 服务端 -> 客户端：其他设备的播放状态
+	wsMsgAck         = "ack"          // 服务端 -> 客户端：命令已送达
+	wsMsgError       = "error"        // 服务端 -> 客户端：出错
+	wsMsgPlaylist    = "playlist"     // 服务端 -> 客户端：用户参与的歌单发生了变化
+)
+
+var (
+	ErrDeviceOffline  = errors.New("device offline")
+	ErrUnknownCommand = errors.New("unknown device command")
+)
+
+// 设备的播放状态，由设备自己上报
+type PlaybackState struct {
+	MusicID     int64     `json:"musicId"`
+	QueueItemID int64     `json:"queueItemId,omitempty"`
+	Playing     bool      `json:"playing"`
+	PositionMs  int64     `json:"positionMs"`
+	Volume      float64   `json:"volume"`
+	UpdatedAt   time.Time `json:"updatedAt"` // 服务端收到状态的时间，客户端可据此推算当前进度
+}
+
+// 在线设备
+type DeviceInfo struct {
+	DeviceID    string         `json:"deviceId"`
+	Name        string         `json:"name"`
+	Type        string         `json:"type"` // 客户端自定义，如 desktop / phone / speaker
+	ConnectedAt time.Time      `json:"connectedAt"`
+	LastSeen    time.Time      `json:"lastSeen"`
+	State       *PlaybackState `json:"state"`
+}
+
+// 控制命令
+type DeviceCommand struct {
+	Target     string  `json:"target"`
+	Command    string  `json:"command"`
+	PositionMs int64   `json:"positionMs"`       // seek
+	Volume     float64 `json:"volume"`           // volume，0~1
+	Source     string  `json:"source,omitempty"` // transfer：原来播放的设备，省略时为正在播放的设备
+	From       string  `json:"from,omitempty"`   // 由服务端填写：发出命令的设备
+}
+
+// WebSocket 消息，按 type 使用对应字段
+type wsMessage struct {
+	Type     string            `json:"type"`
+	ID       string            `json:"id,omitempty"` // 客户端自定义的消息 ID，回复时原样带回
+	DeviceID string            `json:"deviceId,omitempty"`
+	State    *PlaybackState    `json:"state,omitempty"`
+	Command  *DeviceCommand    `json:"command,omitempty"`
+	Devices  []DeviceInfo      `json:"devices,omitempty"`
+	Playlist *PlaylistActivity `json:"playlist,omitempty"`
+	Message  string            `json:"message,omitempty"`
+}
+
+// 一个设备的连接，info 由 DeviceHub.mu 保护
+type deviceConn struct {
+	*wsConn
+	userID int64
+	info   DeviceInfo
+}
+
+// 设备中心：按用户管理在线设备并转发消息
+type DeviceHub struct {
+	mu      sync.RWMutex
+	devices map[int64]map[string]*deviceConn
+}
+
+var Devices = &DeviceHub{devices: map[int64]map[string]*deviceConn{}}
+
+func (h *DeviceHub) register(d *deviceConn) {
+	h.mu.Lock()
+	if h.devices[d.userID] == nil {
+		h.devices[d.userID] = map[string]*deviceConn{}
+	}
+	// 同一设备重复连接时（如刷新页面）替换旧连接
+	old := h.devices[d.userID][d.info.DeviceID]
+	h.devices[d.userID][d.info.DeviceID] = d
+	h.mu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+	h.broadcastDevices(d.userID)
+}
+
+func (h *DeviceHub) unregister(d *deviceConn) {
+	h.mu.Lock()
+	removed := false
+	if h.devices[d.userID][d.info.DeviceID] == d {
+		delete(h.devices[d.userID], d.info.DeviceID)
+		if len(h.devices[d.userID]) == 0 {
+			delete(h.devices, d.userID)
+		}
+		removed = true
+	}
+	h.mu.Unlock()
+
+	d.close()
+	if removed {
+		h.broadcastDevices(d.userID)
+	}
+}
+
+// 用户的在线设备
+func (h *DeviceHub) List(userID int64) []DeviceInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	list := make([]DeviceInfo, 0, len(h.devices[userID]))
+	for _, d := range h.devices[userID] {
+		list = append(list, d.info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ConnectedAt.Before(list[j].ConnectedAt) })
+	return list
+}
+
+// 向设备发送消息；发送缓冲已满时断开该设备
+func (h *DeviceHub) deliver(d *deviceConn, msg wsMessage) {
+	if !d.trySend(msg) {
+		go h.unregister(d)
+	}
+}
+
+// 向用户的所有设备发送消息，except 为空时包括全部设备
+func (h *DeviceHub) SendToUser(userID int64, except string, msg wsMessage) {
+	h.mu.RLock()
+	targets := make([]*deviceConn, 0, len(h.devices[userID]))
+	for id, d := range h.devices[userID] {
+		if id != except {
+			targets = append(targets, d)
+		}
+	}
+	h.mu.RUnlock()
+	for _, d := range targets {
+		h.deliver(d, msg)
+	}
+}
+
+func (h *DeviceHub) broadcastDevices(userID int64) {
+	h.SendToUser(userID, "", wsMessage{Type: wsMsgDevices, Devices: h.List(userID)})
+}
+
+func (h *DeviceHub) get(userID int64, deviceID string) *deviceConn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.devices[userID][deviceID]
+}
+
+// 更新设备的播放状态并通知其他设备
+func (h *DeviceHub) updateState(d *deviceConn, state PlaybackState) {
+	state.UpdatedAt = time.Now()
+	h.mu.Lock()
+	d.info.State = &state
+	d.info.LastSeen = state.UpdatedAt
+	h.mu.Unlock()
+	h.SendToUser(d.userID, d.info.DeviceID, wsMessage{Type: wsMsgDeviceState, DeviceID: d.info.DeviceID, State: &state})
+}
+
+// 正在播放的设备，没有时返回空字符串
+func (h *DeviceHub) playingDevice(userID int64, except string) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var found string
+	var latest time.Time
+	for id, d := range h.devices[userID] {
+		if id != except && d.info.State != nil && d.info.State.Playing && d.info.State.UpdatedAt.After(latest) {
+			found, latest = id, d.info.State.UpdatedAt
+		}
+	}
+	return found
+}
+
+// 把命令发送到目标设备
+// transfer 会把原设备的播放队列复制到目标设备，让原设备暂停，并把原设备的播放状态随命令发给目标设备
+func (h *DeviceHub) SendCommand(userID int64, from string, cmd DeviceCommand) error {
+	switch cmd.Command {
+	case DeviceCommandPlay, DeviceCommandPause, DeviceCommandSeek, DeviceCommandNext,
+		DeviceCommandPrevious, DeviceCommandVolume, DeviceCommandTransfer:
+	default:
+		return ErrUnknownCommand
+	}
+	target := h.get(userID, cmd.Target)
+	if target == nil {
+		return ErrDeviceOffline
+	}
+	cmd.From = from
+
+	msg := wsMessage{Type: wsMsgCommand, Command: &cmd}
+	if cmd.Command == DeviceCommandTransfer {
+		if cmd.Source == "" {
+			cmd.Source = h.playingDevice(userID, cmd.Target)
+		}
+		if cmd.Source == "" {
+			cmd.Source = from
+		}
+		if cmd.Source != "" && cmd.Source != cmd.Target {
+			if _, err := CopyPlayQueue(userID, cmd.Source, cmd.Target); err != nil && !errors.Is(err, ErrInvalidQueue) {
+				return err
+			}
+			if source := h.get(userID, cmd.Source); source != nil {
+				h.mu.RLock()
+				msg.State = source.info.State
+				h.mu.RUnlock()
+				h.deliver(source, wsMessage{Type: wsMsgCommand, Command: &DeviceCommand{Target: cmd.Source, Command: DeviceCommandPause, From: from}})
+			}
+		}
+	}
+	h.deliver(target, msg)
+	return nil
+}
+
+// ==== 连接处理 ====
+
+// 升级为 WebSocket 连接并注册设备，阻塞到连接断开
+func ServeDeviceSocket(w http.ResponseWriter, r *http.Request, userID int64, info DeviceInfo) {
+	conn, err := upgradeWS(w, r)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	info.ConnectedAt, info.LastSeen = now, now
+	d := &deviceConn{wsConn: conn, userID: userID, info: info}
+
+	Devices.register(d)
+	defer Devices.unregister(d)
+	d.readLoop(fmt.Sprintf("devices: user %d device %s", userID, info.DeviceID), func(data []byte) {
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			Devices.deliver(d, wsMessage{Type: wsMsgError, Message: "invalid message: " + err.Error()})
+			return
+		}
+		Devices.handle(d, msg)
+	})
+}
+
+func (h *DeviceHub) handle(d *deviceConn, msg wsMessage) {
+	switch msg.Type {
+	case wsMsgPing:
+		h.mu.Lock()
+		d.info.LastSeen = time.Now()
+		h.mu.Unlock()
+		h.deliver(d, wsMessage{Type: wsMsgPong, ID: msg.ID})
+	case wsMsgState:
+		if msg.State == nil {
+			h.deliver(d, wsMessage{Type: wsMsgError, ID: msg.ID, Message: "state required"})
+			return
+		}
+		h.updateState(d, *msg.State)
+	case wsMsgCommand:
+		if msg.Command == nil {
+			h.deliver(d, wsMessage{Type: wsMsgError, ID: msg.ID, Message: "command required"})
+			return
+		}
+		if err := h.SendCommand(d.userID, d.info.DeviceID, *msg.Command); err != nil {
+			h.deliver(d, wsMessage{Type: wsMsgError, ID: msg.ID, Message: err.Error()})
+			return
+		}
+		h.deliver(d, wsMessage{Type: wsMsgAck, ID: msg.ID})
+	default:
+		h.deliver(d, wsMessage{Type: wsMsgError, ID: msg.ID, Message: "unknown message type: " + msg.Type})
+	}
+}
@@ -0,0 +1,277 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gorm.io/gorm"
+)
+
+// 内容相同的一组歌曲
+type DuplicateGroup struct {
+	ContentHash string  `json:"contentHash"`
+	Music       []Music `json:"music"`
+}
+
+// ComputeContentHash 计算音频文件的内容指纹
+// 指纹只覆盖音频数据本身：MP3 会跳过 ID3v2/ID3v1/APEv2 标签，FLAC 会跳过元数据块，
+// 因此同一首歌改名或改标签后指纹不变。其他格式按整个文件计算。
+func ComputeContentHash(fullPath string) (string, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	start, end, err := audioPayloadRange(f, info.Size())
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, start, end-start)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 计算去掉首尾标签后的音频数据区间 [start, end)
+func audioPayloadRange(r io.ReaderAt, size int64) (int64, int64, error) {
+	start, end := int64(0), size
+
+	// 文件头可能有一个或多个 ID3v2 标签
+	for end-start >= 10 {
+		head := make([]byte, 10)
+		if _, err := r.ReadAt(head, start); err != nil {
+			return 0, 0, err
+		}
+		if !bytes.Equal(head[:3], []byte("ID3")) {
+			break
+		}
+		tagSize := int64(head[6]&0x7f)<<21 | int64(head[7]&0x7f)<<14 | int64(head[8]&0x7f)<<7 | int64(head[9]&0x7f)
+		tagSize += 10
+		if head[5]&0x10 != 0 { // 带 footer
+			tagSize += 10
+		}
+		start += tagSize
+	}
+
+	// FLAC：跳过 fLaC 标记及全部元数据块
+	if end-start >= 4 {
+		marker := make([]byte, 4)
+		if _, err := r.ReadAt(marker, start); err != nil {
+			return 0, 0, err
+		}
+		if bytes.Equal(marker, []byte("fLaC")) {
+			pos := start + 4
+			for pos+4 <= end {
+				block := make([]byte, 4)
+				if _, err := r.ReadAt(block, pos); err != nil {
+					return 0, 0, err
+				}
+				pos += 4 + (int64(block[1])<<16 | int64(block[2])<<8 | int64(block[3]))
+				if block[0]&0x80 != 0 { // 最后一个元数据块
+					break
+				}
+			}
+			start = pos
+		}
+	}
+
+	// 文件尾的 ID3v1 标签
+	if end-start >= 128 {
+		tail := make([]byte, 3)
+		if _, err := r.ReadAt(tail, end-128); err != nil {
+			return 0, 0, err
+		}
+		if bytes.Equal(tail, []byte("TAG")) {
+			end -= 128
+		}
+	}
+
+	// 文件尾的 APEv2 标签
+	if end-start >= 32 {
+		footer := make([]byte, 32)
+		if _, err := r.ReadAt(footer, end-32); err != nil {
+			return 0, 0, err
+		}
+		if bytes.Equal(footer[:8], []byte("APETAGEX")) {
+			tagSize := int64(binary.LittleEndian.Uint32(footer[12:16]))
+			if binary.LittleEndian.Uint32(footer[20:24])&0x80000000 != 0 { // 带 header
+				tagSize += 32
+			}
+			end -= tagSize
+		}
+	}
+
+	if start > end {
+		return 0, 0, errors.New("invalid audio tag layout")
+	}
+	return start, end, nil
+}
+
+// 为歌曲计算并保存内容指纹
+func UpdateMusicContentHash(music *Music) error {
+	fullPath, err := resolveAssetPath(music.AudioURL)
+	if err != nil {
+		return err
+	}
+	hash, err := ComputeContentHash(fullPath)
+	if err != nil {
+		return err
+	}
+	music.ContentHash = hash
+	return DB.Model(music).Update("content_hash", hash).Error
+}
+
+// 查询内容指纹相同的重复歌曲
+func FindDuplicateMusic() ([]DuplicateGroup, error) {
+	var hashes []string
+	err := DB.Model(&Music{}).
+		Where("content_hash IS NOT NULL AND content_hash <> ''").
+		Group("content_hash").
+		Having("COUNT(*) > 1").
+		Pluck("content_hash", &hashes).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(hashes) == 0 {
+		return []DuplicateGroup{}, nil
+	}
+
+	var songs []Music
+	if err := DB.Where("content_hash IN ?", hashes).Order("id ASC").Find(&songs).Error; err != nil {
+		return nil, err
+	}
+
+	groups := make([]DuplicateGroup, 0, len(hashes))
+	index := make(map[string]int)
+	for _, m := range songs {
+		i, ok := index[m.ContentHash]
+		if !ok {
+			i = len(groups)
+			index[m.ContentHash] = i
+			groups = append(groups, DuplicateGroup{ContentHash: m.ContentHash})
+		}
+		groups[i].Music = append(groups[i].Music, m)
+	}
+	return groups, nil
+}
+
+// 合并重复歌曲：歌单项和播放记录改指向保留的歌曲，然后删除其余歌曲
+func MergeMusic(keepID int64, mergeIDs []int64) error {
+	if len(mergeIDs) == 0 {
+		return errors.New("no music to merge")
+	}
+	for _, id := range mergeIDs {
+		if id == keepID {
+			return errors.New("cannot merge music into itself")
+		}
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var keep Music
+		if err := tx.First(&keep, keepID).Error; err != nil {
+			return errors.New("music not found")
+		}
+
+		var dups []Music
+		if err := tx.Where("id IN ?", mergeIDs).Find(&dups).Error; err != nil {
+			return err
+		}
+		if len(dups) != len(mergeIDs) {
+			return errors.New("music not found")
+		}
+
+		// 1. 歌单项：保留歌曲已在歌单中时删除重复项，否则改为指向保留歌曲
+		var keepPlaylists []int64
+		if err := tx.Model(&PlaylistItem{}).Where("music_id = ?", keepID).Pluck("playlist_id", &keepPlaylists).Error; err != nil {
+			return err
+		}
+		hasKeep := make(map[int64]bool)
+		for _, pid := range keepPlaylists {
+			hasKeep[pid] = true
+		}
+
+		var items []PlaylistItem
+		if err := tx.Where("music_id IN ?", mergeIDs).Order("playlist_id ASC, track_order ASC").Find(&items).Error; err != nil {
+			return err
+		}
+		for _, it := range items {
+			if hasKeep[it.PlaylistID] {
+				if err := tx.Delete(&PlaylistItem{}, it.ID).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Model(&PlaylistItem{}).Where("id = ?", it.ID).Update("music_id", keepID).Error; err != nil {
+				return err
+			}
+			hasKeep[it.PlaylistID] = true
+		}
+
+		// 2. 播放记录
+		if err := tx.Model(&PlayHistory{}).Where("music_id IN ?", mergeIDs).Update("music_id", keepID).Error; err != nil {
+			return err
+		}
+
+		// 3. 删除被合并歌曲的波形缓存
+		if err := tx.Where("music_id IN ?", mergeIDs).Delete(&MusicWaveform{}).Error; err != nil {
+			return err
+		}
+
+		// 4. 用户反馈
+		if err := mergeMusicFeedback(tx, keepID, mergeIDs); err != nil {
+			return err
+		}
+
+		// 5. 相似度：删除被合并歌曲的统计，保留的歌曲稍后重新计算
+		if err := tx.Where("music_id IN ? OR similar_id IN ?", mergeIDs, mergeIDs).Delete(&MusicSimilarity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("music_a IN ? OR music_b IN ?", mergeIDs, mergeIDs).Delete(&MusicColisten{}).Error; err != nil {
+			return err
+		}
+
+		// 6. 合并标签
+		labels, delabels := keep.Labels, keep.DeLabels
+		for _, d := range dups {
+			labels = unionStrings(labels, d.Labels)
+			delabels = unionStrings(delabels, d.DeLabels)
+		}
+		if err := Repo.SetMusicLabels(tx, keepID, labels, delabels); err != nil {
+			return err
+		}
+
+		// 7. 删除重复歌曲
+		if err := tx.Where("id IN ?", mergeIDs).Delete(&Music{}).Error; err != nil {
+			return fmt.Errorf("failed to delete merged music: %w", err)
+		}
+		MarkSimilarityDirty(keepID)
+		return nil
+	})
+}
+
+// 合并两个字符串切片并去重，保持原有顺序
+func unionStrings(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var out []string
+	for _, s := range append(append([]string{}, a...), b...) {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
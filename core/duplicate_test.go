@@ -0,0 +1,134 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ID3v2 标签：10 字节头 + size 字节内容，size 按 syncsafe 整数编码
+func id3v2Tag(size int, footer bool) []byte {
+	head := []byte{'I', 'D', '3', 4, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	if footer {
+		head[5] = 0x10
+	}
+	tag := append(head, bytes.Repeat([]byte{0xee}, size)...)
+	if footer {
+		tag = append(tag, []byte("3DI\x04\x00\x10\x00\x00\x00\x00")...)
+	}
+	return tag
+}
+
+// ID3v1 标签：固定 128 字节，以 TAG 开头
+func id3v1Tag() []byte {
+	return append([]byte("TAG"), bytes.Repeat([]byte{0xdd}, 125)...)
+}
+
+// APEv2 标签：size 为内容加 footer 的长度，header 不计入 size
+func apeTag(items int, header bool) []byte {
+	size := items + 32
+	footer := make([]byte, 32)
+	copy(footer, "APETAGEX")
+	binary.LittleEndian.PutUint32(footer[8:12], 2000)
+	binary.LittleEndian.PutUint32(footer[12:16], uint32(size))
+	var tag []byte
+	if header {
+		binary.LittleEndian.PutUint32(footer[20:24], 0x80000000)
+		tag = append(tag, footer...)
+	}
+	tag = append(tag, bytes.Repeat([]byte{0xcc}, items)...)
+	return append(tag, footer...)
+}
+
+// FLAC 元数据块，last 表示最后一个块
+func flacBlock(size int, last bool) []byte {
+	head := []byte{0, byte(size >> 16), byte(size >> 8), byte(size)}
+	if last {
+		head[0] |= 0x80
+	}
+	return append(head, bytes.Repeat([]byte{0xbb}, size)...)
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func TestAudioPayloadRange(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x00}, 64) // 256 字节的“音频数据”
+
+	tests := []struct {
+		name   string
+		prefix []byte
+		suffix []byte
+	}{
+		{"no tags", nil, nil},
+		{"id3v2", id3v2Tag(100, false), nil},
+		{"id3v2 with footer", id3v2Tag(100, true), nil},
+		{"multiple id3v2", concat(id3v2Tag(20, false), id3v2Tag(300, false)), nil},
+		{"id3v1", nil, id3v1Tag()},
+		{"apev2", nil, apeTag(50, false)},
+		{"apev2 with header", nil, apeTag(50, true)},
+		{"apev2 before id3v1", nil, concat(apeTag(40, true), id3v1Tag())},
+		{"all mp3 tags", id3v2Tag(64, false), concat(apeTag(16, false), id3v1Tag())},
+		{"flac", concat([]byte("fLaC"), flacBlock(34, false), flacBlock(200, true)), nil},
+		{"flac after id3v2", concat(id3v2Tag(10, false), []byte("fLaC"), flacBlock(34, true)), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := concat(tt.prefix, audio, tt.suffix)
+			start, end, err := audioPayloadRange(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatalf("audioPayloadRange: %v", err)
+			}
+			if start != int64(len(tt.prefix)) || end != int64(len(tt.prefix)+len(audio)) {
+				t.Errorf("range = [%d, %d), want [%d, %d)", start, end, len(tt.prefix), len(tt.prefix)+len(audio))
+			}
+		})
+	}
+}
+
+func TestAudioPayloadRangeInvalid(t *testing.T) {
+	// 声明的 ID3v2 长度超过整个文件
+	data := concat(id3v2Tag(0, false)[:10], bytes.Repeat([]byte{0}, 20))
+	data[9] = 0x7f
+	if _, _, err := audioPayloadRange(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Fatal("expected an error for a tag larger than the file")
+	}
+}
+
+func TestComputeContentHashIgnoresTags(t *testing.T) {
+	dir := t.TempDir()
+	audio := bytes.Repeat([]byte{0x12, 0x34, 0x56}, 100)
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	plain, err := ComputeContentHash(write("plain.mp3", audio))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged, err := ComputeContentHash(write("tagged.mp3", concat(id3v2Tag(80, false), audio, id3v1Tag())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ComputeContentHash(write("other.mp3", append(audio, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain != tagged {
+		t.Errorf("hash changed after adding tags: %s != %s", plain, tagged)
+	}
+	if plain == other {
+		t.Error("different audio data produced the same hash")
+	}
+}
@@ -0,0 +1,352 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 推荐理由的类型
+const (
+	ReasonLabel     = "label"     // 命中请求或场景的标签
+	ReasonTaste     = "taste"     // 符合用户常听的标签
+	ReasonHistory   = "history"   // 用户近期常听
+	ReasonHabit     = "habit"     // 用户常在这个时段听
+	ReasonStarred   = "starred"   // 用户收藏了这首歌，或与收藏的歌曲相似
+	ReasonLiked     = "liked"     // 用户喜欢这首歌
+	ReasonSimilar   = "similar"   // 与指定的歌曲相似
+	ReasonSeed      = "seed"      // 电台的种子歌曲
+	ReasonSinger    = "singer"    // 电台偏好的歌手
+	ReasonDiscovery = "discovery" // 没听过的新歌
+)
+
+const (
+	explainHistoryMin  = 3 // 近期播放达到该次数才视为“常听”
+	explainLabelsShown = 3 // 理由中最多列出的标签数
+)
+
+// 一条推荐理由；Score 为该理由对推荐得分的贡献，便于排查排序问题
+type RecommendReason struct {
+	Type    string   `json:"type"`
+	Message string   `json:"message"`
+	Labels  []string `json:"labels,omitempty"`
+	MusicID int64    `json:"musicId,omitempty"` // 相关的歌曲，如收藏的歌曲、种子歌曲
+	Score   float64  `json:"score"`
+}
+
+// 推荐结果：歌曲信息加上推荐得分和理由
+type Recommendation struct {
+	Music
+	Score   float64           `json:"score"`
+	Reasons []RecommendReason `json:"reasons"`
+	Reason  string            `json:"reason,omitempty"` // 各条理由合成的一句话，目前只有即时推荐返回
+}
+
+func newRecommendation(m Music) Recommendation {
+	return Recommendation{Music: m, Reasons: []RecommendReason{}}
+}
+
+// 添加一条理由，并把它的贡献计入得分
+func (r *Recommendation) add(reason RecommendReason) {
+	r.Score += reason.Score
+	r.Reasons = append(r.Reasons, reason)
+}
+
+// 把各条理由合成一句话
+func (r *Recommendation) joinReasons() string {
+	messages := make([]string, len(r.Reasons))
+	for i, reason := range r.Reasons {
+		messages[i] = reason.Message
+	}
+	return strings.Join(messages, "；")
+}
+
+// 按得分倒序，得分相同时按歌曲 ID
+func sortRecommendations(list []Recommendation) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score != list[j].Score {
+			return list[i].Score > list[j].Score
+		}
+		return list[i].Id < list[j].Id
+	})
+}
+
+func quoteTitle(m Music) string {
+	return "《" + m.Title + "》"
+}
+
+func joinLabels(labels []string) string {
+	if len(labels) > explainLabelsShown {
+		return strings.Join(labels[:explainLabelsShown], "、") + " 等"
+	}
+	return strings.Join(labels, "、")
+}
+
+// 歌曲命中的标签，顺序与 labels 一致
+func matchedLabels(m Music, labels []string) []string {
+	var out []string
+	for _, l := range labels {
+		if containsString(m.Labels, l) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// ==== 个性化理由 ====
+
+// 为某个用户生成个性化的推荐理由：喜欢、收藏、常听，以及与收藏的歌曲相似
+type recommendExplainer struct {
+	songs   map[int64]Music
+	taste   *tasteProfile
+	starred map[int64]MusicSimilarity // 候选歌曲 -> 与它最相似的收藏歌曲
+}
+
+// 载入用户的口味画像，并查出 candidates 中与收藏歌曲最相似的一首
+func newRecommendExplainer(userID int64, songs map[int64]Music, candidates []int64, now time.Time) (*recommendExplainer, error) {
+	taste, err := buildTasteProfile(userID, songs, now)
+	if err != nil {
+		return nil, err
+	}
+	e := &recommendExplainer{songs: songs, taste: taste, starred: map[int64]MusicSimilarity{}}
+	if len(taste.starred) == 0 || len(candidates) == 0 {
+		return e, nil
+	}
+
+	var rows []MusicSimilarity
+	err = DB.Where("music_id IN ? AND similar_id IN ?", mapKeys(taste.starred), candidates).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		if cur, ok := e.starred[r.SimilarID]; !ok || r.Score > cur.Score || (r.Score == cur.Score && r.MusicID < cur.MusicID) {
+			e.starred[r.SimilarID] = r
+		}
+	}
+	return e, nil
+}
+
+// 与用户本人有关的理由
+func (e *recommendExplainer) personal(m Music) []RecommendReason {
+	var reasons []RecommendReason
+	if e.taste.liked[m.Id] {
+		reasons = append(reasons, RecommendReason{Type: ReasonLiked, Message: "你喜欢过这首歌", Score: 1})
+	}
+	if e.taste.starred[m.Id] {
+		reasons = append(reasons, RecommendReason{Type: ReasonStarred, Message: "你收藏了这首歌", MusicID: m.Id, Score: 1})
+	} else if s, ok := e.starred[m.Id]; ok {
+		reasons = append(reasons, RecommendReason{
+			Type:    ReasonStarred,
+			Message: "因为你收藏了" + quoteTitle(e.songs[s.MusicID]),
+			MusicID: s.MusicID,
+			Score:   s.Score,
+		})
+	}
+	if n := e.taste.plays[m.Id]; n >= explainHistoryMin {
+		reasons = append(reasons, RecommendReason{
+			Type:    ReasonHistory,
+			Message: fmt.Sprintf("你最近听了 %d 次", n),
+			Score:   0.2 * float64(min(n, 5)),
+		})
+	}
+	return reasons
+}
+
+// 歌曲中用户最偏好的标签
+func (e *recommendExplainer) favoriteLabel(m Music) (string, float64) {
+	var best string
+	var affinity float64
+	for _, l := range m.Labels {
+		if a := e.taste.affinity[l]; a > affinity {
+			best, affinity = l, a
+		}
+	}
+	return best, affinity
+}
+
+// ==== 各推荐接口的理由 ====
+
+// 按标签推荐（AI 对话）：命中的标签越多越靠前，用户喜欢、收藏、常听的歌曲优先，不喜欢的歌曲排除
+func RecommendByLabels(userID int64, labels []string, limit int) ([]Recommendation, error) {
+	list := []Recommendation{}
+	if len(labels) == 0 {
+		return list, nil
+	}
+	songs, err := GetAllSongs()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]Music, len(songs))
+	var candidates []int64
+	for _, m := range songs {
+		byID[m.Id] = m
+		if len(matchedLabels(m, labels)) > 0 {
+			candidates = append(candidates, m.Id)
+		}
+	}
+	e, err := newRecommendExplainer(userID, byID, candidates, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range candidates {
+		m := byID[id]
+		if e.taste.disliked[id] {
+			continue
+		}
+		r := newRecommendation(m)
+		matched := matchedLabels(m, labels)
+		r.add(RecommendReason{
+			Type:    ReasonLabel,
+			Message: "符合你想听的 " + joinLabels(matched),
+			Labels:  matched,
+			Score:   float64(len(matched)),
+		})
+		// 标记为不适合这些标签（delabels）的歌曲降低得分，单独作为一条理由
+		var unfit []string
+		for _, l := range m.DeLabels {
+			if containsString(labels, l) {
+				unfit = append(unfit, l)
+			}
+		}
+		if len(unfit) > 0 {
+			r.add(RecommendReason{
+				Type:    ReasonLabel,
+				Message: "不太适合 " + joinLabels(unfit),
+				Labels:  unfit,
+				Score:   -float64(len(unfit)),
+			})
+		}
+		for _, reason := range e.personal(m) {
+			r.add(reason)
+		}
+		list = append(list, r)
+	}
+	sortRecommendations(list)
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// 按标签检索的结果，以命中的标签作为理由，命中越多越靠前
+func explainLabelSearch(songs []Music, labels []string) []Recommendation {
+	list := make([]Recommendation, 0, len(songs))
+	for _, m := range songs {
+		r := newRecommendation(m)
+		matched := matchedLabels(m, labels)
+		r.add(RecommendReason{
+			Type:    ReasonLabel,
+			Message: "带有标签 " + joinLabels(matched),
+			Labels:  matched,
+			Score:   float64(len(matched)),
+		})
+		list = append(list, r)
+	}
+	sortRecommendations(list)
+	return list
+}
+
+// 相似歌曲的理由：共同的标签、同歌单、同一收听会话
+func explainSimilar(seed Music, rows []MusicSimilarity) []Recommendation {
+	list := make([]Recommendation, 0, len(rows))
+	for _, s := range rows {
+		r := newRecommendation(s.Music)
+		if s.LabelScore > 0 {
+			shared := matchedLabels(s.Music, seed.Labels)
+			msg := "与" + quoteTitle(seed) + "风格相近"
+			if len(shared) > 0 {
+				msg = "与" + quoteTitle(seed) + "都是 " + joinLabels(shared)
+			}
+			r.add(RecommendReason{Type: ReasonLabel, Message: msg, Labels: shared, MusicID: seed.Id, Score: similarLabelWeight * s.LabelScore})
+		}
+		if s.PlaylistScore > 0 {
+			r.add(RecommendReason{Type: ReasonSimilar, Message: "常与" + quoteTitle(seed) + "出现在同一个歌单", MusicID: seed.Id, Score: similarPlaylistWeight * s.PlaylistScore})
+		}
+		if s.SessionScore > 0 {
+			r.add(RecommendReason{Type: ReasonSimilar, Message: "常与" + quoteTitle(seed) + "在同一次收听中播放", MusicID: seed.Id, Score: similarSessionWeight * s.SessionScore})
+		}
+		list = append(list, r)
+	}
+	return list
+}
+
+// 查询相似歌曲并附带理由
+func GetSimilarRecommendations(musicID int64, limit int) ([]Recommendation, error) {
+	seed, err := GetMusicByID(musicID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := GetSimilarMusic(musicID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return explainSimilar(*seed, rows), nil
+}
+
+// 推荐歌单及其中每首歌的推荐理由
+type DailyMixWithReasons struct {
+	Playlist
+	Tracks []Recommendation `json:"tracks"`
+}
+
+// 为推荐歌单中的歌曲补充理由：常听的歌曲说明它为什么被视为常听（得分与生成歌单时一致），新歌说明它符合哪个偏好标签
+func ExplainDailyMixes(userID int64, mixes []Playlist) ([]DailyMixWithReasons, error) {
+	out := make([]DailyMixWithReasons, 0, len(mixes))
+	if len(mixes) == 0 {
+		return out, nil
+	}
+	songs, err := GetAllSongs()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]Music, len(songs))
+	for _, m := range songs {
+		byID[m.Id] = m
+	}
+	taste, err := buildTasteProfile(userID, byID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range mixes {
+		mix := DailyMixWithReasons{Playlist: p, Tracks: make([]Recommendation, 0, len(p.Items))}
+		for _, it := range p.Items {
+			m := it.Music
+			r := newRecommendation(m)
+			label := ""
+			for _, l := range m.Labels {
+				if label == "" || taste.affinity[l] > taste.affinity[label] {
+					label = l
+				}
+			}
+
+			familiar, ok := taste.familiar[m.Id]
+			if !ok {
+				reason := RecommendReason{Type: ReasonDiscovery, Message: "你还没有听过这首歌", Score: taste.discoveryScore(m)}
+				if label != "" {
+					reason.Message = "你还没有听过这首歌，它和你常听的歌曲一样是 " + label
+					reason.Labels = []string{label}
+				}
+				r.add(reason)
+				mix.Tracks = append(mix.Tracks, r)
+				continue
+			}
+			if taste.liked[m.Id] {
+				r.add(RecommendReason{Type: ReasonLiked, Message: "你喜欢过这首歌", Score: 2})
+				familiar -= 2
+			}
+			if taste.starred[m.Id] {
+				r.add(RecommendReason{Type: ReasonStarred, Message: "你收藏了这首歌", MusicID: m.Id, Score: 2})
+				familiar -= 2
+			}
+			if n := taste.plays[m.Id]; n > 0 {
+				r.add(RecommendReason{Type: ReasonHistory, Message: fmt.Sprintf("你最近听了 %d 次", n), Score: familiar})
+			}
+			mix.Tracks = append(mix.Tracks, r)
+		}
+		out = append(out, mix)
+	}
+	return out, nil
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 歌曲反馈
+const (
+	FeedbackLike    = 1
+	FeedbackDislike = -1
+)
+
+// 用户对歌曲的喜欢 / 不喜欢，同一用户对同一首歌只保留最新的一次
+// 收藏仍通过“收藏”歌单记录
+type MusicFeedback struct {
+	ID        int64     `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	UserID    int64     `json:"userId" gorm:"column:user_id;not null;uniqueIndex:idx_music_feedback_uniq"`
+	MusicID   int64     `json:"musicId" gorm:"column:music_id;not null;uniqueIndex:idx_music_feedback_uniq;index"`
+	Value     int       `json:"value" gorm:"column:value;not null"` // 1 喜欢，-1 不喜欢
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;autoUpdateTime"`
+}
+
+func (MusicFeedback) TableName() string {
+	return "music_feedback"
+}
+
+// 记录用户对歌曲的反馈
+func SetMusicFeedback(userID, musicID int64, value int) error {
+	if value != FeedbackLike && value != FeedbackDislike {
+		return errors.New("invalid feedback value")
+	}
+	if _, err := GetMusicByID(musicID); err != nil {
+		return errors.New("music not found")
+	}
+	fb := MusicFeedback{UserID: userID, MusicID: musicID, Value: value, UpdatedAt: time.Now()}
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "music_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&fb).Error
+}
+
+// 用户的全部反馈：musicID -> 1 / -1
+func GetMusicFeedback(userID int64) (map[int64]int, error) {
+	var rows []MusicFeedback
+	if err := DB.Where("user_id = ?", userID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	feedback := make(map[int64]int, len(rows))
+	for _, r := range rows {
+		feedback[r.MusicID] = r.Value
+	}
+	return feedback, nil
+}
+
+// 合并歌曲时转移反馈：用户对保留歌曲已有反馈时以其为准，否则取最近的一次
+func mergeMusicFeedback(tx *gorm.DB, keepID int64, mergeIDs []int64) error {
+	var rows []MusicFeedback
+	if err := tx.Where("music_id = ? OR music_id IN ?", keepID, mergeIDs).Order("updated_at DESC").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	chosen := make(map[int64]MusicFeedback)
+	for _, r := range rows {
+		if cur, ok := chosen[r.UserID]; !ok || (r.MusicID == keepID && cur.MusicID != keepID) {
+			chosen[r.UserID] = r
+		}
+	}
+	for _, r := range rows {
+		if chosen[r.UserID].ID == r.ID {
+			continue
+		}
+		if err := tx.Delete(&MusicFeedback{}, r.ID).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&MusicFeedback{}).Where("music_id IN ?", mergeIDs).Update("music_id", keepID).Error
+}
@@ -0,0 +1,375 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 歌单树中节点的类型
+const (
+	TreeNodeFolder   = "folder"
+	TreeNodePlaylist = "playlist"
+)
+
+const (
+	folderMaxDepth   = 8
+	folderNameMaxLen = 255
+	unplacedPosition = 1 << 30 // 还没有被移动过的歌单排在同级最后
+	folderRootID     = 0       // 根目录
+)
+
+var (
+	ErrFolderNotFound    = errors.New("folder not found")
+	ErrInvalidFolder     = errors.New("invalid folder")
+	ErrInvalidFolderMove = errors.New("invalid folder move")
+)
+
+// 文件夹：每个用户各自整理侧边栏中的歌单，可以包含歌单和其他文件夹
+type PlaylistFolder struct {
+	ID        int64     `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	UserID    int64     `json:"userId" gorm:"column:user_id;not null;index"`
+	ParentID  int64     `json:"parentId" gorm:"column:parent_id;not null;default:0"` // 0 表示根目录
+	Name      string    `json:"name" gorm:"column:name;type:varchar(255);not null"`
+	Position  int       `json:"position" gorm:"column:position;not null"` // 在同级节点（文件夹和歌单）中的顺序
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;autoCreateTime"`
+}
+
+func (PlaylistFolder) TableName() string {
+	return "playlist_folders"
+}
+
+// 歌单在某个用户的歌单树中的位置；没有记录的歌单位于根目录的最后
+type PlaylistFolderEntry struct {
+	UserID     int64 `json:"userId" gorm:"primaryKey;column:user_id"`
+	PlaylistID int64 `json:"playlistId" gorm:"primaryKey;column:playlist_id;index"`
+	FolderID   int64 `json:"folderId" gorm:"column:folder_id;not null;default:0"` // 0 表示根目录
+	Position   int   `json:"position" gorm:"column:position;not null"`
+}
+
+func (PlaylistFolderEntry) TableName() string {
+	return "playlist_folder_entries"
+}
+
+// 歌单树的节点，文件夹和歌单在同级中按用户调整的顺序排列
+type PlaylistTreeNode struct {
+	Type     string             `json:"type"` // 见 TreeNode*
+	ID       int64              `json:"id"`
+	Name     string             `json:"name"`
+	Playlist *SharedPlaylist    `json:"playlist,omitempty"` // 歌单节点：歌单信息及当前用户的角色
+	Children []PlaylistTreeNode `json:"children,omitempty"` // 文件夹节点：子节点
+	position int
+}
+
+// 一个用户的全部文件夹和可见的歌单
+type playlistTree struct {
+	userID    int64
+	folders   map[int64]*PlaylistFolder
+	entries   map[int64]PlaylistFolderEntry
+	playlists []SharedPlaylist // 自己创建的和共享给自己的歌单
+}
+
+func loadPlaylistTree(tx *gorm.DB, userID int64) (*playlistTree, error) {
+	t := &playlistTree{
+		userID:  userID,
+		folders: map[int64]*PlaylistFolder{},
+		entries: map[int64]PlaylistFolderEntry{},
+	}
+	var folders []PlaylistFolder
+	if err := tx.Where("user_id = ?", userID).Find(&folders).Error; err != nil {
+		return nil, err
+	}
+	for i := range folders {
+		t.folders[folders[i].ID] = &folders[i]
+	}
+	var entries []PlaylistFolderEntry
+	if err := tx.Where("user_id = ?", userID).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		t.entries[e.PlaylistID] = e
+	}
+
+	var owned []Playlist
+	if err := tx.Where("owner_user_id = ?", userID).Order("id").Find(&owned).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range owned {
+		t.playlists = append(t.playlists, SharedPlaylist{Playlist: p, Role: PlaylistRoleOwner})
+	}
+	shared, err := sharedPlaylists(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	t.playlists = append(t.playlists, shared...)
+	return t, nil
+}
+
+// 歌单所在的文件夹，文件夹已不存在时视为根目录
+func (t *playlistTree) playlistParent(playlistID int64) (int64, int) {
+	e, ok := t.entries[playlistID]
+	if !ok {
+		return folderRootID, unplacedPosition
+	}
+	if _, ok := t.folders[e.FolderID]; !ok {
+		return folderRootID, e.Position
+	}
+	return e.FolderID, e.Position
+}
+
+// 某个文件夹的直接子节点（不展开下级），按顺序排列
+func (t *playlistTree) children(parentID int64) []PlaylistTreeNode {
+	nodes := []PlaylistTreeNode{}
+	for _, f := range t.folders {
+		if f.ParentID == parentID {
+			nodes = append(nodes, PlaylistTreeNode{Type: TreeNodeFolder, ID: f.ID, Name: f.Name, position: f.Position})
+		}
+	}
+	for i := range t.playlists {
+		p := &t.playlists[i]
+		folderID, position := t.playlistParent(p.ID)
+		if folderID == parentID {
+			nodes = append(nodes, PlaylistTreeNode{Type: TreeNodePlaylist, ID: p.ID, Name: p.Name, Playlist: p, position: position})
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if a.position != b.position {
+			return a.position < b.position
+		}
+		if a.Type != b.Type {
+			return a.Type == TreeNodeFolder
+		}
+		return a.ID < b.ID
+	})
+	return nodes
+}
+
+// 展开整棵子树
+func (t *playlistTree) build(parentID int64) []PlaylistTreeNode {
+	nodes := t.children(parentID)
+	for i := range nodes {
+		if nodes[i].Type == TreeNodeFolder {
+			nodes[i].Children = t.build(nodes[i].ID)
+		}
+	}
+	return nodes
+}
+
+// 文件夹的层级，根目录下的文件夹为 1
+func (t *playlistTree) depth(folderID int64) int {
+	d := 0
+	for f, ok := t.folders[folderID]; ok; f, ok = t.folders[f.ParentID] {
+		d++
+	}
+	return d
+}
+
+// 文件夹子树的高度，不包含子文件夹时为 1
+func (t *playlistTree) height(folderID int64) int {
+	h := 0
+	for _, f := range t.folders {
+		if f.ParentID == folderID {
+			h = max(h, t.height(f.ID))
+		}
+	}
+	return h + 1
+}
+
+// 按 nodes 的顺序重写同级节点的位置
+func (t *playlistTree) place(tx *gorm.DB, parentID int64, nodes []PlaylistTreeNode) error {
+	for i, n := range nodes {
+		if n.Type == TreeNodeFolder {
+			err := tx.Model(&PlaylistFolder{}).Where("id = ?", n.ID).
+				Updates(map[string]any{"parent_id": parentID, "position": i + 1}).Error
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		entry := PlaylistFolderEntry{UserID: t.userID, PlaylistID: n.ID, FolderID: parentID, Position: i + 1}
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&entry).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *playlistTree) checkParent(parentID int64) error {
+	if parentID == folderRootID {
+		return nil
+	}
+	if _, ok := t.folders[parentID]; !ok {
+		return ErrFolderNotFound
+	}
+	return nil
+}
+
+func checkFolderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > folderNameMaxLen {
+		return "", fmt.Errorf("%w: name must be 1-%d bytes", ErrInvalidFolder, folderNameMaxLen)
+	}
+	return name, nil
+}
+
+// ==== 操作函数 ====
+
+// 用户的歌单树：自己创建的和共享给自己的歌单，按文件夹组织
+func GetPlaylistTree(userID int64) ([]PlaylistTreeNode, error) {
+	t, err := loadPlaylistTree(DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	return t.build(folderRootID), nil
+}
+
+// 在 parentID 文件夹（0 为根目录）的最后新建文件夹
+func CreateFolder(userID, parentID int64, name string) (*PlaylistFolder, error) {
+	name, err := checkFolderName(name)
+	if err != nil {
+		return nil, err
+	}
+	folder := PlaylistFolder{UserID: userID, ParentID: parentID, Name: name}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		t, err := loadPlaylistTree(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := t.checkParent(parentID); err != nil {
+			return err
+		}
+		if t.depth(parentID)+1 > folderMaxDepth {
+			return fmt.Errorf("%w: folders can be nested at most %d levels", ErrInvalidFolderMove, folderMaxDepth)
+		}
+		siblings := t.children(parentID)
+		folder.Position = len(siblings) + 1
+		if err := tx.Create(&folder).Error; err != nil {
+			return err
+		}
+		// 没有移动过的歌单也写入位置，新文件夹才能排在它们之后
+		return t.place(tx, parentID, append(siblings, PlaylistTreeNode{Type: TreeNodeFolder, ID: folder.ID}))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// 重命名文件夹
+func RenameFolder(userID, folderID int64, name string) (*PlaylistFolder, error) {
+	name, err := checkFolderName(name)
+	if err != nil {
+		return nil, err
+	}
+	var folder PlaylistFolder
+	if err := DB.Where("id = ? AND user_id = ?", folderID, userID).First(&folder).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFolderNotFound
+		}
+		return nil, err
+	}
+	if err := DB.Model(&folder).Update("name", name).Error; err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// 把文件夹或歌单移动到 parentID 文件夹（0 为根目录）中的第 position 个位置（从 0 开始，-1 表示最后）
+func MoveTreeNode(userID int64, nodeType string, id, parentID int64, position int) ([]PlaylistTreeNode, error) {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		t, err := loadPlaylistTree(tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := t.checkParent(parentID); err != nil {
+			return err
+		}
+
+		switch nodeType {
+		case TreeNodeFolder:
+			if _, ok := t.folders[id]; !ok {
+				return ErrFolderNotFound
+			}
+			// 不能移动到自己或自己的子文件夹中
+			for f, ok := t.folders[parentID]; ok; f, ok = t.folders[f.ParentID] {
+				if f.ID == id {
+					return fmt.Errorf("%w: cannot move a folder into itself", ErrInvalidFolderMove)
+				}
+			}
+			if t.depth(parentID)+t.height(id) > folderMaxDepth {
+				return fmt.Errorf("%w: folders can be nested at most %d levels", ErrInvalidFolderMove, folderMaxDepth)
+			}
+		case TreeNodePlaylist:
+			found := false
+			for _, p := range t.playlists {
+				found = found || p.ID == id
+			}
+			if !found {
+				return ErrPlaylistNotFound
+			}
+		default:
+			return fmt.Errorf("%w: unknown type %q", ErrInvalidFolderMove, nodeType)
+		}
+
+		siblings := []PlaylistTreeNode{}
+		for _, n := range t.children(parentID) {
+			if n.Type != nodeType || n.ID != id {
+				siblings = append(siblings, n)
+			}
+		}
+		if position < 0 || position > len(siblings) {
+			position = len(siblings)
+		}
+		node := PlaylistTreeNode{Type: nodeType, ID: id}
+		siblings = append(siblings[:position], append([]PlaylistTreeNode{node}, siblings[position:]...)...)
+		return t.place(tx, parentID, siblings)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetPlaylistTree(userID)
+}
+
+// 删除文件夹，其中的歌单和子文件夹移到上一级文件夹中原来的位置，歌单本身不受影响
+func DeleteFolder(userID, folderID int64) ([]PlaylistTreeNode, error) {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		t, err := loadPlaylistTree(tx, userID)
+		if err != nil {
+			return err
+		}
+		folder, ok := t.folders[folderID]
+		if !ok {
+			return ErrFolderNotFound
+		}
+		parentID := folder.ParentID
+
+		siblings := []PlaylistTreeNode{}
+		for _, n := range t.children(parentID) {
+			if n.Type == TreeNodeFolder && n.ID == folderID {
+				siblings = append(siblings, t.children(folderID)...)
+				continue
+			}
+			siblings = append(siblings, n)
+		}
+		// 当前不可见的歌单（如已移入回收站）也移到上一级，恢复后不会丢失位置
+		err = tx.Model(&PlaylistFolderEntry{}).Where("user_id = ? AND folder_id = ?", userID, folderID).
+			Update("folder_id", parentID).Error
+		if err != nil {
+			return err
+		}
+		if err := t.place(tx, parentID, siblings); err != nil {
+			return err
+		}
+		return tx.Delete(&PlaylistFolder{}, folderID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetPlaylistTree(userID)
+}
@@ -5,14 +5,12 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"time"
 
 	"github.com/lib/pq"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-// DB 是全局 GORM 数据库实例
+// DB 是全局 GORM 数据库实例，即 Repo.DB()；方言相关的查询放在 Repository 中
 var DB *gorm.DB
 
 func InitDB() error {
@@ -21,59 +19,66 @@ func InitDB() error {
 		return ErrNoDatabaseURL
 	}
 
-	var err error
-	for i := 0; i < 10; i++ {
-		DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-		if err == nil {
-			// 确保底层连接可用
-			sqlDB, cerr := DB.DB()
-			if cerr == nil {
-				if pingErr := sqlDB.Ping(); pingErr == nil {
-					err = nil
-					break
-				} else {
-					err = pingErr
-				}
-			} else {
-				err = cerr
-			}
-		}
-		log.Printf("failed to connect to db (attempt %d/10): %v, retrying...", i+1, err)
-		time.Sleep(2 * time.Second)
-	}
-
+	repo, err := openRepository(dsn)
 	if err != nil {
 		return fmt.Errorf("failed to initialize database, got error %w", err)
 	}
+	Repo, DB = repo, repo.DB()
 
 	// GORM 将自动创建/更新表以匹配 Go 结构体
-	err = DB.AutoMigrate(
+	err = Repo.Migrate(
 		&Music{},
 		// &core.User{},
 		&Playlist{},
 		&PlaylistItem{},
 		&PlayHistory{},
+		&MusicWaveform{},
+		&ScrobbleQueue{},
+		&MusicFeedback{},
+		&MusicSimilarity{},
+		&MusicColisten{},
+		&SimilarityState{},
+		&RadioStation{},
+		&PlayQueue{},
+		&PlaylistMember{},
+		&PlaylistActivity{},
+		&ShareLink{},
+		&PlaylistVersion{},
+		&PlaylistFolder{},
+		&PlaylistFolderEntry{},
 	)
 	if err != nil {
 		log.Printf("AutoMigrate error: %v", err)
 		// 在开发中，我们继续；在生产中，这应该是一个致命错误
 	}
 
-	// 创建索引
-	if execErr := DB.Exec("CREATE INDEX IF NOT EXISTS idx_music_labels_gin ON music USING GIN (labels);").Error; execErr != nil {
-		log.Printf("failed to create idx_music_labels_gin: %v", execErr)
-	}
-	if execErr := DB.Exec("CREATE INDEX IF NOT EXISTS idx_music_delabels_gin ON music USING GIN (delabels);").Error; execErr != nil {
-		log.Printf("failed to create idx_music_delabels_gin: %v", execErr)
-	}
-
 	// 填充数据
 	seedData(DB)
+	migrateStarPlaylist(DB)
+
+	// 后台分析新导入歌曲的响度，避免阻塞启动
+	go func() {
+		if err := AnalyzeLibraryLoudness(false); err != nil {
+			log.Printf("loudness analysis failed: %v", err)
+		}
+	}()
 
-	log.Println("数据库初始化完成，已解析资源")
+	log.Printf("数据库初始化完成（%s），已解析资源", Repo.Backend())
 	return nil
 }
 
+// 早期所有用户共用 ID 为 StarPlaylistID 的收藏歌单，把它交给创建者作为其收藏歌单
+func migrateStarPlaylist(db *gorm.DB) {
+	var count int64
+	if err := db.Model(&Playlist{}).Where("kind = ?", PlaylistKindFavorites).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+	if err := db.Model(&Playlist{}).Where("id = ? AND kind = ?", StarPlaylistID, PlaylistKindUser).
+		Update("kind", PlaylistKindFavorites).Error; err != nil {
+		log.Printf("failed to migrate star playlist: %v", err)
+	}
+}
+
 // seedData 使用 GORM 的 FirstOrCreate 来安全地填充数据
 func seedData(db *gorm.DB) {
 	// 定义与 seed.json 对应的临时结构
@@ -90,6 +95,7 @@ func seedData(db *gorm.DB) {
 	type seedMusic struct {
 		Title     string         `json:"title"`
 		Singer    string         `json:"singer"`
+		Album     string         `json:"album"`
 		Labels    pq.StringArray `json:"labels"`
 		AudioURL  string         `json:"audioUrl"`
 		CoverURL  string         `json:"coverUrl"`
@@ -119,6 +125,7 @@ func seedData(db *gorm.DB) {
 		mm := Music{
 			Title:     m.Title,
 			Singer:    m.Singer,
+			Album:     m.Album,
 			Labels:    m.Labels,
 			AudioURL:  m.AudioURL,
 			CoverURL:  m.CoverURL,
@@ -126,6 +133,14 @@ func seedData(db *gorm.DB) {
 		}
 		if err := db.Where("audio_path = ?", mm.AudioURL).FirstOrCreate(&mm).Error; err != nil {
 			log.Printf("failed to create music %s: %v", mm.Title, err)
+			continue
+		}
+
+		// 计算内容指纹，用于重复歌曲检测
+		if mm.ContentHash == "" {
+			if err := UpdateMusicContentHash(&mm); err != nil {
+				log.Printf("failed to hash music %s: %v", mm.Title, err)
+			}
 		}
 	}
 
@@ -0,0 +1,232 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 音频请求自动记录（PlaySourceAuto）的去重窗口：只用于合并浏览器的多次 Range 请求，
+// 客户端上报的 start 事件是明确的播放，不受此窗口限制
+const playDedupWindow = 30 * time.Second
+
+// 播放事件类型
+const (
+	PlayEventStart    = "start"
+	PlayEventProgress = "progress"
+	PlayEventEnd      = "end"
+)
+
+// 客户端上报的播放事件
+type PlayEvent struct {
+	PlayID     int64  `json:"playId"`     // start 事件返回的播放记录 ID，progress/end 事件必填
+	MusicID    int64  `json:"musicId"`    // start 事件必填
+	Event      string `json:"event"`      // start / progress / end
+	PositionMs int64  `json:"positionMs"` // 当前播放位置
+	ListenedMs *int64 `json:"listenedMs"` // 累计收听时长，不填时按播放到的最远位置计算
+	Reason     string `json:"reason"`     // end 事件的结束原因：completed / skipped / error
+}
+
+// 判断音频请求是否为一次播放的开始（无 Range 或从 0 字节开始）
+// 拖动进度条等产生的 Range 请求不应记为新的播放
+func isInitialRangeRequest(r *http.Request) bool {
+	rng := strings.TrimSpace(r.Header.Get("Range"))
+	return rng == "" || strings.HasPrefix(rng, "bytes=0-")
+}
+
+// 处理客户端上报的播放事件，返回对应的播放记录
+// 隐私收听的会话不记录任何事件，返回 nil
+func ReportPlayEvent(ev PlayEvent, userID int64, sessionID string) (*PlayHistory, error) {
+	if IsPrivateSession(sessionID) {
+		return nil, nil
+	}
+	switch ev.Event {
+	case PlayEventStart:
+		return startPlay(ev, userID)
+	case PlayEventProgress, PlayEventEnd:
+		return updatePlay(ev, userID)
+	default:
+		return nil, errors.New("unknown play event: " + ev.Event)
+	}
+}
+
+// 开始播放：若刚由音频请求自动记录过同一首歌，则接管那条记录，避免重复
+func startPlay(ev PlayEvent, userID int64) (*PlayHistory, error) {
+	music, err := GetMusicByID(ev.MusicID)
+	if err != nil {
+		return nil, errors.New("music not found")
+	}
+
+	var ph PlayHistory
+	err = DB.Where("user_id = ? AND music_id = ? AND source = ? AND ended_at IS NULL AND played_at > ?",
+		userID, ev.MusicID, PlaySourceAuto, time.Now().Add(-playDedupWindow)).
+		Order("played_at DESC").First(&ph).Error
+	switch {
+	case err == nil:
+		ph.Source = PlaySourceEvent
+		ph.PositionMs = ev.PositionMs
+		if err := DB.Omit("Music").Save(&ph).Error; err != nil {
+			return nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ph = PlayHistory{
+			UserID:     userID,
+			MusicID:    ev.MusicID,
+			PlayedAt:   time.Now(),
+			Source:     PlaySourceEvent,
+			PositionMs: ev.PositionMs,
+		}
+		if err := DB.Create(&ph).Error; err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	ph.Music = *music
+	return &ph, nil
+}
+
+// 播放进度心跳与播放结束
+func updatePlay(ev PlayEvent, userID int64) (*PlayHistory, error) {
+	var ph PlayHistory
+	if err := DB.Preload("Music").Where("user_id = ?", userID).First(&ph, ev.PlayID).Error; err != nil {
+		return nil, errors.New("play not found")
+	}
+	if ph.EndedAt != nil {
+		return nil, errors.New("play already ended")
+	}
+
+	ph.PositionMs = ev.PositionMs
+	listened := ev.PositionMs
+	if ev.ListenedMs != nil {
+		listened = *ev.ListenedMs
+	}
+	if listened > ph.ListenedMs {
+		ph.ListenedMs = listened
+	}
+
+	if ev.Event == PlayEventEnd {
+		switch ev.Reason {
+		case PlayEndCompleted, PlayEndSkipped, PlayEndError:
+		default:
+			return nil, errors.New("invalid end reason: " + ev.Reason)
+		}
+		now := time.Now()
+		ph.EndReason = ev.Reason
+		ph.Completed = ev.Reason == PlayEndCompleted
+		ph.EndedAt = &now
+	}
+
+	if err := DB.Omit("Music").Save(&ph).Error; err != nil {
+		return nil, err
+	}
+	return &ph, nil
+}
+
+// 播放时间线：逐条返回播放记录，按时间倒序分页
+func GetPlayTimeline(r StatsRange, page, pageSize int) ([]PlayHistory, int64, error) {
+	query := DB.Model(&PlayHistory{}).Where("user_id = ?", r.UserID)
+	if !r.From.IsZero() {
+		query = query.Where("played_at >= ?", r.From)
+	}
+	if !r.To.IsZero() {
+		query = query.Where("played_at < ?", r.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	entries := []PlayHistory{}
+	err := query.Preload("Music").
+		Order("played_at DESC, id DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&entries).Error
+	return entries, total, err
+}
+
+// 按歌曲汇总的播放历史
+type TrackHistory struct {
+	MusicID      int64     `json:"-"`
+	Plays        int64     `json:"plays"`
+	ListenedMs   int64     `json:"listenedMs"`
+	LastPlayedAt time.Time `json:"lastPlayedAt"`
+	Music        Music     `json:"music" gorm:"-"`
+}
+
+// 按歌曲汇总播放次数，按最后播放时间倒序分页
+func GetTrackHistory(r StatsRange, page, pageSize int) ([]TrackHistory, int64, error) {
+	var total int64
+	if err := statsQuery(r).Distinct("play_history.music_id").Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	// SQLite 中 MAX(played_at) 是文本，先扫描到 dbTime
+	var rows []struct {
+		MusicID      int64
+		Plays        int64
+		ListenedMs   int64
+		LastPlayedAt dbTime
+	}
+	err := statsQuery(r).
+		Select("play_history.music_id AS music_id, COUNT(*) AS plays, " + sumListenedMs + " AS listened_ms, MAX(play_history.played_at) AS last_played_at").
+		Group("play_history.music_id").
+		Order("last_played_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	entries := make([]TrackHistory, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, TrackHistory{MusicID: row.MusicID, Plays: row.Plays, ListenedMs: row.ListenedMs, LastPlayedAt: row.LastPlayedAt.Time})
+	}
+
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.MusicID
+	}
+	var songs []Music
+	if err := DB.Where("id IN ?", ids).Find(&songs).Error; err != nil {
+		return nil, 0, err
+	}
+	byID := make(map[int64]Music, len(songs))
+	for _, m := range songs {
+		byID[m.Id] = m
+	}
+	for i := range entries {
+		entries[i].Music = byID[entries[i].MusicID]
+	}
+	return entries, total, nil
+}
+
+// 删除用户指定的播放记录，其他用户的记录不受影响
+func DeletePlayHistory(userID int64, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, errors.New("no play history specified")
+	}
+	result := DB.Where("user_id = ? AND id IN ?", userID, ids).Delete(&PlayHistory{})
+	return result.RowsAffected, result.Error
+}
+
+// 删除用户在时间范围内的播放记录，至少需要指定一端
+func DeletePlayHistoryRange(r StatsRange) (int64, error) {
+	if r.From.IsZero() && r.To.IsZero() {
+		return 0, errors.New("time range required")
+	}
+	query := DB.Model(&PlayHistory{}).Where("user_id = ?", r.UserID)
+	if !r.From.IsZero() {
+		query = query.Where("played_at >= ?", r.From)
+	}
+	if !r.To.IsZero() {
+		query = query.Where("played_at < ?", r.To)
+	}
+	result := query.Delete(&PlayHistory{})
+	return result.RowsAffected, result.Error
+}
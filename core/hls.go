@@ -0,0 +1,275 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	hlsPlaylistName    = "index.m3u8"
+	hlsSegmentDuration = 6.0 // 目标分片时长（秒）
+)
+
+var hlsSegmentPattern = regexp.MustCompile(`^seg\d{5}\.mp3$`)
+
+var ErrHLSUnsupported = errors.New("hls streaming only supports mp3")
+
+// HLS 分片缓存目录，可通过 HLS_CACHE_DIR 配置
+func hlsCacheDir() string {
+	if dir := os.Getenv("HLS_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "nmp-hls")
+}
+
+// 同一首歌的分片只生成一次
+var hlsLocks sync.Map
+
+// PrepareHLS 确保歌曲的 HLS 播放列表和分片已生成，返回缓存目录
+// 缓存目录名包含音频指纹（或文件大小与修改时间），音频变化后会重新生成
+func PrepareHLS(music *Music) (string, error) {
+	if strings.ToLower(filepath.Ext(music.AudioURL)) != ".mp3" {
+		return "", ErrHLSUnsupported
+	}
+	fullPath, err := resolveAssetPath(music.AudioURL)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", err
+	}
+
+	version := music.ContentHash
+	if len(version) > 16 {
+		version = version[:16]
+	}
+	if version == "" {
+		version = fmt.Sprintf("%x-%x", info.Size(), info.ModTime().Unix())
+	}
+	dir := filepath.Join(hlsCacheDir(), fmt.Sprintf("%d-%s", music.Id, version))
+
+	lock, _ := hlsLocks.LoadOrStore(music.Id, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, err := os.Stat(filepath.Join(dir, hlsPlaylistName)); err == nil {
+		return dir, nil
+	}
+
+	// 先写入临时目录再重命名，避免客户端读到不完整的分片
+	if err := os.MkdirAll(hlsCacheDir(), 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(hlsCacheDir(), fmt.Sprintf("%d-tmp-", music.Id))
+	if err != nil {
+		return "", err
+	}
+	if err := segmentMP3(fullPath, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+
+	// 清理该歌曲的旧版本缓存
+	if old, _ := filepath.Glob(filepath.Join(hlsCacheDir(), fmt.Sprintf("%d-*", music.Id))); len(old) > 0 {
+		for _, o := range old {
+			if o != tmp {
+				os.RemoveAll(o)
+			}
+		}
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	return dir, nil
+}
+
+// MP3 帧信息
+type mp3Frame struct {
+	offset     int64
+	size       int
+	samples    int
+	sampleRate int
+}
+
+var (
+	mp3BitratesV1L1 = [16]int{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0}
+	mp3BitratesV1L2 = [16]int{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0}
+	mp3BitratesV1L3 = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mp3BitratesV2L1 = [16]int{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0}
+	mp3BitratesV2L2 = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	mp3SampleRates  = [4][3]int{
+		{11025, 12000, 8000},  // MPEG 2.5
+		{0, 0, 0},             // 保留
+		{22050, 24000, 16000}, // MPEG 2
+		{44100, 48000, 32000}, // MPEG 1
+	}
+)
+
+// 解析 4 字节 MPEG 音频帧头，无效时返回 false
+func parseMP3FrameHeader(h []byte) (mp3Frame, bool) {
+	if h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	version := (h[1] >> 3) & 0x03 // 0: 2.5, 2: 2, 3: 1
+	layer := (h[1] >> 1) & 0x03   // 1: III, 2: II, 3: I
+	bitrateIdx := h[2] >> 4
+	rateIdx := (h[2] >> 2) & 0x03
+	padding := int((h[2] >> 1) & 0x01)
+	if version == 1 || layer == 0 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+		return mp3Frame{}, false
+	}
+
+	sampleRate := mp3SampleRates[version][rateIdx]
+	var bitrate int
+	switch {
+	case version == 3 && layer == 3:
+		bitrate = mp3BitratesV1L1[bitrateIdx]
+	case version == 3 && layer == 2:
+		bitrate = mp3BitratesV1L2[bitrateIdx]
+	case version == 3:
+		bitrate = mp3BitratesV1L3[bitrateIdx]
+	case layer == 3:
+		bitrate = mp3BitratesV2L1[bitrateIdx]
+	default:
+		bitrate = mp3BitratesV2L2[bitrateIdx]
+	}
+	bitrate *= 1000
+
+	f := mp3Frame{sampleRate: sampleRate}
+	switch {
+	case layer == 3: // Layer I
+		f.samples = 384
+		f.size = (12*bitrate/sampleRate + padding) * 4
+	case layer == 2 || version == 3: // Layer II，或 MPEG 1 Layer III
+		f.samples = 1152
+		f.size = 144*bitrate/sampleRate + padding
+	default: // MPEG 2/2.5 Layer III
+		f.samples = 576
+		f.size = 72*bitrate/sampleRate + padding
+	}
+	return f, f.size > 4
+}
+
+// 扫描 MP3 文件中的全部音频帧
+func scanMP3Frames(r io.ReaderAt, start, end int64) ([]mp3Frame, error) {
+	var frames []mp3Frame
+	head := make([]byte, 4)
+	for pos := start; pos+4 <= end; {
+		if _, err := r.ReadAt(head, pos); err != nil {
+			return nil, err
+		}
+		f, ok := parseMP3FrameHeader(head)
+		if !ok || pos+int64(f.size) > end {
+			pos++ // 丢失同步，逐字节重新寻找帧头
+			continue
+		}
+		f.offset = pos
+		frames = append(frames, f)
+		pos += int64(f.size)
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("no mp3 frames found")
+	}
+	return frames, nil
+}
+
+// 将 MP3 按帧边界切分为 HLS Packed Audio 分片，并写出播放列表
+func segmentMP3(fullPath, dir string) error {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	start, end, err := audioPayloadRange(f, info.Size())
+	if err != nil {
+		return err
+	}
+	frames, err := scanMP3Frames(f, start, end)
+	if err != nil {
+		return err
+	}
+
+	var (
+		playlist    bytes.Buffer
+		entries     bytes.Buffer
+		maxDuration float64
+		elapsed     int64 // 已输出的采样数（以首帧采样率计）
+	)
+	sampleRate := frames[0].sampleRate
+	for seg, i := 0, 0; i < len(frames); seg++ {
+		// 收集约 hlsSegmentDuration 秒的帧
+		j, samples := i, 0
+		for j < len(frames) && float64(samples) < hlsSegmentDuration*float64(sampleRate) {
+			samples += frames[j].samples
+			j++
+		}
+
+		name := fmt.Sprintf("seg%05d.mp3", seg)
+		out, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		// Packed Audio 分片需以携带时间戳的 ID3 标签开头
+		pts := uint64(elapsed) * 90000 / uint64(sampleRate)
+		_, err = out.Write(hlsTimestampTag(pts))
+		if err == nil {
+			last := frames[j-1]
+			_, err = io.Copy(out, io.NewSectionReader(f, frames[i].offset, last.offset+int64(last.size)-frames[i].offset))
+		}
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+
+		duration := float64(samples) / float64(sampleRate)
+		maxDuration = math.Max(maxDuration, duration)
+		fmt.Fprintf(&entries, "#EXTINF:%.3f,\n%s\n", duration, name)
+		elapsed += int64(samples)
+		i = j
+	}
+
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(maxDuration)))
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	playlist.Write(entries.Bytes())
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+	return os.WriteFile(filepath.Join(dir, hlsPlaylistName), playlist.Bytes(), 0o644)
+}
+
+// 构造带有 com.apple.streaming.transportStreamTimestamp PRIV 帧的 ID3v2.4 标签
+func hlsTimestampTag(pts uint64) []byte {
+	owner := "com.apple.streaming.transportStreamTimestamp"
+	payload := make([]byte, 0, len(owner)+9)
+	payload = append(payload, owner...)
+	payload = append(payload, 0)
+	payload = binary.BigEndian.AppendUint64(payload, pts&0x1FFFFFFFF) // 33 位 PTS
+
+	frame := append([]byte("PRIV"), syncsafe(len(payload))...)
+	frame = append(frame, 0, 0)
+	frame = append(frame, payload...)
+
+	tag := append([]byte{'I', 'D', '3', 4, 0, 0}, syncsafe(len(frame))...)
+	return append(tag, frame...)
+}
+
+func syncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
@@ -0,0 +1,570 @@
+package core
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 默认忽略收听不足 30 秒的记录（与收听上报的门槛一致）
+const defaultImportMinPlayedMs = 30000
+
+// 导入请求体的大小上限（Spotify 的数据下载 zip 包可能有上百 MB，上传的文件保存在临时文件中解析，不整体读入内存）
+const maxHistoryImportSize = 256 << 20
+
+// 导入的数据格式
+const (
+	ImportFormatSpotify         = "spotify"          // StreamingHistory*.json
+	ImportFormatSpotifyExtended = "spotify-extended" // Streaming_History_Audio_*.json（扩展收听记录）
+	ImportFormatListenBrainz    = "listenbrainz"
+	ImportFormatLastFM          = "lastfm"
+	ImportFormatCSV             = "csv"
+)
+
+// 从外部导出文件中解析出的一次收听
+type ImportedPlay struct {
+	Title     string
+	Singer    string
+	Album     string
+	PlayedAt  time.Time // 开始收听的时间
+	PlayedMs  int64     // 实际收听时长，-1 表示未知
+	EndReason string
+}
+
+// 未能匹配到曲库的歌曲，同一首歌合并计数
+type UnmatchedImport struct {
+	Title  string `json:"title"`
+	Singer string `json:"singer"`
+	Count  int    `json:"count"`
+}
+
+// 导入结果
+type HistoryImportResult struct {
+	Formats    []string          `json:"formats"`    // 识别出的格式
+	Total      int               `json:"total"`      // 解析出的收听条数
+	Invalid    int               `json:"invalid"`    // 缺少歌名、时间等无法使用的条目（如播客、正在播放）
+	TooShort   int               `json:"tooShort"`   // 收听时长不足门槛
+	Duplicates int               `json:"duplicates"` // 已存在相同的播放记录
+	Imported   int               `json:"imported"`   // 新写入的播放记录（dryRun 时为将要写入的条数）
+	Unmatched  []UnmatchedImport `json:"unmatched"`  // 按出现次数倒序
+	DryRun     bool              `json:"dryRun"`
+}
+
+// 解析后的导出文件
+type parsedExport struct {
+	formats map[string]bool
+	plays   []ImportedPlay
+	invalid int
+}
+
+func (p *parsedExport) add(format string, play ImportedPlay, ok bool) {
+	if !ok || strings.TrimSpace(play.Title) == "" || strings.TrimSpace(play.Singer) == "" || play.PlayedAt.IsZero() {
+		p.invalid++
+		return
+	}
+	p.formats[format] = true
+	p.plays = append(p.plays, play)
+}
+
+// ==== 解析 ====
+
+// 一个上传的收听记录文件；内容按需从 Data 中读取，zip 包和大文件不会整体载入内存
+type ListenExportFile struct {
+	Name string
+	Data io.ReaderAt
+	Size int64
+}
+
+// 解析一个导出文件，自动识别 Spotify、ListenBrainz、Last.fm 的 JSON / JSONL / CSV 以及包含它们的 zip
+func parseListenExport(f ListenExportFile, p *parsedExport) error {
+	magic := make([]byte, 4)
+	if n, _ := f.Data.ReadAt(magic, 0); n == len(magic) && bytes.Equal(magic, []byte("PK\x03\x04")) {
+		return parseListenZip(f, p)
+	}
+	return parseListenStream(f.Name, io.NewSectionReader(f.Data, 0, f.Size), p)
+}
+
+// 按第一个非空白字符识别 JSON 数组、JSON 对象 / JSONL 或 CSV，边读边解析
+func parseListenStream(name string, r io.Reader, p *parsedExport) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	if bom, err := br.Peek(3); err == nil && bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return fmt.Errorf("%s: empty file", name)
+	}
+	if err != nil {
+		return err
+	}
+	switch first {
+	case '[':
+		return parseListenJSONArray(br, p)
+	case '{':
+		return parseListenJSONObjects(br, p)
+	default:
+		return parseListenCSV(br, p)
+	}
+}
+
+// 跳过空白，返回下一个字符但不消耗它
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// Spotify 的数据下载是 zip 包，其中还有歌单、账户等无关文件，解析失败的文件直接跳过
+func parseListenZip(f ListenExportFile, p *parsedExport) error {
+	zr, err := zip.NewReader(f.Data, f.Size)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, zf := range zr.File {
+		ext := strings.ToLower(zf.Name[strings.LastIndex(zf.Name, ".")+1:])
+		if zf.FileInfo().IsDir() || (ext != "json" && ext != "jsonl" && ext != "csv") {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			continue
+		}
+		sub := &parsedExport{formats: map[string]bool{}}
+		err = parseListenStream(zf.Name, rc, sub)
+		rc.Close()
+		if err == nil && len(sub.plays) > 0 {
+			for format := range sub.formats {
+				p.formats[format] = true
+			}
+			p.plays = append(p.plays, sub.plays...)
+			p.invalid += sub.invalid
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("no listening history found in zip")
+	}
+	return nil
+}
+
+// 各种 JSON 导出中单条记录的字段并集
+type exportEntry struct {
+	// Spotify StreamingHistory*.json
+	EndTime    string `json:"endTime"`
+	ArtistName string `json:"artistName"`
+	TrackName  string `json:"trackName"`
+	MsPlayed   *int64 `json:"msPlayed"`
+
+	// Spotify 扩展收听记录
+	TS          string `json:"ts"`
+	MsPlayedExt *int64 `json:"ms_played"`
+	MetaTrack   string `json:"master_metadata_track_name"`
+	MetaArtist  string `json:"master_metadata_album_artist_name"`
+	MetaAlbum   string `json:"master_metadata_album_album_name"`
+	ReasonEnd   string `json:"reason_end"`
+	SpotifySkip *bool  `json:"skipped"`
+
+	// ListenBrainz
+	ListenedAt    json.RawMessage    `json:"listened_at"`
+	TrackMetadata *listenBrainzTrack `json:"track_metadata"`
+
+	// Last.fm user.getRecentTracks
+	lastFMTrack
+	Attr struct {
+		NowPlaying string `json:"nowplaying"`
+	} `json:"@attr"`
+}
+
+// 逐条解码 JSON 数组中的记录
+func parseListenJSONArray(r io.Reader, p *parsedExport) error {
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("unrecognized json export: %w", err)
+	}
+	for dec.More() {
+		var e exportEntry
+		if err := dec.Decode(&e); err != nil {
+			return fmt.Errorf("unrecognized json export: %w", err)
+		}
+		format, play, ok := e.toPlay()
+		p.add(format, play, ok)
+	}
+	return nil
+}
+
+// 以 { 开头的文件：第一行就是完整对象且后面还有内容的按 JSONL 逐行解析，
+// 否则是单个 JSON 对象（带外层包装的 API 响应，体积不大），整体解码
+func parseListenJSONObjects(br *bufio.Reader, p *parsedExport) error {
+	line, err := br.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if json.Valid(line) {
+		if _, err := peekNonSpace(br); err == io.EOF {
+			return parseListenJSON(line, p)
+		}
+		return parseListenJSONL(io.MultiReader(bytes.NewReader(line), br), p)
+	}
+	data, err := io.ReadAll(io.MultiReader(bytes.NewReader(line), br))
+	if err != nil {
+		return err
+	}
+	if json.Valid(data) {
+		return parseListenJSON(data, p)
+	}
+	return parseListenJSONL(bytes.NewReader(data), p)
+}
+
+func parseListenJSON(data []byte, p *parsedExport) error {
+	var raw json.RawMessage = bytes.TrimSpace(data)
+	// 去掉常见的外层包装：{"payload": [...]}、{"payload": {"listens": [...]}}、{"recenttracks": {"track": [...]}}
+	for i := 0; i < 3 && len(raw) > 0 && raw[0] == '{'; i++ {
+		var wrapper map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &wrapper); err != nil {
+			return err
+		}
+		next, ok := json.RawMessage(nil), false
+		for _, key := range []string{"payload", "listens", "recenttracks", "track"} {
+			if next, ok = wrapper[key]; ok {
+				break
+			}
+		}
+		if !ok {
+			// 单条记录
+			raw = append(append(json.RawMessage("["), raw...), ']')
+			break
+		}
+		raw = next
+	}
+	return parseListenJSONArray(bytes.NewReader(raw), p)
+}
+
+// ListenBrainz 的完整导出为每行一条记录
+func parseListenJSONL(r io.Reader, p *parsedExport) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e exportEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			p.invalid++
+			continue
+		}
+		format, play, ok := e.toPlay()
+		p.add(format, play, ok)
+	}
+	return sc.Err()
+}
+
+func (e *exportEntry) toPlay() (string, ImportedPlay, bool) {
+	switch {
+	case e.TrackMetadata != nil:
+		t, ok := parseImportTime(strings.Trim(string(e.ListenedAt), `"`))
+		return ImportFormatListenBrainz, ImportedPlay{
+			Title:    e.TrackMetadata.TrackName,
+			Singer:   e.TrackMetadata.ArtistName,
+			Album:    e.TrackMetadata.ReleaseName,
+			PlayedAt: t,
+			PlayedMs: -1,
+		}, ok
+
+	case e.TS != "":
+		// ts 为播放结束的时间；没有歌名的是播客或有声书
+		end, ok := parseImportTime(e.TS)
+		played := int64(0)
+		if e.MsPlayedExt != nil {
+			played = *e.MsPlayedExt
+		}
+		play := ImportedPlay{
+			Title:    e.MetaTrack,
+			Singer:   e.MetaArtist,
+			Album:    e.MetaAlbum,
+			PlayedAt: end.Add(-time.Duration(played) * time.Millisecond),
+			PlayedMs: played,
+		}
+		switch {
+		case e.ReasonEnd == "trackdone":
+			play.EndReason = PlayEndCompleted
+		case e.SpotifySkip != nil && *e.SpotifySkip, e.ReasonEnd == "fwdbtn", e.ReasonEnd == "backbtn":
+			play.EndReason = PlayEndSkipped
+		}
+		return ImportFormatSpotifyExtended, play, ok
+
+	case e.MsPlayed != nil && e.EndTime != "":
+		// endTime 为 UTC 时间，精确到分钟
+		end, ok := parseImportTime(e.EndTime)
+		return ImportFormatSpotify, ImportedPlay{
+			Title:    e.TrackName,
+			Singer:   e.ArtistName,
+			PlayedAt: end.Add(-time.Duration(*e.MsPlayed) * time.Millisecond),
+			PlayedMs: *e.MsPlayed,
+		}, ok
+
+	case e.Name != "":
+		if e.Attr.NowPlaying == "true" {
+			return ImportFormatLastFM, ImportedPlay{}, false
+		}
+		t, ok := parseImportTime(e.Date.UTS)
+		if !ok {
+			t, ok = parseImportTime(e.Date.Text)
+		}
+		return ImportFormatLastFM, ImportedPlay{
+			Title:    e.Name,
+			Singer:   e.Artist.Text,
+			Album:    e.Album.Text,
+			PlayedAt: t,
+			PlayedMs: -1,
+		}, ok
+	}
+	return "", ImportedPlay{}, false
+}
+
+// CSV 中可能出现的列名
+var importCSVColumns = map[string][]string{
+	"time":   {"listened_at", "uts", "timestamp", "ts", "endtime", "utc_time", "date", "time"},
+	"artist": {"artist_name", "artist", "artistname", "singer"},
+	"track":  {"track_name", "track", "trackname", "title", "name"},
+	"album":  {"release_name", "album", "album_name"},
+	"played": {"ms_played", "msplayed"},
+}
+
+// 解析 CSV：有表头时按列名识别；没有表头时按 Last.fm 导出工具的 artist,album,track,date 列序
+func parseListenCSV(r io.Reader, p *parsedExport) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	first, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unrecognized csv export: %w", err)
+	}
+
+	cols := map[string]int{}
+	header := map[string]int{}
+	for i, name := range first {
+		header[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for key, names := range importCSVColumns {
+		for _, name := range names {
+			if i, ok := header[name]; ok {
+				cols[key] = i
+				break
+			}
+		}
+	}
+	_, hasTime := cols["time"]
+	_, hasTrack := cols["track"]
+	headerRow := hasTime && hasTrack
+	if !headerRow {
+		cols = map[string]int{"artist": 0, "album": 1, "track": 2, "time": 3}
+	}
+
+	field := func(rec []string, key string) string {
+		if i, ok := cols[key]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	for rec := first; ; {
+		if !headerRow {
+			t, ok := parseImportTime(field(rec, "time"))
+			play := ImportedPlay{
+				Title:    field(rec, "track"),
+				Singer:   field(rec, "artist"),
+				Album:    field(rec, "album"),
+				PlayedAt: t,
+				PlayedMs: -1,
+			}
+			if ms, err := strconv.ParseInt(field(rec, "played"), 10, 64); err == nil {
+				play.PlayedMs = ms
+			}
+			p.add(ImportFormatCSV, play, ok)
+		}
+		headerRow = false
+		if rec, err = cr.Read(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("unrecognized csv export: %w", err)
+		}
+	}
+}
+
+// 导出文件中出现过的时间格式，不带时区的均按 UTC 处理
+var importTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	lastFMDateLayout,
+	"2 Jan 2006 15:04",
+	"2 Jan 2006, 15:04",
+}
+
+// 解析时间：Unix 秒（或毫秒）时间戳及常见的文本格式
+func parseImportTime(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "null" {
+		return time.Time{}, false
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), true
+		}
+		return time.Unix(n, 0), true
+	}
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ==== 导入 ====
+
+// 一次导入的上传文件（歌单文件，体积较小，整体读入内存）
+type ImportFile struct {
+	Name string
+	Data []byte
+}
+
+// 导入外部收听记录：按规范化的标题和歌手匹配曲库，以原始播放时间写入播放历史
+// 已存在的相同播放（同一首歌、同一秒）会被跳过，因此同一文件可以重复导入
+func ImportPlayHistory(userID int64, files []ListenExportFile, minPlayedMs int64, dryRun bool) (*HistoryImportResult, error) {
+	parsed := &parsedExport{formats: map[string]bool{}}
+	for _, f := range files {
+		if err := parseListenExport(f, parsed); err != nil {
+			return nil, err
+		}
+	}
+	if len(parsed.plays) == 0 {
+		return nil, errors.New("no listening history found")
+	}
+
+	result := &HistoryImportResult{
+		Formats:   []string{},
+		Total:     len(parsed.plays) + parsed.invalid,
+		Invalid:   parsed.invalid,
+		Unmatched: []UnmatchedImport{},
+		DryRun:    dryRun,
+	}
+	for f := range parsed.formats {
+		result.Formats = append(result.Formats, f)
+	}
+	sort.Strings(result.Formats)
+
+	matcher, err := LoadMusicMatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []PlayHistory
+	unmatched := map[string]*UnmatchedImport{}
+	var minAt, maxAt time.Time
+	for _, play := range parsed.plays {
+		if play.PlayedMs >= 0 && play.PlayedMs < minPlayedMs {
+			result.TooShort++
+			continue
+		}
+		id, ok := matcher.Match(play.Title, play.Singer)
+		if !ok {
+			key := musicKey(play.Title, play.Singer)
+			if u := unmatched[key]; u != nil {
+				u.Count++
+			} else {
+				unmatched[key] = &UnmatchedImport{Title: play.Title, Singer: play.Singer, Count: 1}
+			}
+			continue
+		}
+
+		ph := PlayHistory{
+			UserID:    userID,
+			MusicID:   id,
+			PlayedAt:  play.PlayedAt.Truncate(time.Second),
+			Source:    PlaySourceImport,
+			EndReason: play.EndReason,
+			Completed: play.EndReason == PlayEndCompleted,
+		}
+		if play.PlayedMs > 0 {
+			ended := ph.PlayedAt.Add(time.Duration(play.PlayedMs) * time.Millisecond)
+			ph.ListenedMs, ph.PositionMs, ph.EndedAt = play.PlayedMs, play.PlayedMs, &ended
+		}
+		matched = append(matched, ph)
+		if minAt.IsZero() || ph.PlayedAt.Before(minAt) {
+			minAt = ph.PlayedAt
+		}
+		if ph.PlayedAt.After(maxAt) {
+			maxAt = ph.PlayedAt
+		}
+	}
+
+	for _, u := range unmatched {
+		result.Unmatched = append(result.Unmatched, *u)
+	}
+	sort.Slice(result.Unmatched, func(i, j int) bool {
+		if result.Unmatched[i].Count != result.Unmatched[j].Count {
+			return result.Unmatched[i].Count > result.Unmatched[j].Count
+		}
+		return result.Unmatched[i].Title < result.Unmatched[j].Title
+	})
+	if len(matched) == 0 {
+		return result, nil
+	}
+
+	// 已有的播放记录，以及本次导入内部的重复（如多个文件时间重叠）
+	var existing []PlayHistory
+	err = DB.Select("music_id, played_at").
+		Where("user_id = ? AND played_at >= ? AND played_at < ?", userID, minAt, maxAt.Add(time.Second)).
+		Find(&existing).Error
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(existing)+len(matched))
+	playKey := func(ph *PlayHistory) string {
+		return fmt.Sprintf("%d@%d", ph.MusicID, ph.PlayedAt.Unix())
+	}
+	for i := range existing {
+		seen[playKey(&existing[i])] = true
+	}
+	inserts := matched[:0]
+	for i := range matched {
+		key := playKey(&matched[i])
+		if seen[key] {
+			result.Duplicates++
+			continue
+		}
+		seen[key] = true
+		inserts = append(inserts, matched[i])
+	}
+	result.Imported = len(inserts)
+
+	if dryRun || len(inserts) == 0 {
+		return result, nil
+	}
+	if err := DB.Omit("Music").CreateInBatches(inserts, 500).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
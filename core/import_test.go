@@ -0,0 +1,204 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func parseExportBytes(t *testing.T, name string, data []byte) *parsedExport {
+	t.Helper()
+	p := &parsedExport{formats: map[string]bool{}}
+	if err := parseListenExport(ListenExportFile{Name: name, Data: bytes.NewReader(data), Size: int64(len(data))}, p); err != nil {
+		t.Fatalf("parseListenExport: %v", err)
+	}
+	return p
+}
+
+func TestParseListenExport(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		format  string
+		plays   []ImportedPlay
+		invalid int
+	}{
+		{
+			name:   "spotify streaming history",
+			data:   `[{"endTime":"2024-03-01 12:03","artistName":"Artist","trackName":"Song","msPlayed":180000}]`,
+			format: ImportFormatSpotify,
+			plays: []ImportedPlay{
+				{Title: "Song", Singer: "Artist", PlayedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), PlayedMs: 180000},
+			},
+		},
+		{
+			name: "spotify extended history",
+			data: `[
+				{"ts":"2024-03-01T12:04:00Z","ms_played":240000,"master_metadata_track_name":"Song","master_metadata_album_artist_name":"Artist","master_metadata_album_album_name":"Album","reason_end":"trackdone","skipped":false},
+				{"ts":"2024-03-01T12:05:00Z","ms_played":10000,"master_metadata_track_name":"Next","master_metadata_album_artist_name":"Artist","reason_end":"fwdbtn"},
+				{"ts":"2024-03-01T13:00:00Z","ms_played":600000,"master_metadata_track_name":null,"episode_name":"Podcast"}
+			]`,
+			format: ImportFormatSpotifyExtended,
+			plays: []ImportedPlay{
+				{Title: "Song", Singer: "Artist", Album: "Album", PlayedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), PlayedMs: 240000, EndReason: PlayEndCompleted},
+				{Title: "Next", Singer: "Artist", PlayedAt: time.Date(2024, 3, 1, 12, 4, 50, 0, time.UTC), PlayedMs: 10000, EndReason: PlayEndSkipped},
+			},
+			invalid: 1,
+		},
+		{
+			name: "lastfm recent tracks",
+			data: `{"recenttracks":{"track":[
+				{"artist":{"#text":"Artist"},"album":{"#text":"Album"},"name":"Playing","@attr":{"nowplaying":"true"}},
+				{"artist":{"#text":"Artist"},"album":{"#text":"Album"},"name":"Song","date":{"uts":"1709294400","#text":"01 Mar 2024, 12:00"}}
+			]}}`,
+			format: ImportFormatLastFM,
+			plays: []ImportedPlay{
+				{Title: "Song", Singer: "Artist", Album: "Album", PlayedAt: time.Unix(1709294400, 0), PlayedMs: -1},
+			},
+			invalid: 1,
+		},
+		{
+			name: "listenbrainz jsonl",
+			data: `{"listened_at":1709294400,"track_metadata":{"track_name":"Song","artist_name":"Artist","release_name":"Album"}}
+not json
+{"listened_at":1709298000,"track_metadata":{"track_name":"Other","artist_name":"Artist"}}
+`,
+			format: ImportFormatListenBrainz,
+			plays: []ImportedPlay{
+				{Title: "Song", Singer: "Artist", Album: "Album", PlayedAt: time.Unix(1709294400, 0), PlayedMs: -1},
+				{Title: "Other", Singer: "Artist", PlayedAt: time.Unix(1709298000, 0), PlayedMs: -1},
+			},
+			invalid: 1,
+		},
+		{
+			name:   "listenbrainz api payload",
+			data:   `{"payload":{"count":1,"listens":[{"listened_at":1709294400,"track_metadata":{"track_name":"Song","artist_name":"Artist"}}]}}`,
+			format: ImportFormatListenBrainz,
+			plays: []ImportedPlay{
+				{Title: "Song", Singer: "Artist", PlayedAt: time.Unix(1709294400, 0), PlayedMs: -1},
+			},
+		},
+		{
+			name:   "single json object",
+			data:   "{\n  \"listened_at\": 1709294400,\n  \"track_metadata\": {\"track_name\": \"Song\", \"artist_name\": \"Artist\"}\n}\n",
+			format: ImportFormatListenBrainz,
+			plays: []ImportedPlay{
+				{Title: "Song", Singer: "Artist", PlayedAt: time.Unix(1709294400, 0), PlayedMs: -1},
+			},
+		},
+		{
+			name:   "csv with header",
+			data:   "Track,Artist,Album,ts,ms_played\nSong,Artist,Album,2024-03-01T12:00:00Z,200000\n,Artist,Album,2024-03-01T12:05:00Z,1000\n",
+			format: ImportFormatCSV,
+			plays: []ImportedPlay{
+				{Title: "Song", Singer: "Artist", Album: "Album", PlayedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), PlayedMs: 200000},
+			},
+			invalid: 1,
+		},
+		{
+			name:   "lastfm csv without header",
+			data:   "Artist,Album,Song,01 Mar 2024 12:00\r\nArtist,,Other,01 Mar 2024 12:04\r\n",
+			format: ImportFormatCSV,
+			plays: []ImportedPlay{
+				{Title: "Song", Singer: "Artist", Album: "Album", PlayedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), PlayedMs: -1},
+				{Title: "Other", Singer: "Artist", PlayedAt: time.Date(2024, 3, 1, 12, 4, 0, 0, time.UTC), PlayedMs: -1},
+			},
+		},
+		{
+			name:   "utf-8 bom",
+			data:   "\xef\xbb\xbf[{\"endTime\":\"2024-03-01 12:03\",\"artistName\":\"Artist\",\"trackName\":\"Song\",\"msPlayed\":180000}]",
+			format: ImportFormatSpotify,
+			plays: []ImportedPlay{
+				{Title: "Song", Singer: "Artist", PlayedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), PlayedMs: 180000},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parseExportBytes(t, "export", []byte(tt.data))
+			if !p.formats[tt.format] || len(p.formats) != 1 {
+				t.Errorf("formats = %v, want %s", p.formats, tt.format)
+			}
+			if p.invalid != tt.invalid {
+				t.Errorf("invalid = %d, want %d", p.invalid, tt.invalid)
+			}
+			if len(p.plays) != len(tt.plays) {
+				t.Fatalf("got %d plays, want %d: %+v", len(p.plays), len(tt.plays), p.plays)
+			}
+			for i, want := range tt.plays {
+				got := p.plays[i]
+				if !got.PlayedAt.Equal(want.PlayedAt) {
+					t.Errorf("play %d: PlayedAt = %v, want %v", i, got.PlayedAt, want.PlayedAt)
+				}
+				got.PlayedAt = want.PlayedAt
+				if got != want {
+					t.Errorf("play %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseListenExportZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"Spotify Account Data/StreamingHistory_music_0.json": `[{"endTime":"2024-03-01 12:03","artistName":"Artist","trackName":"Song","msPlayed":180000}]`,
+		"Spotify Account Data/Playlist1.json":                `{"playlists":[]}`,
+		"Spotify Account Data/ReadMe.pdf":                    "%PDF",
+		"listens.jsonl":                                      `{"listened_at":1709294400,"track_metadata":{"track_name":"Other","artist_name":"Artist"}}`,
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := parseExportBytes(t, "my_spotify_data.zip", buf.Bytes())
+	if len(p.plays) != 2 || !p.formats[ImportFormatSpotify] || !p.formats[ImportFormatListenBrainz] {
+		t.Errorf("plays = %+v, formats = %v", p.plays, p.formats)
+	}
+}
+
+func TestParseListenExportEmptyZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("ReadMe.txt")
+	w.Write([]byte("nothing here"))
+	zw.Close()
+
+	p := &parsedExport{formats: map[string]bool{}}
+	if err := parseListenExport(ListenExportFile{Name: "x.zip", Data: bytes.NewReader(buf.Bytes()), Size: int64(buf.Len())}, p); err == nil {
+		t.Error("expected an error for a zip without listening history")
+	}
+}
+
+func TestParseImportTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+		ok   bool
+	}{
+		{"1709294400", time.Unix(1709294400, 0), true},
+		{"1709294400123", time.UnixMilli(1709294400123), true},
+		{"2024-03-01T12:00:00+08:00", time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC), true},
+		{"2024-03-01 12:00:05", time.Date(2024, 3, 1, 12, 0, 5, 0, time.UTC), true},
+		{"2024-03-01 12:00", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), true},
+		{"01 Mar 2024, 12:00", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), true},
+		{"1 Mar 2024 12:00", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"null", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseImportTime(tt.in)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseImportTime(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReplayGain 2.0 参考响度（LUFS）
+const replayGainReference = -18.0
+
+// 单首歌曲的响度分析结果
+type loudnessResult struct {
+	Integrated float64   // 积分响度（LUFS），无有效音频时为 -inf
+	Peak       float64   // 采样峰值（线性，1.0 为满幅）
+	DurationMs int64     // 时长
+	blocks     []float64 // 每个 400ms 门限块的均方功率，用于专辑响度
+}
+
+// 双二阶滤波器
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// 按 ITU-R BS.1770 构造任意采样率下的 K 计权滤波器（高架 + 高通）
+func kWeightingFilters(sampleRate int) (biquad, biquad) {
+	fs := float64(sampleRate)
+
+	// 第一级：高架滤波，模拟头部声学效应
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / fs)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// 第二级：RLB 高通滤波
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / fs)
+	a0 = 1 + k/q + k*k
+	highpass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highpass
+}
+
+// 分析音频文件的积分响度和峰值
+func analyzeLoudness(fullPath string) (*loudnessResult, error) {
+	dec, err := openAudioDecoder(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	rate, channels := dec.SampleRate(), dec.Channels()
+	if rate <= 0 || channels <= 0 {
+		return nil, errors.New("invalid audio stream")
+	}
+
+	filters := make([][2]biquad, channels)
+	for ch := range filters {
+		filters[ch][0], filters[ch][1] = kWeightingFilters(rate)
+	}
+
+	// 以 100ms 为步长累计能量，每 4 个步长组成一个 400ms 的门限块（75% 重叠）
+	stepFrames := rate / 10
+	var (
+		res        loudnessResult
+		steps      []float64
+		stepEnergy float64
+		stepCount  int
+		frames     int64
+		ch         int
+	)
+	buf := make([]float64, 4096*channels)
+	for {
+		n, err := dec.ReadSamples(buf)
+		for _, s := range buf[:n] {
+			if a := math.Abs(s); a > res.Peak {
+				res.Peak = a
+			}
+			y := filters[ch][1].process(filters[ch][0].process(s))
+			stepEnergy += y * y
+			ch++
+			if ch == channels {
+				ch = 0
+				frames++
+				stepCount++
+				if stepCount == stepFrames {
+					steps = append(steps, stepEnergy)
+					stepEnergy, stepCount = 0, 0
+					if len(steps) >= 4 {
+						sum := steps[len(steps)-1] + steps[len(steps)-2] + steps[len(steps)-3] + steps[len(steps)-4]
+						res.blocks = append(res.blocks, sum/float64(4*stepFrames))
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res.DurationMs = frames * 1000 / int64(rate)
+	res.Integrated = gatedLoudness(res.blocks)
+	return &res, nil
+}
+
+// 对门限块应用绝对门限（-70 LUFS）和相对门限（-10 LU）后计算积分响度
+func gatedLoudness(blocks []float64) float64 {
+	const absoluteGate = -70.0
+	loudness := func(power float64) float64 { return -0.691 + 10*math.Log10(power) }
+
+	var sum float64
+	var n int
+	for _, p := range blocks {
+		if loudness(p) > absoluteGate {
+			sum += p
+			n++
+		}
+	}
+	if n == 0 {
+		return math.Inf(-1)
+	}
+
+	relativeGate := loudness(sum/float64(n)) - 10
+	sum, n = 0, 0
+	for _, p := range blocks {
+		if l := loudness(p); l > absoluteGate && l > relativeGate {
+			sum += p
+			n++
+		}
+	}
+	if n == 0 {
+		return math.Inf(-1)
+	}
+	return loudness(sum / float64(n))
+}
+
+// 由积分响度计算增益（dB），静音时不调整
+func replayGain(integrated float64) float64 {
+	if math.IsInf(integrated, -1) {
+		return 0
+	}
+	return math.Round((replayGainReference-integrated)*100) / 100
+}
+
+// 防止多个分析任务同时运行
+var loudnessMu sync.Mutex
+
+var ErrLoudnessBusy = errors.New("loudness analysis already running")
+
+// 格式受支持但无法解码的音频（文件损坏、截断等）
+var ErrAudioUndecodable = errors.New("audio cannot be decoded")
+
+// 分析一组歌曲的响度并写回数据库
+// 同一专辑的歌曲会一起计算专辑增益，因此若传入的歌曲属于某个专辑，会把同专辑的其他歌曲一并分析
+// 传入的歌曲无法分析时返回 ErrUnsupportedAudio 或 ErrAudioUndecodable，其他歌曲的结果仍会保存
+func AnalyzeMusicLoudness(musicIDs []int64) error {
+	if !loudnessMu.TryLock() {
+		return ErrLoudnessBusy
+	}
+	defer loudnessMu.Unlock()
+
+	var songs []Music
+	if err := DB.Where("id IN ?", musicIDs).Find(&songs).Error; err != nil {
+		return err
+	}
+	if len(songs) == 0 {
+		return errors.New("music not found")
+	}
+
+	var albums []string
+	for _, m := range songs {
+		if m.Album != "" {
+			albums = append(albums, m.Album)
+		}
+	}
+	if len(albums) > 0 {
+		var albumSongs []Music
+		if err := DB.Where("album IN ? AND id NOT IN ?", albums, musicIDs).Find(&albumSongs).Error; err != nil {
+			return err
+		}
+		songs = append(songs, albumSongs...)
+	}
+	failed, err := analyzeAndSaveLoudness(songs)
+	if err != nil {
+		return err
+	}
+	for _, id := range musicIDs {
+		if err, ok := failed[id]; ok {
+			return fmt.Errorf("music %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// 分析所有尚未分析过响度的歌曲，上次无法解码的歌曲不再重试；force 为 true 时重新分析全部歌曲
+func AnalyzeLibraryLoudness(force bool) error {
+	if !loudnessMu.TryLock() {
+		return ErrLoudnessBusy
+	}
+	defer loudnessMu.Unlock()
+	return analyzeLibraryLoudness(force)
+}
+
+// 在后台分析全库歌曲的响度，已有分析任务在运行时立即返回 ErrLoudnessBusy
+func StartLibraryLoudnessAnalysis(force bool) error {
+	if !loudnessMu.TryLock() {
+		return ErrLoudnessBusy
+	}
+	go func() {
+		defer loudnessMu.Unlock()
+		if err := analyzeLibraryLoudness(force); err != nil {
+			log.Printf("loudness analysis failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// 调用方需持有 loudnessMu
+func analyzeLibraryLoudness(force bool) error {
+	var songs []Music
+	query := DB.Model(&Music{})
+	if !force {
+		// 未分析的歌曲，以及与之同专辑的歌曲（专辑增益需要重新计算）
+		query = query.Where("analyzed_at IS NULL OR (album <> '' AND album IN (?))",
+			DB.Model(&Music{}).Select("album").Where("analyzed_at IS NULL AND album <> ''"))
+	}
+	if err := query.Find(&songs).Error; err != nil {
+		return err
+	}
+	_, err := analyzeAndSaveLoudness(songs)
+	return err
+}
+
+// 分析并保存响度，返回无法分析的歌曲及原因
+func analyzeAndSaveLoudness(songs []Music) (map[int64]error, error) {
+	type analyzed struct {
+		music  *Music
+		result *loudnessResult
+	}
+	var results []analyzed
+	var undecodable []int64
+	failed := map[int64]error{}
+	for i := range songs {
+		m := &songs[i]
+		fullPath, err := resolveAssetPath(m.AudioURL)
+		if err != nil {
+			log.Printf("loudness: invalid audio path for music id=%d: %v", m.Id, err)
+			failed[m.Id] = err
+			continue
+		}
+		res, err := analyzeLoudness(fullPath)
+		if err != nil {
+			log.Printf("loudness: failed to analyze music id=%d: %v", m.Id, err)
+			if !errors.Is(err, ErrUnsupportedAudio) && !errors.Is(err, fs.ErrNotExist) {
+				err = fmt.Errorf("%w: %v", ErrAudioUndecodable, err)
+			}
+			// 文件不存在可能只是暂时没有挂载，下次启动时仍会重试
+			if !errors.Is(err, fs.ErrNotExist) {
+				undecodable = append(undecodable, m.Id)
+			}
+			failed[m.Id] = err
+			continue
+		}
+		results = append(results, analyzed{music: m, result: res})
+	}
+
+	// 按专辑汇总门限块，计算专辑增益与专辑峰值
+	type albumStat struct {
+		blocks []float64
+		peak   float64
+	}
+	albums := make(map[string]*albumStat)
+	for _, r := range results {
+		if r.music.Album == "" {
+			continue
+		}
+		st, ok := albums[r.music.Album]
+		if !ok {
+			st = &albumStat{}
+			albums[r.music.Album] = st
+		}
+		st.blocks = append(st.blocks, r.result.blocks...)
+		st.peak = math.Max(st.peak, r.result.Peak)
+	}
+
+	now := time.Now()
+	return failed, DB.Transaction(func(tx *gorm.DB) error {
+		for _, r := range results {
+			trackGain := replayGain(r.result.Integrated)
+			trackPeak := math.Round(r.result.Peak*1e6) / 1e6
+			albumGain, albumPeak := trackGain, trackPeak
+			if st, ok := albums[r.music.Album]; ok {
+				albumGain = replayGain(gatedLoudness(st.blocks))
+				albumPeak = math.Round(st.peak*1e6) / 1e6
+			}
+
+			updates := map[string]interface{}{
+				"duration_ms":     r.result.DurationMs,
+				"track_gain":      trackGain,
+				"track_peak":      trackPeak,
+				"album_gain":      albumGain,
+				"album_peak":      albumPeak,
+				"analyzed_at":     now,
+				"loudness_failed": false,
+			}
+			if !math.IsInf(r.result.Integrated, -1) {
+				updates["loudness"] = math.Round(r.result.Integrated*100) / 100
+			}
+			if err := tx.Model(&Music{}).Where("id = ?", r.music.Id).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		// 记录无法解码的歌曲，避免每次启动都重新解码它们以及同专辑的歌曲
+		if len(undecodable) == 0 {
+			return nil
+		}
+		return tx.Model(&Music{}).Where("id IN ?", undecodable).
+			Updates(map[string]interface{}{"analyzed_at": now, "loudness_failed": true}).Error
+	})
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// 标题中常见的附加信息，如 (feat. xxx)、（Live）、[Remastered]、- 2011 Remaster
+var titleDecoration = regexp.MustCompile(`\s*[\(\[（【][^\)\]）】]*[\)\]）】]|\s+-\s+.*(remaster|live|version|edit|mix|版).*$`)
+
+// 歌手之间常见的分隔符
+var singerSeparator = regexp.MustCompile(`\s*(,|，|、|&|/|;|；|\bfeat\.?|\bft\.?|\bx\b)\s*`)
+
+// 规范化文本：统一全角半角与大小写，只保留字母和数字
+func normalizeText(s string) string {
+	s = strings.ToLower(norm.NFKC.String(s))
+	var sb strings.Builder
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// 规范化歌曲标题，去掉括号中的附加信息
+func normalizeTitle(title string) string {
+	if t := normalizeText(titleDecoration.ReplaceAllString(strings.ToLower(title), "")); t != "" {
+		return t
+	}
+	return normalizeText(title)
+}
+
+// 拆分并规范化歌手，多位歌手合唱时返回每一位
+func normalizeSingers(singer string) []string {
+	var out []string
+	for _, part := range singerSeparator.Split(strings.ToLower(norm.NFKC.String(singer)), -1) {
+		if n := normalizeText(part); n != "" {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// 歌曲的规范化键：标题 + 歌手，用于识别“同一首歌”
+func musicKey(title, singer string) string {
+	return normalizeTitle(title) + "|" + strings.Join(normalizeSingers(singer), ",")
+}
+
+// MusicMatcher 按规范化的标题和歌手把外部数据匹配到曲库中的歌曲
+type MusicMatcher struct {
+	byKey   map[string]int64
+	byTitle map[string][]matchCandidate
+}
+
+type matchCandidate struct {
+	id      int64
+	singers []string
+}
+
+func NewMusicMatcher(songs []Music) *MusicMatcher {
+	m := &MusicMatcher{
+		byKey:   make(map[string]int64, len(songs)),
+		byTitle: make(map[string][]matchCandidate, len(songs)),
+	}
+	for _, s := range songs {
+		key := musicKey(s.Title, s.Singer)
+		if _, ok := m.byKey[key]; !ok {
+			m.byKey[key] = s.Id
+		}
+		title := normalizeTitle(s.Title)
+		m.byTitle[title] = append(m.byTitle[title], matchCandidate{id: s.Id, singers: normalizeSingers(s.Singer)})
+	}
+	return m
+}
+
+// 加载全部歌曲构造匹配器
+func LoadMusicMatcher() (*MusicMatcher, error) {
+	songs, err := GetAllSongs()
+	if err != nil {
+		return nil, err
+	}
+	return NewMusicMatcher(songs), nil
+}
+
+// Match 返回匹配到的歌曲 ID：先精确匹配标题和歌手，再匹配标题相同且至少有一位歌手相同的歌曲
+func (m *MusicMatcher) Match(title, singer string) (int64, bool) {
+	if id, ok := m.byKey[musicKey(title, singer)]; ok {
+		return id, true
+	}
+	singers := normalizeSingers(singer)
+	for _, c := range m.byTitle[normalizeTitle(title)] {
+		for _, a := range c.singers {
+			for _, b := range singers {
+				if a == b || strings.Contains(a, b) || strings.Contains(b, a) {
+					return c.id, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
@@ -56,14 +56,25 @@ var AllLabels = []Label{
 
 // 音乐
 type Music struct {
-	Id        int64          `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
-	Title     string         `json:"title" gorm:"column:title;type:varchar(255);not null"`
-	Singer    string         `json:"singer" gorm:"column:singer;type:varchar(255);not null"`
-	Labels    pq.StringArray `json:"labels" gorm:"column:labels;type:text[]"`
-	DeLabels  pq.StringArray `json:"delabels" gorm:"column:delabels;type:text[]"`
-	AudioURL  string         `json:"audioUrl" gorm:"column:audio_path;type:text;not null"`
-	CoverURL  string         `json:"coverUrl" gorm:"column:cover_path;type:text"`
-	LyricsURL string         `json:"lyricsUrl" gorm:"column:lyrics_path;type:text"`
+	Id          int64          `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	Title       string         `json:"title" gorm:"column:title;type:varchar(255);not null"`
+	Singer      string         `json:"singer" gorm:"column:singer;type:varchar(255);not null"`
+	Labels      pq.StringArray `json:"labels" gorm:"column:labels;type:text[]"`
+	DeLabels    pq.StringArray `json:"delabels" gorm:"column:delabels;type:text[]"`
+	AudioURL    string         `json:"audioUrl" gorm:"column:audio_path;type:text;not null"`
+	CoverURL    string         `json:"coverUrl" gorm:"column:cover_path;type:text"`
+	LyricsURL   string         `json:"lyricsUrl" gorm:"column:lyrics_path;type:text"`
+	ContentHash string         `json:"contentHash" gorm:"column:content_hash;type:varchar(64);index"` // 去除标签后的音频内容指纹
+	Album       string         `json:"album" gorm:"column:album;type:varchar(255);index"`
+	DurationMs  int64          `json:"durationMs" gorm:"column:duration_ms"`
+	Loudness    *float64       `json:"loudness" gorm:"column:loudness"`      // 积分响度（LUFS）
+	TrackGain   *float64       `json:"trackGain" gorm:"column:track_gain"`   // ReplayGain 单曲增益（dB）
+	TrackPeak   *float64       `json:"trackPeak" gorm:"column:track_peak"`   // 单曲采样峰值（线性）
+	AlbumGain   *float64       `json:"albumGain" gorm:"column:album_gain"`   // ReplayGain 专辑增益（dB）
+	AlbumPeak   *float64       `json:"albumPeak" gorm:"column:album_peak"`   // 专辑采样峰值（线性）
+	AnalyzedAt  *time.Time     `json:"analyzedAt" gorm:"column:analyzed_at"` // 响度分析时间，为空表示尚未分析
+	// 上次分析时音频无法解码，AnalyzedAt 为失败的时间；启动时的自动分析不再重试，可以手动重新分析
+	LoudnessFailed bool `json:"loudnessFailed" gorm:"column:loudness_failed;not null;default:false"`
 }
 
 func (Music) TableName() string {
@@ -72,15 +83,35 @@ func (Music) TableName() string {
 
 // 歌单
 type Playlist struct {
-	ID          int64          `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
-	Name        string         `json:"name" gorm:"column:name;type:varchar(255);not null"`
-	Description string         `json:"description" gorm:"column:description;type:text"`
-	CreatedAt   time.Time      `json:"createdAt" gorm:"column:created_at;autoCreateTime"`
-	Items       []PlaylistItem `json:"items" gorm:"foreignKey:PlaylistID;references:ID"` // GORM 会自动预加载 (Preload) 这个字段
-	// OwnerUserID int64       `json:"ownerUserId" gorm:"column:owner_user_id;not null"`
+	ID          int64             `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	Name        string            `json:"name" gorm:"column:name;type:varchar(255);not null"`
+	Description string            `json:"description" gorm:"column:description;type:text"`
+	CreatedAt   time.Time         `json:"createdAt" gorm:"column:created_at;autoCreateTime"`
+	Items       []PlaylistItem    `json:"items" gorm:"foreignKey:PlaylistID;references:ID"` // GORM 会自动预加载 (Preload) 这个字段
+	OwnerUserID int64             `json:"ownerUserId" gorm:"column:owner_user_id;not null;default:1;index"`
+	Kind        string            `json:"kind" gorm:"column:kind;type:varchar(16);not null;default:user"`     // 见 PlaylistKind*
+	RefreshedAt *time.Time        `json:"refreshedAt" gorm:"column:refreshed_at"`                             // 系统歌单最近一次生成的时间
+	Version     int64             `json:"version" gorm:"column:version;not null;default:0"`                   // 每次修改歌曲或成员时递增，见 PlaylistActivity
+	Smart       *SmartPlaylistDef `json:"smart,omitempty" gorm:"column:smart_rule;type:text;serializer:json"` // 智能歌单的规则，其他歌单为空
+	DeletedAt   gorm.DeletedAt    `json:"deletedAt" gorm:"column:deleted_at;index"`                           // 移入回收站的时间，普通查询会自动排除回收站中的歌单
 	// Owner       User        `json:"owner" gorm:"foreignKey:OwnerUserID;references:ID"`
 }
 
+// 歌单类型
+const (
+	PlaylistKindUser      = "user"      // 用户创建的歌单
+	PlaylistKindDailyMix  = "daily_mix" // 每日推荐，由系统每天重新生成
+	PlaylistKindSmart     = "smart"     // 智能歌单，查询时按规则计算歌曲
+	PlaylistKindFavorites = "favorites" // 用户的收藏歌单，每个用户一个，由收藏接口维护
+)
+
+// 系统生成的歌单，用户不能修改
+func (p *Playlist) ReadOnly() bool {
+	return p.Kind == PlaylistKindDailyMix || p.Kind == PlaylistKindSmart
+}
+
+var ErrPlaylistReadOnly = errors.New("playlist is generated by the system and cannot be modified")
+
 func (Playlist) TableName() string {
 	return "playlists"
 }
@@ -99,12 +130,33 @@ func (PlaylistItem) TableName() string {
 	return "playlist_music"
 }
 
+// 播放来源
+const (
+	PlaySourceAuto   = "auto"   // 请求音频流时自动记录
+	PlaySourceEvent  = "event"  // 客户端通过播放事件上报
+	PlaySourceImport = "import" // 从外部收听记录导入
+)
+
+// 播放结束原因
+const (
+	PlayEndCompleted = "completed"
+	PlayEndSkipped   = "skipped"
+	PlayEndError     = "error"
+)
+
 // 播放历史记录
 type PlayHistory struct {
-	ID       int64     `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
-	MusicID  int64     `json:"musicId" gorm:"column:music_id;not null;index"`
-	PlayedAt time.Time `json:"playedAt" gorm:"column:played_at;not null;index"`
-	Music    Music     `json:"music" gorm:"foreignKey:MusicID;references:id"`
+	ID         int64      `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	UserID     int64      `json:"userId" gorm:"column:user_id;not null;default:1;index"`
+	MusicID    int64      `json:"musicId" gorm:"column:music_id;not null;index"`
+	PlayedAt   time.Time  `json:"playedAt" gorm:"column:played_at;not null;index"`
+	Source     string     `json:"source" gorm:"column:source;type:varchar(16);not null;default:auto"`
+	PositionMs int64      `json:"positionMs" gorm:"column:position_ms;not null;default:0"` // 最近一次上报的播放位置
+	ListenedMs int64      `json:"listenedMs" gorm:"column:listened_ms;not null;default:0"` // 实际收听时长
+	Completed  bool       `json:"completed" gorm:"column:completed;not null;default:false"`
+	EndReason  string     `json:"endReason" gorm:"column:end_reason;type:varchar(16)"` // completed / skipped / error
+	EndedAt    *time.Time `json:"endedAt" gorm:"column:ended_at"`
+	Music      Music      `json:"music" gorm:"foreignKey:MusicID;references:id"`
 }
 
 func (PlayHistory) TableName() string {
@@ -114,196 +166,250 @@ func (PlayHistory) TableName() string {
 // ==== 操作函数 ====
 // 查询所有歌曲
 func GetAllSongs() ([]Music, error) {
-	var songs []Music
-	result := DB.Find(&songs)
-	return songs, result.Error
+	return Repo.AllMusic()
 }
 
-// 查询所有歌单的信息
-func GetAllPlaylists() ([]Playlist, error) {
-	var playlists []Playlist
-	result := DB.Find(&playlists)
-	return playlists, result.Error
+// 查询用户创建的和作为成员加入的歌单
+func GetAllPlaylists(userID int64) ([]Playlist, error) {
+	return Repo.AllPlaylists(userID)
 }
 
 // 查询歌单详细信息
 func GetPlaylistByID(playlistID int64) (*Playlist, error) {
-	var playlist Playlist
-
-	// Preload("Items"): 加载 PlaylistItems 连接表数据
-	// Preload("Items.Music"): 加载连接表中嵌套的 Music 数据
-	// Order("items.track_order"): 确保按顺序返回
-	// 注意：GORM 的 Preload 排序语法可能需要根据具体版本调整，
-	// 这里更稳妥的方式是在 PlaylistItem 定义中指定默认排序，或者在加载后排序。
-	// 我们尝试在 Preload 中指定排序:
-	err := DB.Preload("Items", func(db *gorm.DB) *gorm.DB {
-		return db.Order("track_order ASC")
-	}).Preload("Items.Music").First(&playlist, playlistID).Error
-
+	playlist, err := Repo.PlaylistByID(playlistID)
 	if err != nil {
 		return nil, err
 	}
-	return &playlist, nil
+	if playlist.Kind == PlaylistKindSmart {
+		if err := fillSmartPlaylist(playlist); err != nil {
+			return nil, err
+		}
+	}
+	return playlist, nil
 }
 
-// 将歌曲添加到歌单
-func AddSongToPlaylist(playlistID int64, musicID int64) error {
-	return DB.Transaction(func(tx *gorm.DB) error {
+// 将歌曲添加到歌单，userID 为操作者
+func AddSongToPlaylist(playlistID int64, musicID int64, userID int64) error {
+	activity := &PlaylistActivity{PlaylistID: playlistID, UserID: userID, Action: PlaylistActionAdd, MusicID: musicID}
+	err := DB.Transaction(func(tx *gorm.DB) error {
 		// 1. 检查歌单是否存在
-		var count int64
-		if err := tx.Model(&Playlist{}).Where("id = ?", playlistID).Count(&count).Error; err != nil || count == 0 {
-			return errors.New("playlist not found")
+		var playlist Playlist
+		if err := tx.First(&playlist, playlistID).Error; err != nil {
+			return ErrPlaylistNotFound
+		}
+		if playlist.ReadOnly() {
+			return ErrPlaylistReadOnly
 		}
 
 		// 2. 检查歌曲是否存在
+		var count int64
 		if err := tx.Model(&Music{}).Where("id = ?", musicID).Count(&count).Error; err != nil || count == 0 {
 			return errors.New("music not found")
 		}
 
-		// 3. 计算新的 track_order
-		var maxOrder int
-		err := tx.Model(&PlaylistItem{}).Where("playlist_id = ?", playlistID).Select("COALESCE(MAX(track_order), 0)").Scan(&maxOrder).Error
-		if err != nil {
-			return err
+		// 3. 在歌单末尾创建连接项，唯一索引保证同一首歌不会重复加入
+		if err := Repo.AppendPlaylistItem(tx, playlistID, musicID); err != nil {
+			return fmt.Errorf("failed to add song: %w", err)
 		}
-		newOrder := maxOrder + 1
 
-		// 4. 创建连接项
-		item := PlaylistItem{
-			PlaylistID: playlistID,
-			MusicID:    musicID,
-			TrackOrder: newOrder,
+		MarkSimilarityDirty(musicID)
+		return recordPlaylistActivity(tx, activity)
+	})
+	if err != nil {
+		return err
+	}
+	broadcastPlaylistActivity(activity, nil)
+	return nil
+}
+
+// 将歌曲移除此歌单，userID 为操作者
+func RemoveSongFromPlaylist(playlistID int64, musicID int64, userID int64) error {
+	activity := &PlaylistActivity{PlaylistID: playlistID, UserID: userID, Action: PlaylistActionRemove, MusicID: musicID}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var playlist Playlist
+		if err := tx.First(&playlist, playlistID).Error; err == nil && playlist.ReadOnly() {
+			return ErrPlaylistReadOnly
 		}
 
-		// Create 会自动处理唯一索引冲突 (如果数据库设置了唯一索引)
-		if err := tx.Create(&item).Error; err != nil {
-			return fmt.Errorf("failed to add song: %w", err)
+		// 直接根据复合主键删除
+		removed, err := Repo.RemovePlaylistItem(tx, playlistID, musicID)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return errors.New("song not found in playlist")
 		}
+		MarkSimilarityDirty(musicID)
+		return recordPlaylistActivity(tx, activity)
+	})
+	if err != nil {
+		return err
+	}
+	broadcastPlaylistActivity(activity, nil)
+	return nil
+}
 
-		return nil
+// 用户的收藏歌单，还没有时创建
+func GetFavoritesPlaylist(userID int64) (*Playlist, error) {
+	var p Playlist
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("owner_user_id = ? AND kind = ?", userID, PlaylistKindFavorites).Order("id ASC").First(&p).Error
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		created, err := createPlaylist(tx, "我的收藏", "", userID)
+		if err != nil {
+			return err
+		}
+		p = *created
+		return tx.Model(&p).Update("kind", PlaylistKindFavorites).Error
 	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
 }
 
-// 将歌曲移除此歌单
-func RemoveSongFromPlaylist(playlistID int64, musicID int64) error {
-	// 直接根据复合主键删除
-	result := DB.Where("playlist_id = ? AND music_id = ?", playlistID, musicID).Delete(&PlaylistItem{})
+// 用户收藏歌单中的歌曲
+func starredMusicIDs(userID int64) ([]int64, error) {
+	var starred []int64
+	err := DB.Model(&PlaylistItem{}).
+		Joins("JOIN playlists ON playlists.id = playlist_music.playlist_id").
+		Where("playlists.owner_user_id = ? AND playlists.kind = ? AND playlists.deleted_at IS NULL", userID, PlaylistKindFavorites).
+		Pluck("playlist_music.music_id", &starred).Error
+	return starred, err
+}
 
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return errors.New("song not found in playlist")
+// 新建一个空白歌单，userID 为创建者
+func CreatePlaylist(name string, description string, userID int64) (*Playlist, error) {
+	var newPlaylist *Playlist
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		newPlaylist, err = createPlaylist(tx, name, description, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return newPlaylist, nil
 }
 
-// 新建一个空白歌单
-func CreatePlaylist(name string, description string) (*Playlist, error) {
+// 在事务中新建歌单并记录创建动态
+func createPlaylist(tx *gorm.DB, name string, description string, userID int64) (*Playlist, error) {
 	newPlaylist := Playlist{
 		Name:        name,
 		Description: description,
+		OwnerUserID: userID,
 	}
-
-	if err := DB.Create(&newPlaylist).Error; err != nil {
+	if err := tx.Create(&newPlaylist).Error; err != nil {
+		return nil, err
+	}
+	activity := &PlaylistActivity{PlaylistID: newPlaylist.ID, UserID: userID, Action: PlaylistActionCreate}
+	if err := recordPlaylistActivity(tx, activity); err != nil {
 		return nil, err
 	}
+	newPlaylist.Version = activity.Version
 	return &newPlaylist, nil
 }
 
-// 删除一个歌单
-func DeletePlaylist(playlistID int64) error {
-	return DB.Transaction(func(tx *gorm.DB) error {
-		var count int64
-		if err := tx.Model(&Playlist{}).Where("id = ?", playlistID).Count(&count).Error; err != nil || count == 0 {
-			return errors.New("playlist not found")
+// 把歌单移入回收站，userID 为操作者；歌曲、成员和动态保留，PlaylistTrashDays 天内可以用 RestorePlaylist 恢复
+func DeletePlaylist(playlistID int64, userID int64) error {
+	audience, err := playlistAudience(playlistID)
+	if err != nil {
+		return err
+	}
+	activity := &PlaylistActivity{PlaylistID: playlistID, UserID: userID, Action: PlaylistActionDelete}
+	var musicIDs []int64
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var playlist Playlist
+		if err := tx.First(&playlist, playlistID).Error; err != nil {
+			return ErrPlaylistNotFound
+		}
+		if playlist.ReadOnly() && playlist.Kind != PlaylistKindSmart {
+			return ErrPlaylistReadOnly
 		}
 
-		// 删除歌单项
-		if err := tx.Where("playlist_id = ?", playlistID).Delete(&PlaylistItem{}).Error; err != nil {
+		// 先记录动态，软删除后歌单无法再被普通查询更新
+		if err := recordPlaylistActivity(tx, activity); err != nil {
 			return err
 		}
-
-		// 删除歌单
-		if err := tx.Delete(&Playlist{}, playlistID).Error; err != nil {
+		if err := tx.Model(&PlaylistItem{}).Where("playlist_id = ?", playlistID).Pluck("music_id", &musicIDs).Error; err != nil {
 			return err
 		}
-
-		return nil
+		return tx.Delete(&playlist).Error
 	})
+	if err != nil {
+		return err
+	}
+	// 回收站中的歌单不参与相似度计算
+	MarkSimilarityDirty(musicIDs...)
+	broadcastPlaylistActivity(activity, audience)
+	return nil
 }
 
-// 根据标签检索音乐
+// 根据标签检索音乐，只要歌曲含有参数中的*任意*一个标签，就算匹配
 func SearchMusicByLabels(labels []string, limit int) ([]Music, error) {
-	var songs []Music
-
-	// 使用 Postgres 的 && 操作符 (数组重叠)
-	// 只要 labels 字段包含参数中的*任意*一个标签，就算匹配
-	// pq.StringArray(labels) 会将 Go切片 转换为 Postgres 数组格式 '{Pop,Rock}'
-	err := DB.Where("labels && ?", pq.StringArray(labels)).Limit(limit).Find(&songs).Error
-
-	return songs, err
+	return Repo.SearchMusicByLabels(labels, limit)
 }
 
 // 根据名称模糊搜索音乐
 func SearchMusicByText(text string) ([]Music, error) {
-	var songs []Music
-	pattern := fmt.Sprintf("%%%s%%", text) // 构造模糊匹配模式
-
-	err := DB.Where("title ILIKE ? OR singer ILIKE ?", pattern, pattern).Find(&songs).Error
-
-	return songs, err
+	return Repo.SearchMusicByText(text)
 }
 
 // 根据 Music.id 查找 Music 详细信息
 func GetMusicByID(musicID int64) (*Music, error) {
-	var music Music
-	if err := DB.First(&music, musicID).Error; err != nil {
-		return nil, err
-	}
-	return &music, nil
+	return Repo.MusicByID(musicID)
 }
 
 // 记录播放记录
-func RecordPlayHistory(music *Music) {
+// 同一首歌在 playDedupWindow 内重复请求（如浏览器的多次 Range 请求）只记录一次；隐私收听的会话不记录
+func RecordPlayHistory(music *Music, userID int64, sessionID string) {
 	if music == nil {
 		fmt.Println(errors.New("music cannot be nil"))
+		return
+	}
+	if IsPrivateSession(sessionID) {
+		return
 	}
 
 	playHistory := PlayHistory{
+		UserID:   userID,
 		MusicID:  music.Id,
 		PlayedAt: time.Now(),
+		Source:   PlaySourceAuto,
 	}
 
-	if err := DB.Create(&playHistory).Error; err != nil {
+	if _, err := Repo.RecordPlay(&playHistory, playDedupWindow); err != nil {
 		fmt.Println(errors.New("failed to record play history: " + err.Error()))
 	}
 }
 
-// 查询播放记录
-func GetPlayHistory(limit int) ([]Music, error) {
+// 查询用户最近播放过的歌曲（按最后一次播放时间倒序，同一首歌只出现一次）
+func GetPlayHistory(userID int64, limit int) ([]Music, error) {
 	if limit <= 0 {
 		limit = 50 // 默认限制
 	}
 
-	var playHistories []PlayHistory
-	err := DB.Preload("Music").
-		Order("played_at DESC").
-		Limit(limit).
-		Find(&playHistories).Error
-
+	musicIDs, err := Repo.RecentlyPlayedIDs(userID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get play history: %w", err)
 	}
 
-	// 提取音乐信息并去重（因为同一首歌可能被播放多次）
-	musicMap := make(map[int64]Music)
-	var musics []Music
+	var songs []Music
+	if err := DB.Where("id IN ?", musicIDs).Find(&songs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get play history: %w", err)
+	}
 
-	for _, ph := range playHistories {
-		if _, exists := musicMap[ph.Music.Id]; !exists {
-			musicMap[ph.Music.Id] = ph.Music
-			musics = append(musics, ph.Music)
+	// 按播放顺序排列
+	byID := make(map[int64]Music, len(songs))
+	for _, m := range songs {
+		byID[m.Id] = m
+	}
+	musics := make([]Music, 0, len(musicIDs))
+	for _, id := range musicIDs {
+		if m, ok := byID[id]; ok {
+			musics = append(musics, m)
 		}
 	}
 
@@ -0,0 +1,349 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	playlistTrashDefaultDays = 30
+	playlistPurgePeriod      = time.Hour
+)
+
+// 歌单在回收站中保留的天数，由 PLAYLIST_TRASH_DAYS 配置，见 StartPlaylistTrashPurger
+var PlaylistTrashDays = playlistTrashDefaultDays
+
+var ErrPlaylistVersionNotFound = errors.New("playlist version not found")
+
+// 会改变歌曲或名称的动态，每次都保存一份歌单快照
+var playlistSnapshotActions = map[string]bool{
+	PlaylistActionCreate:   true,
+	PlaylistActionAdd:      true,
+	PlaylistActionRemove:   true,
+	PlaylistActionReorder:  true,
+	PlaylistActionImport:   true,
+	PlaylistActionRename:   true,
+	PlaylistActionRollback: true,
+	PlaylistActionMerge:    true,
+	PlaylistActionDedupe:   true,
+	PlaylistActionRules:    true,
+}
+
+// 歌单某个版本的快照，用于查看修改历史和回滚
+type PlaylistVersion struct {
+	ID          int64             `json:"-" gorm:"primaryKey;autoIncrement;column:id"`
+	PlaylistID  int64             `json:"playlistId" gorm:"column:playlist_id;not null;uniqueIndex:idx_playlist_version_uniq,priority:1"`
+	Version     int64             `json:"version" gorm:"column:version;not null;uniqueIndex:idx_playlist_version_uniq,priority:2"`
+	UserID      int64             `json:"userId" gorm:"column:user_id;not null"`
+	Action      string            `json:"action" gorm:"column:action;type:varchar(16);not null"` // 产生该版本的操作，见 PlaylistAction*
+	MusicID     int64             `json:"musicId,omitempty" gorm:"column:music_id"`
+	Name        string            `json:"name" gorm:"column:name;type:varchar(255);not null"`
+	Description string            `json:"description" gorm:"column:description;type:text"`
+	TrackCount  int               `json:"trackCount" gorm:"column:track_count;not null"`
+	MusicIDs    []int64           `json:"musicIds,omitempty" gorm:"column:music_ids;type:text;serializer:json"` // 按顺序排列的歌曲
+	Smart       *SmartPlaylistDef `json:"smart,omitempty" gorm:"column:smart_rule;type:text;serializer:json"`   // 智能歌单当时的规则
+	CreatedAt   time.Time         `json:"createdAt" gorm:"column:created_at;autoCreateTime"`
+	Songs       []Music           `json:"songs,omitempty" gorm:"-"` // 查询单个版本时填充，已从曲库删除的歌曲不包括在内
+}
+
+func (PlaylistVersion) TableName() string {
+	return "playlist_versions"
+}
+
+// 在事务中保存动态对应版本的歌单快照（recordPlaylistActivity 调用）
+func snapshotPlaylist(tx *gorm.DB, a *PlaylistActivity) error {
+	if !playlistSnapshotActions[a.Action] {
+		return nil
+	}
+	var p Playlist
+	if err := tx.Select("id", "name", "description", "smart_rule").First(&p, a.PlaylistID).Error; err != nil {
+		return err
+	}
+	musicIDs := []int64{}
+	err := tx.Model(&PlaylistItem{}).Where("playlist_id = ?", a.PlaylistID).
+		Order("track_order ASC").Pluck("music_id", &musicIDs).Error
+	if err != nil {
+		return err
+	}
+	return tx.Create(&PlaylistVersion{
+		PlaylistID:  a.PlaylistID,
+		Version:     a.Version,
+		UserID:      a.UserID,
+		Action:      a.Action,
+		MusicID:     a.MusicID,
+		Name:        p.Name,
+		Description: p.Description,
+		TrackCount:  len(musicIDs),
+		MusicIDs:    musicIDs,
+		Smart:       p.Smart,
+	}).Error
+}
+
+// ==== 修改历史 ====
+
+// 歌单的修改历史，最新的版本在前；列表中不包含歌曲
+func GetPlaylistVersions(playlistID int64, page, pageSize int) ([]PlaylistVersion, int64, error) {
+	var total int64
+	query := DB.Model(&PlaylistVersion{}).Where("playlist_id = ?", playlistID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	list := []PlaylistVersion{}
+	err := query.Omit("music_ids").Order("version DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&list).Error
+	return list, total, err
+}
+
+// 歌单某个版本的快照及其中的歌曲
+func GetPlaylistVersion(playlistID, version int64) (*PlaylistVersion, error) {
+	var v PlaylistVersion
+	if err := DB.Where("playlist_id = ? AND version = ?", playlistID, version).First(&v).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlaylistVersionNotFound
+		}
+		return nil, err
+	}
+	var songs []Music
+	if err := DB.Where("id IN ?", v.MusicIDs).Find(&songs).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]Music, len(songs))
+	for _, m := range songs {
+		byID[m.Id] = m
+	}
+	v.Songs = []Music{}
+	for _, id := range v.MusicIDs {
+		if m, ok := byID[id]; ok {
+			v.Songs = append(v.Songs, m)
+		}
+	}
+	return &v, nil
+}
+
+// 修改歌单的名称和描述，为空的参数保持不变；userID 为操作者
+func RenamePlaylist(playlistID, userID int64, name, description string) (*Playlist, error) {
+	activity := &PlaylistActivity{PlaylistID: playlistID, UserID: userID, Action: PlaylistActionRename}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var playlist Playlist
+		if err := tx.First(&playlist, playlistID).Error; err != nil {
+			return ErrPlaylistNotFound
+		}
+		if playlist.ReadOnly() {
+			return ErrPlaylistReadOnly
+		}
+		updates := map[string]any{}
+		if name != "" {
+			updates["name"] = name
+		}
+		if description != "" {
+			updates["description"] = description
+		}
+		if len(updates) == 0 {
+			activity = nil
+			return nil
+		}
+		if err := tx.Model(&playlist).Updates(updates).Error; err != nil {
+			return err
+		}
+		return recordPlaylistActivity(tx, activity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	broadcastPlaylistActivity(activity, nil)
+	return GetPlaylistByID(playlistID)
+}
+
+// 把歌单的歌曲、名称和描述恢复为 version 时的样子，并作为一个新版本记录；
+// 快照中已从曲库删除的歌曲会被跳过。智能歌单没有固定的歌曲，恢复的是当时的规则
+func RollbackPlaylist(playlistID, userID, version int64) (*Playlist, error) {
+	activity := &PlaylistActivity{PlaylistID: playlistID, UserID: userID, Action: PlaylistActionRollback, TargetVersion: version}
+	var dirty []int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var playlist Playlist
+		if err := tx.First(&playlist, playlistID).Error; err != nil {
+			return ErrPlaylistNotFound
+		}
+		smart := playlist.Kind == PlaylistKindSmart
+		if playlist.ReadOnly() && !smart {
+			return ErrPlaylistReadOnly
+		}
+		var snapshot PlaylistVersion
+		if err := tx.Where("playlist_id = ? AND version = ?", playlistID, version).First(&snapshot).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPlaylistVersionNotFound
+			}
+			return err
+		}
+		if smart {
+			// 早期的快照没有保存规则，无法恢复
+			if snapshot.Smart == nil {
+				return ErrPlaylistVersionNotFound
+			}
+			playlist.Name, playlist.Description, playlist.Smart = snapshot.Name, snapshot.Description, snapshot.Smart
+			if err := tx.Model(&playlist).Select("name", "description", "smart_rule").Updates(&playlist).Error; err != nil {
+				return err
+			}
+			return recordPlaylistActivity(tx, activity)
+		}
+
+		var existing []int64
+		if err := tx.Model(&Music{}).Where("id IN ?", snapshot.MusicIDs).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		exists := make(map[int64]bool, len(existing))
+		for _, id := range existing {
+			exists[id] = true
+		}
+		musicIDs := make([]int64, 0, len(existing))
+		for _, id := range snapshot.MusicIDs {
+			if exists[id] {
+				musicIDs = append(musicIDs, id)
+			}
+		}
+		old, err := replacePlaylistItems(tx, playlistID, musicIDs)
+		if err != nil {
+			return err
+		}
+		dirty = append(old, musicIDs...)
+
+		err = tx.Model(&playlist).Updates(map[string]any{"name": snapshot.Name, "description": snapshot.Description}).Error
+		if err != nil {
+			return err
+		}
+		return recordPlaylistActivity(tx, activity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	MarkSimilarityDirty(dirty...)
+	broadcastPlaylistActivity(activity, nil)
+	return GetPlaylistByID(playlistID)
+}
+
+// ==== 回收站 ====
+
+// 回收站中的歌单
+type TrashedPlaylist struct {
+	Playlist
+	PurgeAt time.Time `json:"purgeAt"` // 到期后彻底删除
+}
+
+// 用户回收站中的歌单，最近删除的在前
+func GetTrashedPlaylists(userID int64) ([]TrashedPlaylist, error) {
+	var playlists []Playlist
+	err := DB.Unscoped().Where("owner_user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC, id DESC").Find(&playlists).Error
+	if err != nil {
+		return nil, err
+	}
+	list := make([]TrashedPlaylist, 0, len(playlists))
+	for _, p := range playlists {
+		list = append(list, TrashedPlaylist{Playlist: p, PurgeAt: p.DeletedAt.Time.AddDate(0, 0, PlaylistTrashDays)})
+	}
+	return list, nil
+}
+
+// 查询回收站中的歌单，只有创建者可以操作
+func getTrashedPlaylist(tx *gorm.DB, playlistID, userID int64) (*Playlist, error) {
+	var playlist Playlist
+	err := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", playlistID).First(&playlist).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlaylistNotFound
+		}
+		return nil, err
+	}
+	if playlist.OwnerUserID != userID {
+		return nil, ErrPlaylistForbidden
+	}
+	return &playlist, nil
+}
+
+// 从回收站恢复歌单，歌曲、成员和修改历史保持删除前的样子
+func RestorePlaylist(playlistID, userID int64) (*Playlist, error) {
+	activity := &PlaylistActivity{PlaylistID: playlistID, UserID: userID, Action: PlaylistActionRestore}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		playlist, err := getTrashedPlaylist(tx, playlistID, userID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(playlist).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return recordPlaylistActivity(tx, activity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var musicIDs []int64
+	if err := DB.Model(&PlaylistItem{}).Where("playlist_id = ?", playlistID).Pluck("music_id", &musicIDs).Error; err == nil {
+		MarkSimilarityDirty(musicIDs...)
+	}
+	broadcastPlaylistActivity(activity, nil)
+	return GetPlaylistByID(playlistID)
+}
+
+// 立即彻底删除回收站中的歌单
+func PurgePlaylist(playlistID, userID int64) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := getTrashedPlaylist(tx, playlistID, userID); err != nil {
+			return err
+		}
+		return purgePlaylist(tx, playlistID)
+	})
+}
+
+// 彻底删除歌单及其歌曲、成员、动态、历史版本、在文件夹中的位置和分享链接
+func purgePlaylist(tx *gorm.DB, playlistID int64) error {
+	for _, model := range []any{&PlaylistItem{}, &PlaylistMember{}, &PlaylistActivity{}, &PlaylistVersion{}, &PlaylistFolderEntry{}} {
+		if err := tx.Where("playlist_id = ?", playlistID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("kind = ? AND target_id = ?", ShareKindPlaylist, playlistID).Delete(&ShareLink{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&Playlist{}, playlistID).Error
+}
+
+// 彻底删除在回收站中超过 PlaylistTrashDays 天的歌单，返回删除的数量
+func PurgeExpiredPlaylists(now time.Time) (int, error) {
+	var ids []int64
+	err := DB.Unscoped().Model(&Playlist{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", now.AddDate(0, 0, -PlaylistTrashDays)).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := DB.Transaction(func(tx *gorm.DB) error { return purgePlaylist(tx, id) }); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// 启动回收站的定期清理，保留天数由 PLAYLIST_TRASH_DAYS 配置，默认 30 天
+func StartPlaylistTrashPurger() {
+	if v, err := strconv.Atoi(os.Getenv("PLAYLIST_TRASH_DAYS")); err == nil && v > 0 {
+		PlaylistTrashDays = v
+	}
+
+	go func() {
+		ticker := time.NewTicker(playlistPurgePeriod)
+		defer ticker.Stop()
+		for {
+			if n, err := PurgeExpiredPlaylists(time.Now()); err != nil {
+				log.Printf("playlist trash: %v", err)
+			} else if n > 0 {
+				log.Printf("playlist trash: purged %d playlists", n)
+			}
+			<-ticker.C
+		}
+	}()
+}
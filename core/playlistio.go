@@ -0,0 +1,441 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// 歌单导入导出的格式
+const (
+	PlaylistFormatM3U8 = "m3u8" // 扩展 M3U，UTF-8 编码
+	PlaylistFormatXSPF = "xspf"
+	PlaylistFormatJSON = "json" // 本应用的格式，见 PlaylistExport
+)
+
+// 导出文件中歌曲位置的写法
+const (
+	PlaylistLocationPath = "path" // 曲库中的相对路径，与 Music.AudioURL 一致
+	PlaylistLocationURL  = "url"  // 本服务的播放地址，需要提供 baseURL
+)
+
+// 导入请求体的大小上限
+const maxPlaylistImportSize = 8 << 20
+
+const playlistExportFormat = "nmp-playlist"
+
+var ErrUnknownPlaylistFormat = errors.New("unknown playlist format")
+
+// 本服务播放地址中的歌曲 ID，导入时直接按 ID 匹配
+var playURLPattern = regexp.MustCompile(`/api/music/play/(\d+)(?:$|[?#])`)
+
+// 本应用的歌单格式
+type PlaylistExport struct {
+	Format      string                `json:"format"` // 固定为 nmp-playlist
+	Version     int                   `json:"version"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	ExportedAt  time.Time             `json:"exportedAt"`
+	Tracks      []PlaylistExportTrack `json:"tracks"`
+}
+
+type PlaylistExportTrack struct {
+	Title      string   `json:"title"`
+	Singer     string   `json:"singer"`
+	Album      string   `json:"album,omitempty"`
+	DurationMs int64    `json:"durationMs,omitempty"`
+	Labels     []string `json:"labels,omitempty"`
+	Location   string   `json:"location"`
+}
+
+type xspfPlaylist struct {
+	XMLName    xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version    string      `xml:"version,attr"`
+	Title      string      `xml:"title,omitempty"`
+	Annotation string      `xml:"annotation,omitempty"`
+	Date       string      `xml:"date,omitempty"`
+	Tracks     []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location,omitempty"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Duration int64  `xml:"duration,omitempty"` // 毫秒
+}
+
+// ==== 导出 ====
+
+// 导出的文件
+type PlaylistFile struct {
+	Name        string // 建议的文件名
+	ContentType string
+	Data        []byte
+}
+
+func exportLocation(m Music, location, baseURL string) string {
+	if location == PlaylistLocationURL {
+		return strings.TrimRight(baseURL, "/") + "/api/music/play/" + strconv.FormatInt(m.Id, 10)
+	}
+	return m.AudioURL
+}
+
+// 把歌单导出为 M3U8、XSPF 或 JSON；location 为 url 时歌曲位置写为 baseURL 下的播放地址
+func ExportPlaylist(playlistID int64, format, location, baseURL string) (*PlaylistFile, error) {
+	p, err := GetPlaylistByID(playlistID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPlaylistNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return encodePlaylist(p, format, location, baseURL)
+}
+
+func encodePlaylist(p *Playlist, format, location, baseURL string) (*PlaylistFile, error) {
+	file := &PlaylistFile{Name: p.Name + "." + format}
+	var buf bytes.Buffer
+	switch format {
+	case PlaylistFormatM3U8:
+		file.ContentType = "audio/x-mpegurl; charset=utf-8"
+		buf.WriteString("#EXTM3U\n")
+		fmt.Fprintf(&buf, "#PLAYLIST:%s\n", oneLine(p.Name))
+		for _, it := range p.Items {
+			m := it.Music
+			seconds := int64(-1)
+			if m.DurationMs > 0 {
+				seconds = (m.DurationMs + 500) / 1000
+			}
+			fmt.Fprintf(&buf, "#EXTINF:%d,%s - %s\n", seconds, oneLine(m.Singer), oneLine(m.Title))
+			if m.Album != "" {
+				fmt.Fprintf(&buf, "#EXTALB:%s\n", oneLine(m.Album))
+			}
+			buf.WriteString(exportLocation(m, location, baseURL) + "\n")
+		}
+	case PlaylistFormatXSPF:
+		file.ContentType = "application/xspf+xml; charset=utf-8"
+		doc := xspfPlaylist{Version: "1", Title: p.Name, Annotation: p.Description, Date: time.Now().Format(time.RFC3339)}
+		for _, it := range p.Items {
+			m := it.Music
+			loc := exportLocation(m, location, baseURL)
+			if location != PlaylistLocationURL {
+				// XSPF 要求 location 为 URI
+				loc = (&url.URL{Path: loc}).String()
+			}
+			doc.Tracks = append(doc.Tracks, xspfTrack{Location: loc, Title: m.Title, Creator: m.Singer, Album: m.Album, Duration: m.DurationMs})
+		}
+		buf.WriteString(xml.Header)
+		enc := xml.NewEncoder(&buf)
+		enc.Indent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n")
+	case PlaylistFormatJSON:
+		file.ContentType = "application/json; charset=utf-8"
+		doc := PlaylistExport{
+			Format:      playlistExportFormat,
+			Version:     1,
+			Name:        p.Name,
+			Description: p.Description,
+			ExportedAt:  time.Now(),
+			Tracks:      make([]PlaylistExportTrack, 0, len(p.Items)),
+		}
+		for _, it := range p.Items {
+			m := it.Music
+			doc.Tracks = append(doc.Tracks, PlaylistExportTrack{
+				Title:      m.Title,
+				Singer:     m.Singer,
+				Album:      m.Album,
+				DurationMs: m.DurationMs,
+				Labels:     m.Labels,
+				Location:   exportLocation(m, location, baseURL),
+			})
+		}
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPlaylistFormat, format)
+	}
+	file.Data = buf.Bytes()
+	return file, nil
+}
+
+// 去掉换行，避免破坏 M3U 的行结构
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// ==== 导入 ====
+
+// 从导入文件中解析出的一首歌，Line 为在文件中的行号（M3U）或序号（XSPF、JSON），从 1 开始
+type PlaylistEntry struct {
+	Line     int    `json:"line"`
+	Title    string `json:"title,omitempty"`
+	Singer   string `json:"singer,omitempty"`
+	Location string `json:"location,omitempty"`
+}
+
+// 导入结果
+type PlaylistImportResult struct {
+	Playlist   *Playlist       `json:"playlist"` // 新建的歌单，dryRun 时为空
+	Format     string          `json:"format"`
+	Name       string          `json:"name"`
+	Total      int             `json:"total"`      // 解析出的歌曲数
+	Matched    int             `json:"matched"`    // 匹配到曲库的歌曲数（不含重复）
+	ByPath     int             `json:"byPath"`     // 其中按路径匹配的
+	ByTitle    int             `json:"byTitle"`    // 其中按标题和歌手匹配的
+	Duplicates int             `json:"duplicates"` // 匹配到歌单中已有歌曲的条目
+	Unmatched  []PlaylistEntry `json:"unmatched"`
+	DryRun     bool            `json:"dryRun"`
+}
+
+// 根据文件名和内容判断格式
+func detectPlaylistFormat(name string, data []byte) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".m3u", ".m3u8":
+		return PlaylistFormatM3U8
+	case ".xspf":
+		return PlaylistFormatXSPF
+	case ".json":
+		return PlaylistFormatJSON
+	}
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return PlaylistFormatXSPF
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return PlaylistFormatJSON
+	}
+	return PlaylistFormatM3U8
+}
+
+// 解析 M3U / M3U8：#EXTINF:时长,歌手 - 标题 的下一行为歌曲位置
+func parseM3U(data []byte) (string, []PlaylistEntry) {
+	var name string
+	var entries []PlaylistEntry
+	var pending PlaylistEntry
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "#PLAYLIST:"):
+			name = strings.TrimSpace(strings.TrimPrefix(text, "#PLAYLIST:"))
+		case strings.HasPrefix(text, "#EXTINF:"):
+			pending = PlaylistEntry{}
+			info := strings.TrimPrefix(text, "#EXTINF:")
+			if i := strings.Index(info, ","); i >= 0 {
+				info = strings.TrimSpace(info[i+1:])
+			}
+			if singer, title, ok := strings.Cut(info, " - "); ok {
+				pending.Singer, pending.Title = strings.TrimSpace(singer), strings.TrimSpace(title)
+			} else {
+				pending.Title = info
+			}
+		case strings.HasPrefix(text, "#"):
+		default:
+			pending.Line = line
+			pending.Location = text
+			entries = append(entries, pending)
+			pending = PlaylistEntry{}
+		}
+	}
+	return name, entries
+}
+
+func parseXSPF(data []byte) (string, string, []PlaylistEntry, error) {
+	var doc xspfPlaylist
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.DefaultSpace = "http://xspf.org/ns/0/"
+	if err := dec.Decode(&doc); err != nil {
+		return "", "", nil, fmt.Errorf("invalid xspf: %w", err)
+	}
+	entries := make([]PlaylistEntry, 0, len(doc.Tracks))
+	for i, t := range doc.Tracks {
+		entries = append(entries, PlaylistEntry{Line: i + 1, Title: t.Title, Singer: t.Creator, Location: t.Location})
+	}
+	return doc.Title, doc.Annotation, entries, nil
+}
+
+func parsePlaylistJSON(data []byte) (string, string, []PlaylistEntry, error) {
+	var doc PlaylistExport
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", "", nil, fmt.Errorf("invalid json: %w", err)
+	}
+	entries := make([]PlaylistEntry, 0, len(doc.Tracks))
+	for i, t := range doc.Tracks {
+		entries = append(entries, PlaylistEntry{Line: i + 1, Title: t.Title, Singer: t.Singer, Location: t.Location})
+	}
+	return doc.Name, doc.Description, entries, nil
+}
+
+// 规范化歌曲位置：去掉 file:// 前缀、URL 编码和曲库根目录，统一分隔符与 Unicode 形式
+func normalizeLocation(loc string) string {
+	loc = strings.TrimPrefix(strings.TrimSpace(loc), "file://")
+	if u, err := url.PathUnescape(loc); err == nil {
+		loc = u
+	}
+	loc = strings.ReplaceAll(loc, "\\", "/")
+	loc = path.Clean("/" + loc)
+	loc = strings.TrimPrefix(loc, MusicRoot+"/")
+	return strings.ToLower(norm.NFC.String(strings.TrimPrefix(loc, "/")))
+}
+
+// 按路径匹配曲库：先匹配完整的相对路径，再匹配唯一的文件名
+type pathMatcher struct {
+	byPath map[string]int64
+	byBase map[string]int64 // 文件名重复的记为 0
+}
+
+func newPathMatcher(songs []Music) *pathMatcher {
+	m := &pathMatcher{byPath: map[string]int64{}, byBase: map[string]int64{}}
+	for _, s := range songs {
+		p := normalizeLocation(s.AudioURL)
+		m.byPath[p] = s.Id
+		base := path.Base(p)
+		if _, ok := m.byBase[base]; ok {
+			m.byBase[base] = 0
+		} else {
+			m.byBase[base] = s.Id
+		}
+	}
+	return m
+}
+
+func (m *pathMatcher) match(loc string, songs map[int64]bool) (int64, bool) {
+	if loc == "" {
+		return 0, false
+	}
+	if sub := playURLPattern.FindStringSubmatch(loc); sub != nil {
+		id, _ := strconv.ParseInt(sub[1], 10, 64)
+		return id, songs[id]
+	}
+	p := normalizeLocation(loc)
+	if id, ok := m.byPath[p]; ok {
+		return id, true
+	}
+	if id := m.byBase[path.Base(p)]; id != 0 {
+		return id, true
+	}
+	return 0, false
+}
+
+// 导入歌单：按路径、再按标题和歌手匹配曲库中的歌曲，并以 userID 为创建者新建歌单
+// format 为空时根据文件名和内容判断；name 为空时使用文件中的歌单名，再没有时使用文件名；歌单描述取自文件
+func ImportPlaylist(userID int64, file ImportFile, format, name string, dryRun bool) (*PlaylistImportResult, error) {
+	if format == "" {
+		format = detectPlaylistFormat(file.Name, file.Data)
+	}
+	var fileName, description string
+	var entries []PlaylistEntry
+	var err error
+	switch format {
+	case PlaylistFormatM3U8, "m3u":
+		format = PlaylistFormatM3U8
+		fileName, entries = parseM3U(file.Data)
+	case PlaylistFormatXSPF:
+		fileName, description, entries, err = parseXSPF(file.Data)
+	case PlaylistFormatJSON:
+		fileName, description, entries, err = parsePlaylistJSON(file.Data)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPlaylistFormat, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no tracks found in playlist file")
+	}
+
+	if name == "" {
+		name = fileName
+	}
+	if name == "" && file.Name != "" && file.Name != "body" {
+		name = strings.TrimSuffix(path.Base(file.Name), path.Ext(file.Name))
+	}
+	if name == "" {
+		name = "导入的歌单"
+	}
+
+	songs, err := GetAllSongs()
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[int64]bool, len(songs))
+	for _, s := range songs {
+		exists[s.Id] = true
+	}
+	paths := newPathMatcher(songs)
+	titles := NewMusicMatcher(songs)
+
+	result := &PlaylistImportResult{Format: format, Name: name, Total: len(entries), Unmatched: []PlaylistEntry{}, DryRun: dryRun}
+	var ids []int64
+	added := map[int64]bool{}
+	for _, e := range entries {
+		id, ok := paths.match(e.Location, exists)
+		byPath := ok
+		if !ok && e.Title != "" {
+			id, ok = titles.Match(e.Title, e.Singer)
+		}
+		if !ok {
+			result.Unmatched = append(result.Unmatched, e)
+			continue
+		}
+		if added[id] {
+			result.Duplicates++
+			continue
+		}
+		added[id] = true
+		ids = append(ids, id)
+		if byPath {
+			result.ByPath++
+		} else {
+			result.ByTitle++
+		}
+	}
+	result.Matched = len(ids)
+	if dryRun {
+		return result, nil
+	}
+
+	// 歌单和歌曲在同一个事务中写入，失败时不会留下空歌单
+	var p *Playlist
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if p, err = createPlaylist(tx, name, description, userID); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if _, err := replacePlaylistItems(tx, p.ID, ids); err != nil {
+			return err
+		}
+		return recordPlaylistActivity(tx, &PlaylistActivity{PlaylistID: p.ID, UserID: userID, Action: PlaylistActionImport})
+	})
+	if err != nil {
+		return nil, err
+	}
+	MarkSimilarityDirty(ids...)
+	if result.Playlist, err = GetPlaylistByID(p.ID); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
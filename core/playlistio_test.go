@@ -0,0 +1,203 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseM3U(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantName string
+		want     []PlaylistEntry
+	}{
+		{
+			name:     "extended",
+			data:     "#EXTM3U\n#PLAYLIST:Road Trip\n#EXTINF:269,周杰伦 - 晴天\n#EXTALB:叶惠美\n晴天.mp3\n\n#EXTINF:-1,Artist - Title - Live\nhttp://host/a.mp3\n",
+			wantName: "Road Trip",
+			want: []PlaylistEntry{
+				{Line: 5, Title: "晴天", Singer: "周杰伦", Location: "晴天.mp3"},
+				{Line: 8, Title: "Title - Live", Singer: "Artist", Location: "http://host/a.mp3"},
+			},
+		},
+		{
+			name: "plain with bom and crlf",
+			data: "\xef\xbb\xbfC:\\Music\\a.mp3\r\n# comment\r\nb.flac\r\n",
+			want: []PlaylistEntry{
+				{Line: 1, Location: `C:\Music\a.mp3`},
+				{Line: 3, Location: "b.flac"},
+			},
+		},
+		{
+			name: "extinf without singer",
+			data: "#EXTINF:120,Only Title\nx.mp3\ny.mp3\n",
+			want: []PlaylistEntry{
+				{Line: 2, Title: "Only Title", Location: "x.mp3"},
+				{Line: 3, Location: "y.mp3"},
+			},
+		},
+		{
+			name: "empty",
+			data: "#EXTM3U\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, entries := parseM3U([]byte(tt.data))
+			if name != tt.wantName {
+				t.Errorf("name = %q, want %q", name, tt.wantName)
+			}
+			if !reflect.DeepEqual(entries, tt.want) {
+				t.Errorf("entries = %+v, want %+v", entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseXSPF(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantName string
+		wantDesc string
+		want     []PlaylistEntry
+		wantErr  bool
+	}{
+		{
+			name: "namespaced",
+			data: `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <title>Mix</title>
+  <annotation>for the road</annotation>
+  <trackList>
+    <track><location>file:///music/a%20b.mp3</location><title>A B</title><creator>Singer</creator></track>
+    <track><title>No Location</title></track>
+  </trackList>
+</playlist>`,
+			wantName: "Mix",
+			wantDesc: "for the road",
+			want: []PlaylistEntry{
+				{Line: 1, Title: "A B", Singer: "Singer", Location: "file:///music/a%20b.mp3"},
+				{Line: 2, Title: "No Location"},
+			},
+		},
+		{
+			name:     "without namespace",
+			data:     `<playlist version="1"><title>Bare</title><trackList><track><location>x.mp3</location></track></trackList></playlist>`,
+			wantName: "Bare",
+			want:     []PlaylistEntry{{Line: 1, Location: "x.mp3"}},
+		},
+		{
+			name:    "invalid",
+			data:    `<playlist><trackList>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, desc, entries, err := parseXSPF([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if name != tt.wantName || desc != tt.wantDesc {
+				t.Errorf("name, desc = %q, %q; want %q, %q", name, desc, tt.wantName, tt.wantDesc)
+			}
+			if !reflect.DeepEqual(entries, tt.want) {
+				t.Errorf("entries = %+v, want %+v", entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeLocation(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"晴天.mp3", "晴天.mp3"},
+		{"  Jay/Qing Tian.MP3 ", "jay/qing tian.mp3"},
+		{"file:///music/jay/a.mp3", "jay/a.mp3"},
+		{"/music/a.mp3", "a.mp3"},
+		{"music/a.mp3", "a.mp3"},
+		{"%E6%99%B4%E5%A4%A9.mp3", "晴天.mp3"},
+		{`C:\Music\a.mp3`, "c:/music/a.mp3"},
+		{"jay/../b.mp3", "b.mp3"},
+		{"caf\u0065\u0301.mp3", "caf\u00e9.mp3"}, // NFD 转为 NFC
+		{"100%.mp3", "100%.mp3"},                 // 无效的 URL 编码保持原样
+	}
+	for _, tt := range tests {
+		if got := normalizeLocation(tt.in); got != tt.want {
+			t.Errorf("normalizeLocation(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPlaylistRoundTrip(t *testing.T) {
+	songs := []Music{
+		{Id: 1, Title: "晴天", Singer: "周杰伦", Album: "叶惠美", DurationMs: 269000, AudioURL: "晴天.mp3"},
+		{Id: 2, Title: "Song - Live, 2020", Singer: "Band & Friends", AudioURL: "folder/with space.mp3"},
+		{Id: 3, Title: "Multi\nLine", Singer: "Singer", DurationMs: 1499, AudioURL: "x/y/z.flac"},
+	}
+	p := &Playlist{Name: "往返", Description: "round trip"}
+	for i, m := range songs {
+		p.Items = append(p.Items, PlaylistItem{MusicID: m.Id, TrackOrder: i + 1, Music: m})
+	}
+	exists := map[int64]bool{1: true, 2: true, 3: true}
+
+	for _, format := range []string{PlaylistFormatM3U8, PlaylistFormatXSPF, PlaylistFormatJSON} {
+		for _, location := range []string{PlaylistLocationPath, PlaylistLocationURL} {
+			t.Run(format+"/"+location, func(t *testing.T) {
+				file, err := encodePlaylist(p, format, location, "http://localhost:8080/")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := detectPlaylistFormat("", file.Data); got != format {
+					t.Errorf("detected format %q, want %q", got, format)
+				}
+
+				var name, desc string
+				var entries []PlaylistEntry
+				switch format {
+				case PlaylistFormatM3U8:
+					name, entries = parseM3U(file.Data)
+				case PlaylistFormatXSPF:
+					name, desc, entries, err = parseXSPF(file.Data)
+				case PlaylistFormatJSON:
+					name, desc, entries, err = parsePlaylistJSON(file.Data)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if name != p.Name {
+					t.Errorf("name = %q, want %q", name, p.Name)
+				}
+				if format != PlaylistFormatM3U8 && desc != p.Description {
+					t.Errorf("description = %q, want %q", desc, p.Description)
+				}
+				if len(entries) != len(songs) {
+					t.Fatalf("got %d entries, want %d", len(entries), len(songs))
+				}
+
+				paths := newPathMatcher(songs)
+				for i, e := range entries {
+					id, ok := paths.match(e.Location, exists)
+					if !ok || id != songs[i].Id {
+						t.Errorf("entry %d location %q matched %d, %v; want %d", i, e.Location, id, ok, songs[i].Id)
+					}
+					singer, title := songs[i].Singer, songs[i].Title
+					if format == PlaylistFormatM3U8 {
+						// M3U 一首歌只占一行
+						singer, title = oneLine(singer), oneLine(title)
+					}
+					if e.Singer != singer || e.Title != title {
+						t.Errorf("entry %d = %q - %q, want %q - %q", i, e.Singer, e.Title, songs[i].Singer, songs[i].Title)
+					}
+				}
+			})
+		}
+	}
+}
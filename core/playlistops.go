@@ -0,0 +1,277 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 歌单之间的运算
+const (
+	PlaylistOpUnion     = "union"     // 合并：按顺序依次加入各歌单的歌曲
+	PlaylistOpIntersect = "intersect" // 交集：第一个歌单中、同时出现在其他所有歌单中的歌曲
+	PlaylistOpSubtract  = "subtract"  // 差集：第一个歌单中、不在其他任何歌单中的歌曲
+)
+
+const playlistOpMaxOperands = 20
+
+var ErrInvalidPlaylistOp = errors.New("invalid playlist operation")
+
+// 判断“同一首歌”的键：规范化的标题和歌手，曲库中重复的歌曲也能识别；标题为空时退回歌曲 ID
+func songIdentity(m *Music) string {
+	if normalizeTitle(m.Title) == "" {
+		return fmt.Sprintf("#%d", m.Id)
+	}
+	return musicKey(m.Title, m.Singer)
+}
+
+// 在事务中把歌单的歌曲替换为 musicIDs（按顺序），返回替换前的歌曲
+func replacePlaylistItems(tx *gorm.DB, playlistID int64, musicIDs []int64) ([]int64, error) {
+	var old []int64
+	if err := tx.Model(&PlaylistItem{}).Where("playlist_id = ?", playlistID).Pluck("music_id", &old).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("playlist_id = ?", playlistID).Delete(&PlaylistItem{}).Error; err != nil {
+		return nil, err
+	}
+	items := make([]PlaylistItem, 0, len(musicIDs))
+	for i, id := range musicIDs {
+		items = append(items, PlaylistItem{PlaylistID: playlistID, MusicID: id, TrackOrder: i + 1})
+	}
+	if len(items) > 0 {
+		if err := tx.Omit("Music").CreateInBatches(items, 500).Error; err != nil {
+			return nil, err
+		}
+	}
+	return old, nil
+}
+
+// ==== 合并、交集、差集 ====
+
+// 歌单运算的结果
+type PlaylistOpResult struct {
+	Op         string    `json:"op"`
+	Playlist   *Playlist `json:"playlist"`        // 写入结果的歌单，dryRun 时为 null
+	Songs      []Music   `json:"songs,omitempty"` // 仅 dryRun 时返回计算出的歌曲
+	Total      int       `json:"total"`           // 结果中的歌曲数
+	Added      int       `json:"added"`           // 相比目标歌单新增的歌曲数
+	Removed    int       `json:"removed"`         // 相比目标歌单移除的歌曲数
+	Duplicates int       `json:"duplicates"`      // 因与前面的歌曲重复而跳过的歌曲数
+}
+
+// 对歌单做合并、交集或差集运算，userID 为操作者
+// targetID 不为 0 时，目标歌单作为第一个运算对象，结果写回目标歌单；
+// 否则以 playlistIDs 的第一个歌单为第一个运算对象，结果保存为新歌单（name 为空时使用各歌单名称）
+// 同一首歌按 songIdentity 判断，只保留第一次出现的那一首
+func CombinePlaylists(userID int64, op string, playlistIDs []int64, targetID int64, name, description string, dryRun bool) (*PlaylistOpResult, error) {
+	switch op {
+	case PlaylistOpUnion, PlaylistOpIntersect, PlaylistOpSubtract:
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPlaylistOp, op)
+	}
+	ids := playlistIDs
+	if targetID != 0 {
+		ids = append([]int64{targetID}, playlistIDs...)
+	}
+	if len(ids) < 2 || len(ids) > playlistOpMaxOperands {
+		return nil, fmt.Errorf("%w: between 2 and %d playlists are required", ErrInvalidPlaylistOp, playlistOpMaxOperands)
+	}
+	seenIDs := make(map[int64]bool, len(ids))
+	operands := make([]*Playlist, 0, len(ids))
+	for _, id := range ids {
+		if seenIDs[id] {
+			return nil, fmt.Errorf("%w: playlist %d appears more than once", ErrInvalidPlaylistOp, id)
+		}
+		seenIDs[id] = true
+		p, err := GetPlaylistByID(id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlaylistNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, p)
+	}
+	if targetID != 0 && operands[0].ReadOnly() {
+		return nil, ErrPlaylistReadOnly
+	}
+
+	// 其他运算对象中出现的歌曲
+	sets := make([]map[string]bool, len(operands))
+	for i, p := range operands {
+		sets[i] = make(map[string]bool, len(p.Items))
+		for _, it := range p.Items {
+			sets[i][songIdentity(&it.Music)] = true
+		}
+	}
+	keep := func(key string) bool {
+		for _, set := range sets[1:] {
+			if op == PlaylistOpIntersect && !set[key] {
+				return false
+			}
+			if op == PlaylistOpSubtract && set[key] {
+				return false
+			}
+		}
+		return true
+	}
+
+	result := &PlaylistOpResult{Op: op, Songs: []Music{}}
+	sources := operands[:1]
+	if op == PlaylistOpUnion {
+		sources = operands
+	}
+	seen := map[string]bool{}
+	musicIDs := []int64{}
+	for i, p := range sources {
+		for _, it := range p.Items {
+			key := songIdentity(&it.Music)
+			// 目标歌单中原有的重复歌曲保持不变，由 DedupePlaylist 清理
+			if seen[key] && (targetID == 0 || i > 0) {
+				result.Duplicates++
+				continue
+			}
+			seen[key] = true
+			if keep(key) {
+				musicIDs = append(musicIDs, it.MusicID)
+				result.Songs = append(result.Songs, it.Music)
+			}
+		}
+	}
+	result.Total = len(musicIDs)
+
+	before := map[int64]bool{}
+	if targetID != 0 {
+		for _, it := range operands[0].Items {
+			before[it.MusicID] = true
+		}
+	}
+	after := make(map[int64]bool, len(musicIDs))
+	for _, id := range musicIDs {
+		after[id] = true
+		if !before[id] {
+			result.Added++
+		}
+	}
+	for id := range before {
+		if !after[id] {
+			result.Removed++
+		}
+	}
+	if dryRun {
+		return result, nil
+	}
+	result.Songs = nil
+
+	if name == "" {
+		names := make([]string, 0, len(operands))
+		for _, p := range operands {
+			names = append(names, p.Name)
+		}
+		name = strings.Join(names, "、")
+	}
+	var activity *PlaylistActivity
+	var dirty []int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		playlistID := targetID
+		if targetID == 0 {
+			p, err := createPlaylist(tx, name, description, userID)
+			if err != nil {
+				return err
+			}
+			playlistID = p.ID
+		} else if err := claimPlaylistVersion(tx, targetID, operands[0].Version); err != nil {
+			// 计算期间目标歌单被修改过时放弃，避免覆盖别人的修改
+			return err
+		}
+		old, err := replacePlaylistItems(tx, playlistID, musicIDs)
+		if err != nil {
+			return err
+		}
+		dirty = append(old, musicIDs...)
+		activity = &PlaylistActivity{PlaylistID: playlistID, UserID: userID, Action: PlaylistActionMerge}
+		if targetID == 0 {
+			return recordPlaylistActivity(tx, activity)
+		}
+		return savePlaylistActivity(tx, activity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	MarkSimilarityDirty(dirty...)
+	broadcastPlaylistActivity(activity, nil)
+	if result.Playlist, err = GetPlaylistByID(activity.PlaylistID); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ==== 清理重复歌曲 ====
+
+// 清理时被移除的歌曲
+type DuplicateItem struct {
+	Music       Music `json:"music"`
+	DuplicateOf int64 `json:"duplicateOf"` // 保留的那一首的 ID
+}
+
+// 清理重复歌曲的结果
+type PlaylistDedupeResult struct {
+	Playlist *Playlist       `json:"playlist"`
+	Removed  []DuplicateItem `json:"removed"`
+	DryRun   bool            `json:"dryRun"`
+}
+
+// 移除歌单中规范化标题和歌手相同的歌曲，每组保留最靠前的一首；userID 为操作者
+// dryRun 为 true 时只返回将被移除的歌曲
+func DedupePlaylist(playlistID, userID int64, dryRun bool) (*PlaylistDedupeResult, error) {
+	p, err := GetPlaylistByID(playlistID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPlaylistNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.ReadOnly() {
+		return nil, ErrPlaylistReadOnly
+	}
+
+	result := &PlaylistDedupeResult{Playlist: p, Removed: []DuplicateItem{}, DryRun: dryRun}
+	first := map[string]int64{}
+	var removeIDs []int64
+	for _, it := range p.Items {
+		key := songIdentity(&it.Music)
+		if keepID, ok := first[key]; ok {
+			result.Removed = append(result.Removed, DuplicateItem{Music: it.Music, DuplicateOf: keepID})
+			removeIDs = append(removeIDs, it.MusicID)
+			continue
+		}
+		first[key] = it.MusicID
+	}
+	if dryRun || len(removeIDs) == 0 {
+		return result, nil
+	}
+
+	activity := &PlaylistActivity{PlaylistID: playlistID, UserID: userID, Action: PlaylistActionDedupe}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		// 读取歌单后又被修改过时放弃，要移除的歌曲是按读到的内容算出来的
+		if err := claimPlaylistVersion(tx, playlistID, p.Version); err != nil {
+			return err
+		}
+		err := tx.Where("playlist_id = ? AND music_id IN ?", playlistID, removeIDs).Delete(&PlaylistItem{}).Error
+		if err != nil {
+			return err
+		}
+		return savePlaylistActivity(tx, activity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	MarkSimilarityDirty(removeIDs...)
+	broadcastPlaylistActivity(activity, nil)
+	if result.Playlist, err = GetPlaylistByID(playlistID); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Postgres 后端：标签保存在 music 表的 text[] 列上，用 GIN 索引和 && 运算符检索
+type postgresRepository struct {
+	gormRepository
+}
+
+// 连接 Postgres，数据库容器可能还没启动完成，失败时重试
+func openPostgres(dsn string) (*postgresRepository, error) {
+	var db *gorm.DB
+	var err error
+	for i := 0; i < 10; i++ {
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err == nil {
+			// 确保底层连接可用
+			sqlDB, cerr := db.DB()
+			if cerr == nil {
+				if pingErr := sqlDB.Ping(); pingErr == nil {
+					err = nil
+					break
+				} else {
+					err = pingErr
+				}
+			} else {
+				err = cerr
+			}
+		}
+		log.Printf("failed to connect to db (attempt %d/10): %v, retrying...", i+1, err)
+		time.Sleep(2 * time.Second)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &postgresRepository{gormRepository{db: db}}, nil
+}
+
+func (r *postgresRepository) Backend() string {
+	return BackendPostgres
+}
+
+func (r *postgresRepository) Migrate(models ...interface{}) error {
+	if err := r.db.AutoMigrate(models...); err != nil {
+		return err
+	}
+
+	// 创建索引
+	if execErr := r.db.Exec("CREATE INDEX IF NOT EXISTS idx_music_labels_gin ON music USING GIN (labels);").Error; execErr != nil {
+		log.Printf("failed to create idx_music_labels_gin: %v", execErr)
+	}
+	if execErr := r.db.Exec("CREATE INDEX IF NOT EXISTS idx_music_delabels_gin ON music USING GIN (delabels);").Error; execErr != nil {
+		log.Printf("failed to create idx_music_delabels_gin: %v", execErr)
+	}
+	return nil
+}
+
+func (r *postgresRepository) SearchMusicByLabels(labels []string, limit int) ([]Music, error) {
+	var songs []Music
+
+	// 使用 Postgres 的 && 操作符 (数组重叠)
+	// 只要 labels 字段包含参数中的*任意*一个标签，就算匹配
+	// pq.StringArray(labels) 会将 Go切片 转换为 Postgres 数组格式 '{Pop,Rock}'
+	err := r.db.Where("labels && ?", pq.StringArray(labels)).Limit(limit).Find(&songs).Error
+
+	return songs, err
+}
+
+func (r *postgresRepository) SearchMusicByText(text string) ([]Music, error) {
+	var songs []Music
+	pattern := fmt.Sprintf("%%%s%%", text) // 构造模糊匹配模式
+
+	err := r.db.Where("title ILIKE ? OR singer ILIKE ?", pattern, pattern).Find(&songs).Error
+
+	return songs, err
+}
+
+func (r *postgresRepository) SetMusicLabels(tx *gorm.DB, musicID int64, labels, delabels []string) error {
+	return tx.Model(&Music{}).Where("id = ?", musicID).Updates(map[string]interface{}{
+		"labels":   pq.StringArray(labels),
+		"delabels": pq.StringArray(delabels),
+	}).Error
+}
+
+func (r *postgresRepository) TimePart(part, column string) string {
+	switch part {
+	case TimePartHour:
+		return "CAST(EXTRACT(HOUR FROM " + column + ") AS INTEGER)"
+	case TimePartWeekday:
+		return "CAST(EXTRACT(DOW FROM " + column + ") AS INTEGER)"
+	case TimePartMonth:
+		return "CAST(EXTRACT(MONTH FROM " + column + ") AS INTEGER)"
+	default:
+		return "TO_CHAR(" + column + ", 'YYYY-MM-DD')"
+	}
+}
+
+func (r *postgresRepository) JoinMusicLabels(q *gorm.DB) *gorm.DB {
+	return q.Joins("CROSS JOIN LATERAL unnest(music.labels) AS l(label)")
+}
@@ -0,0 +1,596 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 循环模式
+const (
+	RepeatOff = "off" // 播放到最后一首后停止
+	RepeatAll = "all" // 列表循环
+	RepeatOne = "one" // 单曲循环
+)
+
+// 设置播放队列的来源
+const (
+	QueueSourceTracks    = "tracks"    // 直接指定歌曲 ID，如客户端已有的搜索结果或推荐结果
+	QueueSourcePlaylist  = "playlist"  // 歌单（包括每日推荐歌单）
+	QueueSourceSearch    = "search"    // 模糊搜索结果
+	QueueSourceSimilar   = "similar"   // 相似歌曲
+	QueueSourceRecommend = "recommend" // 此刻推荐
+)
+
+const (
+	queueMaxItems       = 1000
+	queueRestartAfterMs = 3000 // 上一首：当前歌曲已播放超过该时长时回到开头
+	defaultDeviceID     = "default"
+)
+
+var (
+	ErrQueueItemNotFound = errors.New("queue item not found")
+	ErrInvalidQueue      = errors.New("invalid queue operation")
+)
+
+// 队列中的一项；同一首歌可以出现多次，用条目 ID 区分
+type QueueItem struct {
+	ID      int64 `json:"itemId"`
+	MusicID int64 `json:"musicId"`
+}
+
+// 播放队列，按用户和设备分别保存，换设备或刷新页面后可以恢复
+type PlayQueue struct {
+	ID          int64       `json:"-" gorm:"primaryKey;autoIncrement;column:id"`
+	UserID      int64       `json:"userId" gorm:"column:user_id;not null;uniqueIndex:idx_play_queue_device"`
+	DeviceID    string      `json:"deviceId" gorm:"column:device_id;type:varchar(64);not null;uniqueIndex:idx_play_queue_device"`
+	Items       []QueueItem `json:"-" gorm:"column:items;type:text;serializer:json"`    // 播放顺序
+	Original    []int64     `json:"-" gorm:"column:original;type:text;serializer:json"` // 开启随机播放前的顺序（条目 ID），用于关闭随机播放时恢复
+	NextItemID  int64       `json:"-" gorm:"column:next_item_id;not null"`
+	Shuffle     bool        `json:"shuffle" gorm:"column:shuffle;not null"`
+	ShuffleSeed int64       `json:"shuffleSeed" gorm:"column:shuffle_seed;not null"`
+	Repeat      string      `json:"repeat" gorm:"column:repeat_mode;type:varchar(8);not null;default:off"`
+	Current     int         `json:"currentIndex" gorm:"column:current_index;not null"` // 当前歌曲在 Items 中的位置，-1 表示没有正在播放的歌曲
+	PositionMs  int64       `json:"positionMs" gorm:"column:position_ms;not null"`
+	UpdatedAt   time.Time   `json:"updatedAt" gorm:"column:updated_at;autoUpdateTime"`
+}
+
+func (PlayQueue) TableName() string {
+	return "play_queues"
+}
+
+// 返回给客户端的队列：按播放顺序列出歌曲
+type QueueTrack struct {
+	ItemID int64 `json:"itemId"`
+	Music
+}
+
+type QueueState struct {
+	PlayQueue
+	Tracks []QueueTrack `json:"tracks"`
+}
+
+// 设置播放队列的参数，按 type 填写对应字段
+type QueueSource struct {
+	Type       string  `json:"type"`
+	MusicIDs   []int64 `json:"musicIds"`
+	PlaylistID int64   `json:"playlistId"`
+	Query      string  `json:"query"`
+	MusicID    int64   `json:"musicId"`
+	Activity   string  `json:"activity"`
+	Weather    string  `json:"weather"`
+	StartIndex int     `json:"startIndex"` // 从第几首开始播放（来源中的位置）
+}
+
+// 同一用户同一设备的队列操作串行执行
+var queueLocks sync.Map
+
+func lockQueue(userID int64, deviceID string) func() {
+	lock, _ := queueLocks.LoadOrStore(fmt.Sprintf("%d/%s", userID, deviceID), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+func loadQueue(tx *gorm.DB, userID int64, deviceID string) (*PlayQueue, error) {
+	q := &PlayQueue{}
+	err := tx.Where("user_id = ? AND device_id = ?", userID, deviceID).First(q).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &PlayQueue{UserID: userID, DeviceID: deviceID, Items: []QueueItem{}, Repeat: RepeatOff, Current: -1}, nil
+	}
+	return q, err
+}
+
+// 查询队列
+func GetPlayQueue(userID int64, deviceID string) (*QueueState, error) {
+	defer lockQueue(userID, deviceID)()
+	q, err := loadQueue(DB, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return queueState(q)
+}
+
+// 用户所有设备上的队列（不含歌曲），用于在其他设备上继续播放
+func GetPlayQueues(userID int64) ([]PlayQueue, error) {
+	queues := []PlayQueue{}
+	err := DB.Where("user_id = ?", userID).Order("updated_at DESC").Find(&queues).Error
+	return queues, err
+}
+
+// 载入队列、修改并保存
+func updateQueue(userID int64, deviceID string, fn func(q *PlayQueue) error) (*QueueState, error) {
+	defer lockQueue(userID, deviceID)()
+	q, err := loadQueue(DB, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(q); err != nil {
+		return nil, err
+	}
+	if len(q.Items) > queueMaxItems {
+		return nil, fmt.Errorf("%w: queue cannot exceed %d tracks", ErrInvalidQueue, queueMaxItems)
+	}
+	if err := DB.Save(q).Error; err != nil {
+		return nil, err
+	}
+	return queueState(q)
+}
+
+// 补充歌曲信息；已被删除的歌曲从队列中移除
+func queueState(q *PlayQueue) (*QueueState, error) {
+	ids := make([]int64, len(q.Items))
+	for i, it := range q.Items {
+		ids[i] = it.MusicID
+	}
+	var songs []Music
+	if len(ids) > 0 {
+		if err := DB.Where("id IN ?", ids).Find(&songs).Error; err != nil {
+			return nil, err
+		}
+	}
+	byID := make(map[int64]Music, len(songs))
+	for _, m := range songs {
+		byID[m.Id] = m
+	}
+
+	var missing []int64
+	for _, it := range q.Items {
+		if _, ok := byID[it.MusicID]; !ok {
+			missing = append(missing, it.ID)
+		}
+	}
+	if len(missing) > 0 {
+		q.removeItems(missing)
+		if err := DB.Save(q).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	state := &QueueState{PlayQueue: *q, Tracks: make([]QueueTrack, 0, len(q.Items))}
+	for _, it := range q.Items {
+		state.Tracks = append(state.Tracks, QueueTrack{ItemID: it.ID, Music: byID[it.MusicID]})
+	}
+	return state, nil
+}
+
+// ==== 队列操作 ====
+
+func (q *PlayQueue) newItems(musicIDs []int64) []QueueItem {
+	items := make([]QueueItem, len(musicIDs))
+	for i, id := range musicIDs {
+		q.NextItemID++
+		items[i] = QueueItem{ID: q.NextItemID, MusicID: id}
+	}
+	return items
+}
+
+func (q *PlayQueue) indexOf(itemID int64) int {
+	for i, it := range q.Items {
+		if it.ID == itemID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *PlayQueue) currentItemID() int64 {
+	if q.Current < 0 || q.Current >= len(q.Items) {
+		return 0
+	}
+	return q.Items[q.Current].ID
+}
+
+// 按种子打乱队列，当前歌曲移到最前面；同样的种子和歌曲得到同样的顺序
+func (q *PlayQueue) shuffleItems() {
+	current := q.currentItemID()
+	rng := rand.New(rand.NewSource(q.ShuffleSeed))
+	shuffled := make([]QueueItem, 0, len(q.Items))
+	for _, i := range rng.Perm(len(q.Items)) {
+		if q.Items[i].ID != current {
+			shuffled = append(shuffled, q.Items[i])
+		}
+	}
+	if current != 0 {
+		shuffled = append([]QueueItem{q.Items[q.Current]}, shuffled...)
+		q.Current = 0
+	}
+	q.Items = shuffled
+}
+
+// 关闭随机播放：恢复原来的顺序，当前歌曲不变
+func (q *PlayQueue) unshuffleItems() {
+	current := q.currentItemID()
+	byID := make(map[int64]QueueItem, len(q.Items))
+	for _, it := range q.Items {
+		byID[it.ID] = it
+	}
+	items := make([]QueueItem, 0, len(q.Items))
+	for _, id := range q.Original {
+		if it, ok := byID[id]; ok {
+			items = append(items, it)
+		}
+	}
+	q.Items = items
+	q.Original = nil
+	q.Current = q.indexOf(current)
+}
+
+func (q *PlayQueue) removeItems(itemIDs []int64) {
+	remove := make(map[int64]bool, len(itemIDs))
+	for _, id := range itemIDs {
+		remove[id] = true
+	}
+	current := q.currentItemID()
+	items := make([]QueueItem, 0, len(q.Items))
+	newCurrent := -1
+	for i, it := range q.Items {
+		if remove[it.ID] {
+			continue
+		}
+		// 当前歌曲被移除时，由它之后的第一首接替
+		if newCurrent < 0 && q.Current >= 0 && i >= q.Current {
+			newCurrent = len(items)
+		}
+		items = append(items, it)
+	}
+	if remove[current] {
+		q.PositionMs = 0
+	}
+	q.Items = items
+	q.Current = newCurrent
+
+	original := q.Original[:0]
+	for _, id := range q.Original {
+		if !remove[id] {
+			original = append(original, id)
+		}
+	}
+	q.Original = original
+}
+
+func (q *PlayQueue) moveItem(itemID int64, to int) error {
+	from := q.indexOf(itemID)
+	if from < 0 {
+		return ErrQueueItemNotFound
+	}
+	if to < 0 || to >= len(q.Items) {
+		return fmt.Errorf("%w: target index out of range", ErrInvalidQueue)
+	}
+	current := q.currentItemID()
+	it := q.Items[from]
+	q.Items = append(q.Items[:from], q.Items[from+1:]...)
+	q.Items = append(q.Items[:to], append([]QueueItem{it}, q.Items[to:]...)...)
+	q.Current = q.indexOf(current)
+	return nil
+}
+
+func (q *PlayQueue) next(auto bool) {
+	q.PositionMs = 0
+	if len(q.Items) == 0 || (auto && q.Repeat == RepeatOne && q.Current >= 0) {
+		return
+	}
+	q.Current++
+	if q.Current >= len(q.Items) {
+		if q.Repeat == RepeatOff {
+			q.Current = -1
+		} else {
+			q.Current = 0
+		}
+	}
+}
+
+func (q *PlayQueue) previous() {
+	if len(q.Items) == 0 {
+		return
+	}
+	if q.Current >= 0 && q.PositionMs > queueRestartAfterMs {
+		q.PositionMs = 0
+		return
+	}
+	q.PositionMs = 0
+	switch {
+	case q.Current < 0:
+		q.Current = len(q.Items) - 1
+	case q.Current > 0:
+		q.Current--
+	case q.Repeat != RepeatOff:
+		q.Current = len(q.Items) - 1
+	}
+}
+
+// 用指定的歌曲替换队列，从 start 开始播放
+func SetPlayQueue(userID int64, deviceID string, musicIDs []int64, start int) (*QueueState, error) {
+	return updateQueue(userID, deviceID, func(q *PlayQueue) error {
+		q.Items = q.newItems(musicIDs)
+		q.Original = nil
+		q.PositionMs = 0
+		q.Current = -1
+		if len(q.Items) > 0 {
+			q.Current = max(0, min(start, len(q.Items)-1))
+		}
+		if q.Shuffle {
+			q.Original = itemIDs(q.Items)
+			q.shuffleItems()
+		}
+		return nil
+	})
+}
+
+// 追加到队列末尾；随机播放时按种子插入到当前歌曲之后的随机位置
+func AppendToQueue(userID int64, deviceID string, musicIDs []int64) (*QueueState, error) {
+	return updateQueue(userID, deviceID, func(q *PlayQueue) error {
+		if len(musicIDs) == 0 {
+			return nil
+		}
+		items := q.newItems(musicIDs)
+		if !q.Shuffle {
+			q.Items = append(q.Items, items...)
+		} else {
+			rng := rand.New(rand.NewSource(q.ShuffleSeed + items[0].ID))
+			for _, it := range items {
+				pos := q.Current + 1 + rng.Intn(len(q.Items)-q.Current)
+				q.Items = append(q.Items[:pos], append([]QueueItem{it}, q.Items[pos:]...)...)
+			}
+			q.Original = append(q.Original, itemIDs(items)...)
+		}
+		if q.Current < 0 && len(q.Items) > 0 {
+			q.Current = 0
+		}
+		return nil
+	})
+}
+
+// 插入到当前歌曲之后，作为下一首播放
+func InsertNextInQueue(userID int64, deviceID string, musicIDs []int64) (*QueueState, error) {
+	return updateQueue(userID, deviceID, func(q *PlayQueue) error {
+		items := q.newItems(musicIDs)
+		pos := q.Current + 1
+		q.Items = append(q.Items[:pos], append(items, q.Items[pos:]...)...)
+		if q.Shuffle {
+			// 在原顺序中同样放在当前歌曲之后，关闭随机播放后仍然紧跟当前歌曲
+			at := 0
+			for i, id := range q.Original {
+				if id == q.currentItemID() {
+					at = i + 1
+				}
+			}
+			q.Original = append(q.Original[:at], append(itemIDs(items), q.Original[at:]...)...)
+		}
+		if q.Current < 0 {
+			q.Current = 0
+		}
+		return nil
+	})
+}
+
+// 从队列中移除指定的条目
+func RemoveFromQueue(userID int64, deviceID string, itemIDs []int64) (*QueueState, error) {
+	return updateQueue(userID, deviceID, func(q *PlayQueue) error {
+		q.removeItems(itemIDs)
+		return nil
+	})
+}
+
+// 把条目移动到播放顺序中的 to 位置，当前歌曲不变
+func MoveInQueue(userID int64, deviceID string, itemID int64, to int) (*QueueState, error) {
+	return updateQueue(userID, deviceID, func(q *PlayQueue) error {
+		return q.moveItem(itemID, to)
+	})
+}
+
+// 清空队列，保留播放模式
+func ClearQueue(userID int64, deviceID string) (*QueueState, error) {
+	return updateQueue(userID, deviceID, func(q *PlayQueue) error {
+		q.Items = []QueueItem{}
+		q.Original = nil
+		q.Current = -1
+		q.PositionMs = 0
+		return nil
+	})
+}
+
+// 设置随机播放和循环模式；开启随机播放时未指定种子则随机生成
+func SetQueueMode(userID int64, deviceID string, shuffle *bool, seed *int64, repeat *string) (*QueueState, error) {
+	return updateQueue(userID, deviceID, func(q *PlayQueue) error {
+		if repeat != nil {
+			switch *repeat {
+			case RepeatOff, RepeatAll, RepeatOne:
+				q.Repeat = *repeat
+			default:
+				return fmt.Errorf("%w: unknown repeat mode %q", ErrInvalidQueue, *repeat)
+			}
+		}
+		if shuffle == nil {
+			if seed != nil && q.Shuffle {
+				// 换一个种子重新打乱
+				q.ShuffleSeed = *seed
+				q.unshuffleItems()
+				q.Original = itemIDs(q.Items)
+				q.shuffleItems()
+			}
+			return nil
+		}
+
+		switch {
+		case *shuffle:
+			if q.Shuffle {
+				q.unshuffleItems()
+			}
+			q.ShuffleSeed = time.Now().UnixNano()
+			if seed != nil {
+				q.ShuffleSeed = *seed
+			}
+			q.Original = itemIDs(q.Items)
+			q.shuffleItems()
+		case q.Shuffle:
+			q.unshuffleItems()
+		}
+		q.Shuffle = *shuffle
+		return nil
+	})
+}
+
+// 下一首；auto 为 true 表示当前歌曲自然播放结束，此时单曲循环会重播当前歌曲
+// 关闭循环时播放完最后一首后 currentIndex 为 -1
+func NextInQueue(userID int64, deviceID string, auto bool) (*QueueState, error) {
+	return updateQueue(userID, deviceID, func(q *PlayQueue) error {
+		q.next(auto)
+		return nil
+	})
+}
+
+// 上一首；当前歌曲已播放超过 3 秒时回到开头
+func PreviousInQueue(userID int64, deviceID string) (*QueueState, error) {
+	return updateQueue(userID, deviceID, func(q *PlayQueue) error {
+		q.previous()
+		return nil
+	})
+}
+
+// 跳到指定条目
+func JumpInQueue(userID int64, deviceID string, itemID int64) (*QueueState, error) {
+	return updateQueue(userID, deviceID, func(q *PlayQueue) error {
+		i := q.indexOf(itemID)
+		if i < 0 {
+			return ErrQueueItemNotFound
+		}
+		q.Current = i
+		q.PositionMs = 0
+		return nil
+	})
+}
+
+// 上报当前歌曲的播放进度；itemID 不为 0 时同时切换到该条目
+func SetQueuePosition(userID int64, deviceID string, itemID, positionMs int64) (*QueueState, error) {
+	return updateQueue(userID, deviceID, func(q *PlayQueue) error {
+		if itemID != 0 {
+			i := q.indexOf(itemID)
+			if i < 0 {
+				return ErrQueueItemNotFound
+			}
+			q.Current = i
+		}
+		q.PositionMs = max(0, positionMs)
+		return nil
+	})
+}
+
+// 把另一台设备的队列（包括当前歌曲和进度）复制到当前设备
+func CopyPlayQueue(userID int64, fromDevice, toDevice string) (*QueueState, error) {
+	unlock := lockQueue(userID, fromDevice)
+	src, err := loadQueue(DB, userID, fromDevice)
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	if src.ID == 0 {
+		return nil, fmt.Errorf("%w: no queue on device %q", ErrInvalidQueue, fromDevice)
+	}
+	return updateQueue(userID, toDevice, func(q *PlayQueue) error {
+		id, deviceID := q.ID, q.DeviceID
+		*q = *src
+		q.ID, q.DeviceID = id, deviceID
+		return nil
+	})
+}
+
+func itemIDs(items []QueueItem) []int64 {
+	ids := make([]int64, len(items))
+	for i, it := range items {
+		ids[i] = it.ID
+	}
+	return ids
+}
+
+// 解析队列来源，返回歌曲 ID；不存在的歌曲会被忽略
+func ResolveQueueSource(userID int64, src QueueSource) ([]int64, error) {
+	var ids []int64
+	switch src.Type {
+	case QueueSourceTracks:
+		var existing []int64
+		if len(src.MusicIDs) > 0 {
+			if err := DB.Model(&Music{}).Where("id IN ?", src.MusicIDs).Pluck("id", &existing).Error; err != nil {
+				return nil, err
+			}
+		}
+		found := make(map[int64]bool, len(existing))
+		for _, id := range existing {
+			found[id] = true
+		}
+		for _, id := range src.MusicIDs {
+			if found[id] {
+				ids = append(ids, id)
+			}
+		}
+	case QueueSourcePlaylist:
+		// 没有查看权限时与歌单不存在一样处理，不透露歌单是否存在
+		if err := RequirePlaylistRole(src.PlaylistID, userID, PlaylistRoleViewer); err != nil {
+			if errors.Is(err, ErrPlaylistNotFound) || errors.Is(err, ErrPlaylistForbidden) {
+				return nil, fmt.Errorf("%w: playlist not found", ErrInvalidQueue)
+			}
+			return nil, err
+		}
+		p, err := GetPlaylistByID(src.PlaylistID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: playlist not found", ErrInvalidQueue)
+		}
+		for _, it := range p.Items {
+			ids = append(ids, it.MusicID)
+		}
+	case QueueSourceSearch:
+		songs, err := SearchMusicByText(src.Query)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range songs {
+			ids = append(ids, m.Id)
+		}
+	case QueueSourceSimilar:
+		if _, err := GetMusicByID(src.MusicID); err != nil {
+			return nil, fmt.Errorf("%w: music not found", ErrInvalidQueue)
+		}
+		list, err := GetSimilarMusic(src.MusicID, similarTopK)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, src.MusicID)
+		for _, s := range list {
+			ids = append(ids, s.SimilarID)
+		}
+	case QueueSourceRecommend:
+		result, err := RecommendNow(userID, src.Activity, src.Weather, recommendNowMax)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range result.Picks {
+			ids = append(ids, r.Id)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown queue source %q", ErrInvalidQueue, src.Type)
+	}
+	return ids, nil
+}
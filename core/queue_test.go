@@ -0,0 +1,227 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// n 首歌的队列，条目 ID 为 1..n，歌曲 ID 为条目 ID 加 100
+func testQueue(n, current int) *PlayQueue {
+	q := &PlayQueue{Items: []QueueItem{}, Repeat: RepeatOff, Current: current}
+	ids := make([]int64, n)
+	for i := range ids {
+		ids[i] = int64(i + 101)
+	}
+	q.Items = q.newItems(ids)
+	return q
+}
+
+func TestQueueShuffle(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		current int
+	}{
+		{"empty", 0, -1},
+		{"not playing", 10, -1},
+		{"playing first", 10, 0},
+		{"playing middle", 10, 6},
+		{"playing last", 10, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := testQueue(tt.size, tt.current)
+			q.ShuffleSeed = 42
+			before := itemIDs(q.Items)
+			current := q.currentItemID()
+
+			q.Original = itemIDs(q.Items)
+			q.shuffleItems()
+			if len(q.Items) != tt.size {
+				t.Fatalf("shuffled queue has %d items, want %d", len(q.Items), tt.size)
+			}
+			if current != 0 && (q.Current != 0 || q.currentItemID() != current) {
+				t.Errorf("current = %d (item %d), want item %d first", q.Current, q.currentItemID(), current)
+			}
+			if current == 0 && q.Current != tt.current {
+				t.Errorf("current = %d, want %d", q.Current, tt.current)
+			}
+
+			// 同样的种子得到同样的顺序
+			again := testQueue(tt.size, tt.current)
+			again.ShuffleSeed = 42
+			again.shuffleItems()
+			if !reflect.DeepEqual(itemIDs(again.Items), itemIDs(q.Items)) {
+				t.Errorf("same seed gave %v and %v", itemIDs(again.Items), itemIDs(q.Items))
+			}
+
+			q.unshuffleItems()
+			if !reflect.DeepEqual(itemIDs(q.Items), before) {
+				t.Errorf("unshuffled order = %v, want %v", itemIDs(q.Items), before)
+			}
+			if q.currentItemID() != current || q.Original != nil {
+				t.Errorf("after unshuffle current item = %d, original = %v; want %d, nil", q.currentItemID(), q.Original, current)
+			}
+		})
+	}
+}
+
+func TestQueueUnshuffleDropsRemovedItems(t *testing.T) {
+	q := testQueue(6, 2)
+	q.ShuffleSeed = 7
+	q.Original = itemIDs(q.Items)
+	q.shuffleItems()
+	q.removeItems([]int64{1, 5})
+	q.unshuffleItems()
+	if want := []int64{2, 3, 4, 6}; !reflect.DeepEqual(itemIDs(q.Items), want) {
+		t.Errorf("order = %v, want %v", itemIDs(q.Items), want)
+	}
+	if q.currentItemID() != 3 {
+		t.Errorf("current item = %d, want 3", q.currentItemID())
+	}
+}
+
+func TestQueueRemoveItems(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     int
+		remove      []int64
+		wantItems   []int64
+		wantCurrent int
+		wantReset   bool // 当前歌曲被移除，播放进度清零
+	}{
+		{"after current", 1, []int64{4, 5}, []int64{1, 2, 3}, 1, false},
+		{"before current", 3, []int64{1, 2}, []int64{3, 4, 5}, 1, false},
+		{"current", 2, []int64{3}, []int64{1, 2, 4, 5}, 2, true},
+		{"current and next", 2, []int64{3, 4}, []int64{1, 2, 5}, 2, true},
+		{"current is last", 4, []int64{5}, []int64{1, 2, 3, 4}, -1, true},
+		{"everything", 0, []int64{1, 2, 3, 4, 5}, []int64{}, -1, true},
+		{"not playing", -1, []int64{2}, []int64{1, 3, 4, 5}, -1, false},
+		{"unknown item", 1, []int64{99}, []int64{1, 2, 3, 4, 5}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := testQueue(5, tt.current)
+			q.PositionMs = 1234
+			q.Original = []int64{5, 4, 3, 2, 1}
+			q.removeItems(tt.remove)
+			if !reflect.DeepEqual(itemIDs(q.Items), tt.wantItems) {
+				t.Errorf("items = %v, want %v", itemIDs(q.Items), tt.wantItems)
+			}
+			if q.Current != tt.wantCurrent {
+				t.Errorf("current = %d, want %d", q.Current, tt.wantCurrent)
+			}
+			if (q.PositionMs == 0) != tt.wantReset {
+				t.Errorf("positionMs = %d, reset want %v", q.PositionMs, tt.wantReset)
+			}
+			for _, id := range q.Original {
+				for _, removed := range tt.remove {
+					if id == removed {
+						t.Errorf("original still contains removed item %d", id)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestQueueMoveItem(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     int
+		item        int64
+		to          int
+		wantItems   []int64
+		wantCurrent int
+		wantErr     error
+	}{
+		{"forward", 0, 2, 3, []int64{1, 3, 4, 2, 5}, 0, nil},
+		{"backward", 0, 5, 1, []int64{1, 5, 2, 3, 4}, 0, nil},
+		{"current moves", 1, 2, 4, []int64{1, 3, 4, 5, 2}, 4, nil},
+		{"across current", 2, 1, 4, []int64{2, 3, 4, 5, 1}, 1, nil},
+		{"in front of current", 2, 5, 0, []int64{5, 1, 2, 3, 4}, 3, nil},
+		{"same place", 2, 3, 2, []int64{1, 2, 3, 4, 5}, 2, nil},
+		{"unknown item", 0, 99, 1, []int64{1, 2, 3, 4, 5}, 0, ErrQueueItemNotFound},
+		{"out of range", 0, 2, 5, []int64{1, 2, 3, 4, 5}, 0, ErrInvalidQueue},
+		{"negative", 0, 2, -1, []int64{1, 2, 3, 4, 5}, 0, ErrInvalidQueue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := testQueue(5, tt.current)
+			err := q.moveItem(tt.item, tt.to)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(itemIDs(q.Items), tt.wantItems) {
+				t.Errorf("items = %v, want %v", itemIDs(q.Items), tt.wantItems)
+			}
+			if q.Current != tt.wantCurrent {
+				t.Errorf("current = %d, want %d", q.Current, tt.wantCurrent)
+			}
+		})
+	}
+}
+
+func TestQueueNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		repeat  string
+		current int
+		auto    bool
+		want    int
+	}{
+		{"off middle", 3, RepeatOff, 0, false, 1},
+		{"off last stops", 3, RepeatOff, 2, false, -1},
+		{"off stopped starts over", 3, RepeatOff, -1, false, 0},
+		{"all last wraps", 3, RepeatAll, 2, true, 0},
+		{"one auto replays", 3, RepeatOne, 1, true, 1},
+		{"one manual advances", 3, RepeatOne, 1, false, 2},
+		{"one manual last wraps", 3, RepeatOne, 2, false, 0},
+		{"one auto not playing", 3, RepeatOne, -1, true, 0},
+		{"empty", 0, RepeatAll, -1, false, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := testQueue(tt.size, tt.current)
+			q.Repeat = tt.repeat
+			q.PositionMs = 5000
+			q.next(tt.auto)
+			if q.Current != tt.want {
+				t.Errorf("current = %d, want %d", q.Current, tt.want)
+			}
+			if q.PositionMs != 0 {
+				t.Errorf("positionMs = %d, want 0", q.PositionMs)
+			}
+		})
+	}
+}
+
+func TestQueuePrevious(t *testing.T) {
+	tests := []struct {
+		name       string
+		repeat     string
+		current    int
+		positionMs int64
+		want       int
+	}{
+		{"middle", RepeatOff, 2, 0, 1},
+		{"restart after 3s", RepeatOff, 2, 5000, 2},
+		{"first stays", RepeatOff, 0, 0, 0},
+		{"first wraps with repeat all", RepeatAll, 0, 0, 2},
+		{"first wraps with repeat one", RepeatOne, 0, 1000, 2},
+		{"stopped goes to last", RepeatOff, -1, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := testQueue(3, tt.current)
+			q.Repeat = tt.repeat
+			q.PositionMs = tt.positionMs
+			q.previous()
+			if q.Current != tt.want || q.PositionMs != 0 {
+				t.Errorf("current = %d, positionMs = %d; want %d, 0", q.Current, q.PositionMs, tt.want)
+			}
+		})
+	}
+}
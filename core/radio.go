@@ -0,0 +1,437 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 电台的种子类型
+const (
+	RadioSeedTrack    = "track"
+	RadioSeedSinger   = "singer"
+	RadioSeedLabels   = "labels"
+	RadioSeedPlaylist = "playlist"
+)
+
+// 电台反馈
+const (
+	RadioFeedbackLike    = "like"
+	RadioFeedbackSkip    = "skip"
+	RadioFeedbackDislike = "dislike"
+)
+
+const (
+	radioDefaultCount  = 10
+	radioMaxCount      = 50
+	radioServedKeep    = 200           // 电台保存的已推送歌曲数
+	radioServedAvoid   = 50            // 最近推送过的歌曲不再重复
+	radioRecentPlayed  = 3 * time.Hour // 最近播放过的歌曲尽量避开
+	radioWeightMin     = -3.0
+	radioWeightMax     = 5.0
+	radioSimilarWeight = 2.0 // 与种子歌曲的相似度在得分中的权重
+)
+
+// 电台会话，保存在数据库中，可在其他设备上继续收听
+type RadioStation struct {
+	ID             int64              `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	UserID         int64              `json:"userId" gorm:"column:user_id;not null;index"`
+	Name           string             `json:"name" gorm:"column:name;type:varchar(255);not null"`
+	SeedType       string             `json:"seedType" gorm:"column:seed_type;type:varchar(16);not null"`
+	SeedMusicID    int64              `json:"seedMusicId,omitempty" gorm:"column:seed_music_id"`
+	SeedSinger     string             `json:"seedSinger,omitempty" gorm:"column:seed_singer;type:varchar(255)"`
+	SeedLabels     []string           `json:"seedLabels,omitempty" gorm:"column:seed_labels;type:text;serializer:json"`
+	SeedPlaylistID int64              `json:"seedPlaylistId,omitempty" gorm:"column:seed_playlist_id"`
+	LabelWeights   map[string]float64 `json:"labelWeights" gorm:"column:label_weights;type:text;serializer:json"`
+	SingerWeights  map[string]float64 `json:"singerWeights" gorm:"column:singer_weights;type:text;serializer:json"`
+	SeedTracks     []int64            `json:"seedTracks" gorm:"column:seed_tracks;type:text;serializer:json"` // 种子歌曲及电台中喜欢的歌曲，用于查找相似歌曲
+	Served         []int64            `json:"served" gorm:"column:served;type:text;serializer:json"`          // 已推送的歌曲，最新的在最后
+	Skipped        []int64            `json:"skipped" gorm:"column:skipped;type:text;serializer:json"`        // 电台中跳过的歌曲，不再推送
+	CreatedAt      time.Time          `json:"createdAt" gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt      time.Time          `json:"updatedAt" gorm:"column:updated_at;autoUpdateTime"`
+}
+
+func (RadioStation) TableName() string {
+	return "radio_stations"
+}
+
+// 创建电台的参数
+type RadioSeed struct {
+	Type       string   `json:"type"`
+	MusicID    int64    `json:"musicId"`
+	Singer     string   `json:"singer"`
+	Labels     []string `json:"labels"`
+	PlaylistID int64    `json:"playlistId"`
+}
+
+var ErrRadioNotFound = errors.New("radio station not found")
+
+// 同一电台的取歌和反馈串行执行，避免多个设备同时操作时互相覆盖
+var radioLocks sync.Map
+
+func lockRadio(id int64) func() {
+	lock, _ := radioLocks.LoadOrStore(id, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+// 创建电台：根据种子初始化标签和歌手偏好
+func StartRadio(userID int64, seed RadioSeed) (*RadioStation, error) {
+	st := &RadioStation{
+		UserID:        userID,
+		SeedType:      seed.Type,
+		LabelWeights:  map[string]float64{},
+		SingerWeights: map[string]float64{},
+		SeedTracks:    []int64{},
+		Served:        []int64{},
+		Skipped:       []int64{},
+	}
+
+	switch seed.Type {
+	case RadioSeedTrack:
+		m, err := GetMusicByID(seed.MusicID)
+		if err != nil {
+			return nil, errors.New("music not found")
+		}
+		st.Name = m.Title + " 电台"
+		st.SeedMusicID = m.Id
+		st.SeedTracks = []int64{m.Id}
+		st.SingerWeights[m.Singer] = 1
+		for _, l := range m.Labels {
+			st.LabelWeights[l] = 1
+		}
+
+	case RadioSeedSinger:
+		var songs []Music
+		if err := DB.Where("singer = ?", seed.Singer).Find(&songs).Error; err != nil {
+			return nil, err
+		}
+		if len(songs) == 0 {
+			return nil, errors.New("singer not found")
+		}
+		st.Name = seed.Singer + " 电台"
+		st.SeedSinger = seed.Singer
+		st.SingerWeights[seed.Singer] = 2
+		addLabelDistribution(st.LabelWeights, songs)
+
+	case RadioSeedLabels:
+		if len(seed.Labels) == 0 {
+			return nil, errors.New("labels required")
+		}
+		st.Name = "标签电台"
+		st.SeedLabels = seed.Labels
+		for _, l := range seed.Labels {
+			st.LabelWeights[l] = 2
+		}
+
+	case RadioSeedPlaylist:
+		// 没有查看权限时与歌单不存在一样处理
+		if err := RequirePlaylistRole(seed.PlaylistID, userID, PlaylistRoleViewer); err != nil {
+			if errors.Is(err, ErrPlaylistNotFound) || errors.Is(err, ErrPlaylistForbidden) {
+				return nil, errors.New("playlist not found")
+			}
+			return nil, err
+		}
+		playlist, err := GetPlaylistByID(seed.PlaylistID)
+		if err != nil {
+			return nil, errors.New("playlist not found")
+		}
+		if len(playlist.Items) == 0 {
+			return nil, errors.New("playlist is empty")
+		}
+		st.Name = playlist.Name + " 电台"
+		st.SeedPlaylistID = playlist.ID
+		songs := make([]Music, len(playlist.Items))
+		for i, it := range playlist.Items {
+			songs[i] = it.Music
+			st.SeedTracks = append(st.SeedTracks, it.MusicID)
+		}
+		addLabelDistribution(st.LabelWeights, songs)
+
+	default:
+		return nil, errors.New("unknown seed type: " + seed.Type)
+	}
+
+	if err := DB.Create(st).Error; err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// 按标签在歌曲中出现的比例设置偏好，出现在所有歌曲中的标签为 2
+func addLabelDistribution(weights map[string]float64, songs []Music) {
+	for _, m := range songs {
+		for _, l := range m.Labels {
+			weights[l] += 2 / float64(len(songs))
+		}
+	}
+}
+
+func GetRadioStation(userID, stationID int64) (*RadioStation, error) {
+	var st RadioStation
+	if err := DB.Where("user_id = ?", userID).First(&st, stationID).Error; err != nil {
+		return nil, ErrRadioNotFound
+	}
+	return &st, nil
+}
+
+// 用户的电台，最近使用的在前
+func GetRadioStations(userID int64) ([]RadioStation, error) {
+	stations := []RadioStation{}
+	err := DB.Where("user_id = ?", userID).Order("updated_at DESC").Find(&stations).Error
+	return stations, err
+}
+
+func DeleteRadioStation(userID, stationID int64) error {
+	result := DB.Where("user_id = ?", userID).Delete(&RadioStation{}, stationID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRadioNotFound
+	}
+	return nil
+}
+
+// 取电台接下来的 count 首歌，并记为已推送
+func NextRadioTracks(userID, stationID int64, count int) ([]Recommendation, error) {
+	if count <= 0 {
+		count = radioDefaultCount
+	}
+	if count > radioMaxCount {
+		count = radioMaxCount
+	}
+	defer lockRadio(stationID)()
+
+	st, err := GetRadioStation(userID, stationID)
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := pickRadioTracks(st, count)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tracks {
+		st.Served = append(st.Served, t.Id)
+	}
+	if len(st.Served) > radioServedKeep {
+		st.Served = st.Served[len(st.Served)-radioServedKeep:]
+	}
+	if err := DB.Save(st).Error; err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
+
+func pickRadioTracks(st *RadioStation, count int) ([]Recommendation, error) {
+	songs, err := GetAllSongs()
+	if err != nil {
+		return nil, err
+	}
+
+	// 不再推送的歌曲：不喜欢的、电台中跳过的
+	banned := map[int64]bool{}
+	feedback, err := GetMusicFeedback(st.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for id, v := range feedback {
+		if v == FeedbackDislike {
+			banned[id] = true
+		}
+	}
+	for _, id := range st.Skipped {
+		banned[id] = true
+	}
+
+	// 尽量避开的歌曲：电台最近推送过的、用户最近播放过的
+	avoid := map[int64]bool{}
+	for _, id := range st.Served[max(0, len(st.Served)-radioServedAvoid):] {
+		avoid[id] = true
+	}
+	var recent []int64
+	err = DB.Model(&PlayHistory{}).Where("user_id = ? AND played_at > ?", st.UserID, time.Now().Add(-radioRecentPlayed)).
+		Distinct().Pluck("music_id", &recent).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range recent {
+		avoid[id] = true
+	}
+
+	// 与种子歌曲（及电台中喜欢的歌曲）的相似度
+	similar := map[int64]MusicSimilarity{}
+	if len(st.SeedTracks) > 0 {
+		var rows []MusicSimilarity
+		if err := DB.Where("music_id IN ?", st.SeedTracks).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			if r.Score > similar[r.SimilarID].Score {
+				similar[r.SimilarID] = r
+			}
+		}
+	}
+	byID := make(map[int64]Music, len(songs))
+	for _, m := range songs {
+		byID[m.Id] = m
+	}
+
+	type candidate struct {
+		Recommendation
+		avoid bool
+	}
+	var candidates []candidate
+	for _, m := range songs {
+		if banned[m.Id] {
+			continue
+		}
+		c := candidate{Recommendation: newRecommendation(m), avoid: avoid[m.Id]}
+		if w := st.SingerWeights[m.Singer]; w > 0 {
+			c.add(RecommendReason{Type: ReasonSinger, Message: "电台偏好的歌手 " + m.Singer, Score: w})
+		} else if w < 0 {
+			c.add(RecommendReason{Type: ReasonSinger, Message: "电台中常跳过 " + m.Singer + " 的歌", Score: w})
+		}
+		if len(m.Labels) > 0 {
+			var lw float64
+			var liked, disliked []string
+			for _, l := range m.Labels {
+				lw += st.LabelWeights[l]
+				switch {
+				case st.LabelWeights[l] > 0:
+					liked = append(liked, l)
+				case st.LabelWeights[l] < 0:
+					disliked = append(disliked, l)
+				}
+			}
+			lw /= math.Sqrt(float64(len(m.Labels)))
+			switch {
+			case lw > 0:
+				c.add(RecommendReason{Type: ReasonLabel, Message: "符合电台偏好的 " + joinLabels(liked), Labels: liked, Score: lw})
+			case lw < 0:
+				c.add(RecommendReason{Type: ReasonLabel, Message: "不太符合电台偏好（" + joinLabels(disliked) + "）", Labels: disliked, Score: lw})
+			}
+		}
+		if s, ok := similar[m.Id]; ok {
+			c.add(RecommendReason{
+				Type:    ReasonSimilar,
+				Message: "与" + quoteTitle(byID[s.MusicID]) + "相似",
+				MusicID: s.MusicID,
+				Score:   radioSimilarWeight * s.Score,
+			})
+		}
+		if c.Score > 0 {
+			candidates = append(candidates, c)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Id < candidates[j].Id
+	})
+
+	var tracks []Recommendation
+	// 从种子歌曲开始播放
+	if len(st.Served) == 0 && st.SeedMusicID != 0 && !banned[st.SeedMusicID] {
+		for i, c := range candidates {
+			if c.Id == st.SeedMusicID {
+				c.Reasons = append([]RecommendReason{{Type: ReasonSeed, Message: "电台的种子歌曲", MusicID: c.Id}}, c.Reasons...)
+				tracks = append(tracks, c.Recommendation)
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				break
+			}
+		}
+	}
+
+	var preferred, avoided []candidate
+	for _, c := range candidates {
+		if c.avoid {
+			avoided = append(avoided, c)
+		} else {
+			preferred = append(preferred, c)
+		}
+	}
+
+	// 在得分最高的候选中按得分加权随机抽取，同样的种子每次听到的顺序不同
+	pool := preferred[:min(len(preferred), 3*count)]
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for len(tracks) < count && len(pool) > 0 {
+		var total float64
+		for _, c := range pool {
+			total += c.Score
+		}
+		r := rng.Float64() * total
+		i := 0
+		for ; i < len(pool)-1; i++ {
+			if r -= pool[i].Score; r <= 0 {
+				break
+			}
+		}
+		tracks = append(tracks, pool[i].Recommendation)
+		pool = append(pool[:i], pool[i+1:]...)
+	}
+
+	// 候选不够时才使用最近听过的歌曲
+	for _, c := range avoided {
+		if len(tracks) >= count {
+			break
+		}
+		tracks = append(tracks, c.Recommendation)
+	}
+	return tracks, nil
+}
+
+// 电台中的反馈：喜欢会提高该歌曲标签和歌手的权重并以它作为新的种子，跳过则降低权重且不再推送
+// 不喜欢在跳过的基础上记录为用户的歌曲反馈
+func RadioFeedback(userID, stationID, musicID int64, action string) (*RadioStation, error) {
+	defer lockRadio(stationID)()
+
+	st, err := GetRadioStation(userID, stationID)
+	if err != nil {
+		return nil, err
+	}
+	m, err := GetMusicByID(musicID)
+	if err != nil {
+		return nil, errors.New("music not found")
+	}
+
+	var delta, singerDelta float64
+	switch action {
+	case RadioFeedbackLike:
+		delta, singerDelta = 0.5, 0.5
+		if !containsInt64(st.SeedTracks, musicID) {
+			st.SeedTracks = append(st.SeedTracks, musicID)
+		}
+	case RadioFeedbackSkip, RadioFeedbackDislike:
+		delta, singerDelta = -0.3, -0.5
+		if !containsInt64(st.Skipped, musicID) {
+			st.Skipped = append(st.Skipped, musicID)
+		}
+		if action == RadioFeedbackDislike {
+			if err := SetMusicFeedback(userID, musicID, FeedbackDislike); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, errors.New("unknown radio feedback: " + action)
+	}
+
+	for _, l := range m.Labels {
+		st.LabelWeights[l] = clampRadioWeight(st.LabelWeights[l] + delta)
+	}
+	st.SingerWeights[m.Singer] = clampRadioWeight(st.SingerWeights[m.Singer] + singerDelta)
+
+	if err := DB.Save(st).Error; err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func clampRadioWeight(w float64) float64 {
+	return math.Max(radioWeightMin, math.Min(radioWeightMax, w))
+}
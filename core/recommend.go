@@ -0,0 +1,293 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	recommendNowDefault   = 10
+	recommendNowMax       = 50
+	recommendNowPerSinger = 2         // 同一歌手最多推荐的歌曲数
+	recommendNowRecent    = time.Hour // 最近一小时播放过的歌曲不推荐
+	recommendHabitWindow  = 1         // 统计收听习惯时前后各取的小时数
+	recommendHabitDays    = 90        // 统计收听习惯时回看的天数
+	recommendJitter       = 0.3       // 随机扰动，避免每次请求结果完全相同
+	recommendHabitCap     = 5         // 收听习惯得分的上限（播放次数）
+	recommendSceneWeight  = 1.0       // 场景标签的权重
+	recommendTasteWeight  = 0.5       // 用户整体口味的权重
+	recommendHabitWeight  = 0.5       // 该时段收听习惯的权重
+	recommendTimeLayout   = "2006-01-02 15:04"
+)
+
+// 时段及该时段适合的场景标签
+type dayPeriod struct {
+	name    string
+	from    int // 起始小时（含）
+	to      int // 结束小时（不含）
+	weekday map[Label]float64
+	weekend map[Label]float64
+}
+
+var dayPeriods = []dayPeriod{
+	{"凌晨", 0, 5, map[Label]float64{LabelSleepAid: 2, LabelRelax: 1}, map[Label]float64{LabelSleepAid: 1.5, LabelParty: 1}},
+	{"清晨", 5, 9, map[Label]float64{LabelWakeUp: 2, LabelDriving: 1, LabelFitness: 0.5}, map[Label]float64{LabelWakeUp: 1.5, LabelFitness: 1}},
+	{"上午", 9, 12, map[Label]float64{LabelStudying: 2, LabelInstrumental: 1}, map[Label]float64{LabelRelax: 1.5, LabelTravel: 1}},
+	{"中午", 12, 14, map[Label]float64{LabelRelax: 1.5}, map[Label]float64{LabelRelax: 1.5, LabelTravel: 0.5}},
+	{"下午", 14, 17, map[Label]float64{LabelStudying: 1.5, LabelInstrumental: 1}, map[Label]float64{LabelTravel: 1, LabelPop: 0.5}},
+	{"傍晚", 17, 19, map[Label]float64{LabelDriving: 1.5, LabelFitness: 1}, map[Label]float64{LabelFitness: 1, LabelParty: 0.5}},
+	{"晚上", 19, 22, map[Label]float64{LabelRelax: 1.5}, map[Label]float64{LabelParty: 1.5, LabelRelax: 1}},
+	{"深夜", 22, 24, map[Label]float64{LabelSleepAid: 1.5, LabelRelax: 1}, map[Label]float64{LabelSleepAid: 1, LabelRelax: 1}},
+}
+
+// 客户端提供的活动对应的场景标签，也可以直接传标签名
+var activityLabels = map[string]map[Label]float64{
+	"study":   {LabelStudying: 3, LabelInstrumental: 1},
+	"work":    {LabelStudying: 3, LabelInstrumental: 1},
+	"workout": {LabelFitness: 3, LabelRelease: 1},
+	"running": {LabelFitness: 3, LabelElectronic: 1},
+	"drive":   {LabelDriving: 3},
+	"commute": {LabelDriving: 2, LabelWakeUp: 1},
+	"sleep":   {LabelSleepAid: 3, LabelInstrumental: 1},
+	"relax":   {LabelRelax: 3},
+	"party":   {LabelParty: 3, LabelElectronic: 1},
+	"travel":  {LabelTravel: 3},
+	"wakeup":  {LabelWakeUp: 3},
+	"sad":     {LabelFeelDown: 2, LabelRelease: 1},
+}
+
+// 天气对应的标签
+var weatherLabels = map[string]map[Label]float64{
+	"sunny":  {LabelPop: 1, LabelTravel: 0.5},
+	"clear":  {LabelPop: 1, LabelTravel: 0.5},
+	"cloudy": {LabelFolk: 1},
+	"rain":   {LabelBlue: 1, LabelJazz: 1, LabelRelax: 0.5},
+	"storm":  {LabelRock: 1, LabelRelease: 1},
+	"snow":   {LabelClassical: 1, LabelRelax: 1},
+	"hot":    {LabelElectronic: 1, LabelParty: 0.5},
+	"cold":   {LabelFolk: 1, LabelJazz: 0.5},
+}
+
+// 推荐时的上下文
+type RecommendContext struct {
+	Time     string             `json:"time"`
+	Weekday  int                `json:"weekday"` // 0（周日）~6（周六）
+	Period   string             `json:"period"`
+	Activity string             `json:"activity,omitempty"`
+	Weather  string             `json:"weather,omitempty"`
+	Labels   map[string]float64 `json:"labels"` // 场景标签的权重
+}
+
+type RecommendNowResult struct {
+	Context RecommendContext `json:"context"`
+	Picks   []Recommendation `json:"picks"`
+}
+
+// 场景标签的权重及其来源说明
+type sceneWeight struct {
+	weight float64
+	source string
+}
+
+// 根据时间、活动和天气计算场景标签的权重
+func sceneWeights(now time.Time, activity, weather string) (RecommendContext, map[string]sceneWeight) {
+	ctx := RecommendContext{
+		Time:     now.Format(recommendTimeLayout),
+		Weekday:  int(now.Weekday()),
+		Activity: activity,
+		Weather:  weather,
+		Labels:   map[string]float64{},
+	}
+	scene := map[string]sceneWeight{}
+	add := func(weights map[Label]float64, source string) {
+		for l, w := range weights {
+			cur := scene[string(l)]
+			if w > cur.weight {
+				cur.source = source
+			}
+			cur.weight += w
+			scene[string(l)] = cur
+		}
+	}
+
+	weekend := now.Weekday() == time.Saturday || now.Weekday() == time.Sunday
+	for _, p := range dayPeriods {
+		if now.Hour() >= p.from && now.Hour() < p.to {
+			ctx.Period = p.name
+			if weekend {
+				add(p.weekend, "周末"+p.name)
+			} else {
+				add(p.weekday, p.name)
+			}
+			break
+		}
+	}
+
+	if activity != "" {
+		a := strings.ToLower(strings.TrimSpace(activity))
+		if weights, ok := activityLabels[a]; ok {
+			add(weights, "正在"+activity)
+		} else {
+			for _, l := range AllLabels {
+				if strings.EqualFold(string(l), a) {
+					add(map[Label]float64{l: 3}, "正在"+activity)
+				}
+			}
+		}
+	}
+	if weather != "" {
+		if weights, ok := weatherLabels[strings.ToLower(strings.TrimSpace(weather))]; ok {
+			add(weights, weather+"天气")
+		}
+	}
+
+	for l, s := range scene {
+		ctx.Labels[l] = s.weight
+	}
+	return ctx, scene
+}
+
+// “此刻推荐”：结合时段、星期、客户端提供的活动和天气，以及用户的口味和该时段的收听习惯
+func RecommendNow(userID int64, activity, weather string, limit int) (*RecommendNowResult, error) {
+	if limit <= 0 {
+		limit = recommendNowDefault
+	}
+	if limit > recommendNowMax {
+		limit = recommendNowMax
+	}
+	now := time.Now()
+	ctx, scene := sceneWeights(now, activity, weather)
+
+	all, err := GetAllSongs()
+	if err != nil {
+		return nil, err
+	}
+	songs := make(map[int64]Music, len(all))
+	for _, m := range all {
+		songs[m.Id] = m
+	}
+	e, err := newRecommendExplainer(userID, songs, nil, now)
+	if err != nil {
+		return nil, err
+	}
+	taste := e.taste
+	var maxAffinity float64
+	for _, a := range taste.affinity {
+		maxAffinity = max(maxAffinity, a)
+	}
+
+	// 该时段（前后一小时）的收听习惯
+	habit := map[int64]float64{}
+	recent := map[int64]bool{}
+	var plays []PlayHistory
+	err = DB.Select("music_id, played_at").
+		Where("user_id = ? AND played_at > ?", userID, now.AddDate(0, 0, -recommendHabitDays)).
+		Find(&plays).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, ph := range plays {
+		if now.Sub(ph.PlayedAt) < recommendNowRecent {
+			recent[ph.MusicID] = true
+		}
+		diff := (ph.PlayedAt.In(now.Location()).Hour() - now.Hour() + 24) % 24
+		if diff <= recommendHabitWindow || diff >= 24-recommendHabitWindow {
+			habit[ph.MusicID] = min(habit[ph.MusicID]+1, recommendHabitCap)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(now.UnixNano()))
+	var candidates []Recommendation
+	jitter := map[int64]float64{}
+	for _, m := range all {
+		if taste.disliked[m.Id] || recent[m.Id] {
+			continue
+		}
+		r := newRecommendation(m)
+
+		var sceneScore, best float64
+		var sceneLabels []string
+		var sceneSource string
+		for _, l := range m.Labels {
+			if s, ok := scene[l]; ok {
+				sceneScore += s.weight
+				sceneLabels = append(sceneLabels, l)
+				if s.weight > best {
+					best, sceneSource = s.weight, s.source
+				}
+			}
+		}
+		if len(sceneLabels) > 0 {
+			r.add(RecommendReason{
+				Type:    ReasonLabel,
+				Message: fmt.Sprintf("适合%s（%s）", sceneSource, joinLabels(sceneLabels)),
+				Labels:  sceneLabels,
+				Score:   recommendSceneWeight * sceneScore,
+			})
+		}
+		// 不适合该场景的歌曲（delabels 中含有场景标签）扣分，单独作为一条理由
+		var penalty float64
+		var unfitLabels []string
+		for _, l := range m.DeLabels {
+			if s, ok := scene[l]; ok {
+				penalty += s.weight
+				unfitLabels = append(unfitLabels, l)
+			}
+		}
+		if penalty > 0 {
+			r.add(RecommendReason{
+				Type:    ReasonLabel,
+				Message: fmt.Sprintf("不太适合当前场景（%s）", joinLabels(unfitLabels)),
+				Labels:  unfitLabels,
+				Score:   -recommendSceneWeight * penalty,
+			})
+		}
+
+		if label, a := e.favoriteLabel(m); label != "" && maxAffinity > 0 {
+			r.add(RecommendReason{
+				Type:    ReasonTaste,
+				Message: fmt.Sprintf("你常听 %s 类型的歌曲", label),
+				Labels:  []string{label},
+				Score:   recommendTasteWeight * a / maxAffinity,
+			})
+		}
+		if h := habit[m.Id]; h > 0 {
+			r.add(RecommendReason{
+				Type:    ReasonHabit,
+				Message: fmt.Sprintf("你常在%s听这首歌", ctx.Period),
+				Score:   recommendHabitWeight * h / recommendHabitCap,
+			})
+		}
+		if r.Score <= 0 {
+			continue
+		}
+		r.Reason = r.joinReasons()
+		candidates = append(candidates, r)
+		jitter[r.Id] = rng.Float64() * recommendJitter
+	}
+	// 随机扰动只影响排序，返回的得分仍是各条理由得分之和
+	sort.SliceStable(candidates, func(i, j int) bool {
+		si, sj := candidates[i].Score+jitter[candidates[i].Id], candidates[j].Score+jitter[candidates[j].Id]
+		if si != sj {
+			return si > sj
+		}
+		return candidates[i].Id < candidates[j].Id
+	})
+
+	result := &RecommendNowResult{Context: ctx, Picks: []Recommendation{}}
+	perSinger := map[string]int{}
+	for _, r := range candidates {
+		if len(result.Picks) == limit {
+			break
+		}
+		if perSinger[r.Singer] >= recommendNowPerSinger {
+			continue
+		}
+		perSinger[r.Singer]++
+		result.Picks = append(result.Picks, r)
+	}
+	return result, nil
+}
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 存储后端，由 DATABASE_URL 的 scheme 决定，见 openRepository
+const (
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+)
+
+// HistoryRepository.TimePart 支持的时间部分
+const (
+	TimePartHour    = "hour"    // 0~23
+	TimePartWeekday = "weekday" // 0（周日）~6（周六）
+	TimePartMonth   = "month"   // 1~12
+	TimePartDay     = "day"     // 本地日期，格式为 2006-01-02 的字符串
+)
+
+var ErrUnsupportedDatabaseURL = errors.New("unsupported DATABASE_URL scheme")
+
+// Repo 是全局存储后端，InitDB 时根据 DATABASE_URL 创建；DB 即 Repo.DB()
+var Repo Repository
+
+// 歌曲的读写
+type MusicRepository interface {
+	AllMusic() ([]Music, error)
+	MusicByID(id int64) (*Music, error)
+	// 含有任意一个标签的歌曲
+	SearchMusicByLabels(labels []string, limit int) ([]Music, error)
+	// 标题或歌手包含 text 的歌曲，不区分大小写
+	SearchMusicByText(text string) ([]Music, error)
+	// 在事务中覆盖歌曲的标签和“不适合”标签
+	SetMusicLabels(tx *gorm.DB, musicID int64, labels, delabels []string) error
+}
+
+// 歌单的读写
+type PlaylistRepository interface {
+	// 用户创建的和作为成员加入的歌单
+	AllPlaylists(userID int64) ([]Playlist, error)
+	// 歌单及其按顺序排列的歌曲，不计算智能歌单的规则
+	PlaylistByID(id int64) (*Playlist, error)
+	// 在事务中把歌曲加到歌单末尾
+	AppendPlaylistItem(tx *gorm.DB, playlistID, musicID int64) error
+	// 在事务中移除歌单中的歌曲，返回歌曲原本是否在歌单中
+	RemovePlaylistItem(tx *gorm.DB, playlistID, musicID int64) (bool, error)
+}
+
+// 播放历史的读写以及统计查询中与数据库相关的部分
+type HistoryRepository interface {
+	// 记录一次播放，返回是否记录；自动记录（PlaySourceAuto）的播放在同一用户 window 内
+	// 已自动记录过同一首歌时不记录，客户端上报等明确的播放总会记录
+	RecordPlay(h *PlayHistory, window time.Duration) (bool, error)
+	// 用户最近播放过的歌曲 ID，按最后一次播放时间倒序
+	RecentlyPlayedIDs(userID int64, limit int) ([]int64, error)
+	// 取时间列 column 某一部分的 SQL 表达式，part 见 TimePart*
+	TimePart(part, column string) string
+	// 在关联了 music 表的查询上展开歌曲的标签，每个标签一行，标签列为 l.label
+	JoinMusicLabels(q *gorm.DB) *gorm.DB
+}
+
+// 存储后端
+type Repository interface {
+	MusicRepository
+	PlaylistRepository
+	HistoryRepository
+
+	Backend() string
+	DB() *gorm.DB
+	// 建表并创建后端需要的索引
+	Migrate(models ...interface{}) error
+}
+
+// 根据 DATABASE_URL 的 scheme 打开存储后端：
+// postgres:// 或 postgresql://（以及 host=... 形式的连接串）使用 Postgres，sqlite: 或 file: 使用 SQLite
+func openRepository(dsn string) (Repository, error) {
+	switch backend, path := databaseBackend(dsn); backend {
+	case BackendPostgres:
+		return openPostgres(dsn)
+	case BackendSQLite:
+		return openSQLite(path)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedDatabaseURL, dsn)
+	}
+}
+
+// 解析 DATABASE_URL，返回后端类型和去掉 scheme 后的 SQLite 路径
+func databaseBackend(dsn string) (string, string) {
+	lower := strings.ToLower(dsn)
+	switch {
+	case strings.HasPrefix(lower, "postgres://"), strings.HasPrefix(lower, "postgresql://"):
+		return BackendPostgres, ""
+	case strings.HasPrefix(lower, "sqlite://"):
+		return BackendSQLite, dsn[len("sqlite://"):]
+	case strings.HasPrefix(lower, "sqlite:"):
+		return BackendSQLite, dsn[len("sqlite:"):]
+	case strings.HasPrefix(lower, "file:"):
+		return BackendSQLite, dsn
+	case !strings.Contains(dsn, "://") && strings.Contains(dsn, "="):
+		// 兼容 host=... dbname=... 形式的 Postgres 连接串
+		return BackendPostgres, ""
+	}
+	return "", ""
+}
+
+// ==== 各后端通用的实现 ====
+
+type gormRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormRepository) DB() *gorm.DB {
+	return r.db
+}
+
+func (r *gormRepository) AllMusic() ([]Music, error) {
+	var songs []Music
+	err := r.db.Find(&songs).Error
+	return songs, err
+}
+
+func (r *gormRepository) MusicByID(id int64) (*Music, error) {
+	var music Music
+	if err := r.db.First(&music, id).Error; err != nil {
+		return nil, err
+	}
+	return &music, nil
+}
+
+func (r *gormRepository) AllPlaylists(userID int64) ([]Playlist, error) {
+	var playlists []Playlist
+	err := r.db.Where("owner_user_id = ? OR id IN (?)", userID,
+		r.db.Model(&PlaylistMember{}).Select("playlist_id").Where("user_id = ?", userID),
+	).Order("id").Find(&playlists).Error
+	return playlists, err
+}
+
+func (r *gormRepository) PlaylistByID(id int64) (*Playlist, error) {
+	var playlist Playlist
+	// Preload("Items.Music") 加载歌单项中嵌套的歌曲，歌单项按 track_order 排序
+	err := r.db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("track_order ASC")
+	}).Preload("Items.Music").First(&playlist, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+func (r *gormRepository) AppendPlaylistItem(tx *gorm.DB, playlistID, musicID int64) error {
+	var maxOrder int
+	err := tx.Model(&PlaylistItem{}).Where("playlist_id = ?", playlistID).Select("COALESCE(MAX(track_order), 0)").Scan(&maxOrder).Error
+	if err != nil {
+		return err
+	}
+	item := PlaylistItem{PlaylistID: playlistID, MusicID: musicID, TrackOrder: maxOrder + 1}
+	// 歌曲已在歌单中时由唯一索引拒绝
+	return tx.Create(&item).Error
+}
+
+func (r *gormRepository) RemovePlaylistItem(tx *gorm.DB, playlistID, musicID int64) (bool, error) {
+	result := tx.Where("playlist_id = ? AND music_id = ?", playlistID, musicID).Delete(&PlaylistItem{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormRepository) RecordPlay(h *PlayHistory, window time.Duration) (bool, error) {
+	if h.Source == PlaySourceAuto {
+		var count int64
+		err := r.db.Model(&PlayHistory{}).
+			Where("user_id = ? AND music_id = ? AND source = ? AND played_at > ?", h.UserID, h.MusicID, PlaySourceAuto, h.PlayedAt.Add(-window)).
+			Count(&count).Error
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return false, nil
+		}
+	}
+	if err := r.db.Create(h).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *gormRepository) RecentlyPlayedIDs(userID int64, limit int) ([]int64, error) {
+	var musicIDs []int64
+	err := r.db.Model(&PlayHistory{}).
+		Select("music_id").
+		Where("user_id = ?", userID).
+		Group("music_id").
+		Order("MAX(played_at) DESC").
+		Limit(limit).
+		Pluck("music_id", &musicIDs).Error
+	return musicIDs, err
+}
+
+// 查询中 MAX(played_at) 这类表达式得到的时间：Postgres 返回 time.Time，SQLite 没有时间类型，返回文本
+type dbTime struct {
+	time.Time
+}
+
+// SQLite 驱动写入时间时使用的格式
+var dbTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+func (t *dbTime) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("cannot scan %T into time", value)
+	}
+	for _, layout := range dbTimeLayouts {
+		if parsed, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot parse time %q", s)
+}
+
+func (t dbTime) Value() (driver.Value, error) {
+	return t.Time, nil
+}
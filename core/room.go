@@ -0,0 +1,663 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 房间中的操作
+const (
+	RoomActionPlay    = "play"    // 主持人：播放，指定 musicId 时切换歌曲，否则从暂停处继续
+	RoomActionPause   = "pause"   // 主持人：暂停
+	RoomActionSeek    = "seek"    // 主持人：跳转到 positionMs
+	RoomActionNext    = "next"    // 主持人：播放票数最多的推荐歌曲
+	RoomActionHandoff = "handoff" // 主持人：把主持人转交给 userId
+	RoomActionSuggest = "suggest" // 所有人：推荐歌曲，已有人推荐过时视为投票
+	RoomActionVote    = "vote"    // 所有人：为推荐投票（vote 为 1）或取消投票（vote 为 0）
+)
+
+// 房间 WebSocket 消息类型
+const (
+	roomMsgAction   = "action"   // 客户端 -> 服务端：执行操作
+	roomMsgTime     = "time"     // 双向：时钟同步
+	roomMsgRoom     = "room"     // 服务端 -> 客户端：房间的完整状态（成员、主持人或推荐变化时）
+	roomMsgPlayback = "playback" // 服务端 -> 客户端：播放状态（变化时及每隔几秒）
+	roomMsgAck      = "ack"
+	roomMsgError    = "error"
+	roomMsgClosed   = "closed" // 服务端 -> 客户端：已离开房间或房间已关闭
+)
+
+const (
+	roomCodeLength     = 6
+	roomCodeAlphabet   = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // 去掉容易混淆的 0/O、1/I
+	roomSyncPeriod     = 5 * time.Second                    // 定期广播播放进度，纠正客户端的漂移
+	roomHostGrace      = 30 * time.Second                   // 主持人断线超过该时长后自动转交
+	roomIdleTimeout    = 30 * time.Minute                   // 没有任何连接超过该时长后关闭房间
+	roomMaxSuggestions = 200
+)
+
+var (
+	ErrRoomNotFound      = errors.New("room not found")
+	ErrNotRoomHost       = errors.New("only the host can do this")
+	ErrNotRoomMember     = errors.New("not a member of the room")
+	ErrInvalidRoomAction = errors.New("invalid room action")
+)
+
+type RoomMember struct {
+	UserID   int64     `json:"userId"`
+	Name     string    `json:"name"`
+	JoinedAt time.Time `json:"joinedAt"`
+	Online   bool      `json:"online"`
+}
+
+// 房间的播放状态：在服务端时间 UpdatedAt 时播放到 PositionMs
+// 正在播放时客户端的当前进度 = PositionMs + (估算的服务端当前时间 - UpdatedAt)
+type RoomPlayback struct {
+	Music      *Music `json:"music"`
+	Playing    bool   `json:"playing"`
+	PositionMs int64  `json:"positionMs"`
+	UpdatedAt  int64  `json:"updatedAt"` // 服务端时间，Unix 毫秒
+}
+
+type RoomSuggestion struct {
+	ID          int64     `json:"id"`
+	Music       Music     `json:"music"`
+	SuggestedBy int64     `json:"suggestedBy"`
+	Votes       int       `json:"votes"`
+	Voters      []int64   `json:"voters"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// 房间的完整状态
+type RoomView struct {
+	Code        string           `json:"code"`
+	Name        string           `json:"name"`
+	HostID      int64            `json:"hostId"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	Members     []RoomMember     `json:"members"`
+	Playback    RoomPlayback     `json:"playback"`
+	Suggestions []RoomSuggestion `json:"suggestions"` // 按票数倒序，票数相同时先推荐的在前
+	ServerTime  int64            `json:"serverTime"`
+}
+
+// 房间中的操作，按 type 填写对应字段
+type RoomAction struct {
+	Type         string `json:"type"`
+	MusicID      int64  `json:"musicId"`
+	PositionMs   int64  `json:"positionMs"`
+	SuggestionID int64  `json:"suggestionId"`
+	Vote         int    `json:"vote"`
+	UserID       int64  `json:"userId"`
+}
+
+type roomMessage struct {
+	Type       string        `json:"type"`
+	ID         string        `json:"id,omitempty"` // 客户端自定义的消息 ID，回复时原样带回
+	Action     *RoomAction   `json:"action,omitempty"`
+	Room       *RoomView     `json:"room,omitempty"`
+	Playback   *RoomPlayback `json:"playback,omitempty"`
+	ClientTime int64         `json:"clientTime,omitempty"`
+	ServerTime int64         `json:"serverTime,omitempty"`
+	Message    string        `json:"message,omitempty"`
+}
+
+type roomConn struct {
+	*wsConn
+	userID int64
+}
+
+// 一起听的房间，只保存在内存中，服务重启后失效
+type ListeningRoom struct {
+	mu          sync.Mutex
+	code        string
+	name        string
+	hostID      int64
+	createdAt   time.Time
+	members     []*RoomMember // 按加入顺序
+	playback    RoomPlayback
+	suggestions []*RoomSuggestion
+	nextID      int64
+	conns       map[*roomConn]bool
+	hostSeen    time.Time // 主持人最后一次在线的时间
+	lastActive  time.Time // 最后一次有连接的时间
+	closed      bool
+}
+
+var rooms = struct {
+	sync.Mutex
+	m map[string]*ListeningRoom
+}{m: map[string]*ListeningRoom{}}
+
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
+
+func newRoomCode() string {
+	b := make([]byte, roomCodeLength)
+	for i := range b {
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(roomCodeAlphabet))))
+		b[i] = roomCodeAlphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+func defaultMemberName(userID int64) string {
+	return fmt.Sprintf("用户 %d", userID)
+}
+
+func getRoom(code string) (*ListeningRoom, error) {
+	rooms.Lock()
+	defer rooms.Unlock()
+	room, ok := rooms.m[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	return room, nil
+}
+
+// 创建房间，创建者为主持人
+func CreateRoom(userID int64, name, memberName string) *RoomView {
+	now := time.Now()
+	room := &ListeningRoom{
+		name:       name,
+		hostID:     userID,
+		createdAt:  now,
+		conns:      map[*roomConn]bool{},
+		hostSeen:   now,
+		lastActive: now,
+		playback:   RoomPlayback{UpdatedAt: now.UnixMilli()},
+	}
+	if memberName == "" {
+		memberName = defaultMemberName(userID)
+	}
+	if room.name == "" {
+		room.name = memberName + " 的房间"
+	}
+	room.members = []*RoomMember{{UserID: userID, Name: memberName, JoinedAt: now}}
+
+	rooms.Lock()
+	for {
+		room.code = newRoomCode()
+		if _, exists := rooms.m[room.code]; !exists {
+			break
+		}
+	}
+	rooms.m[room.code] = room
+	rooms.Unlock()
+
+	go room.syncLoop()
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return room.view()
+}
+
+// 加入房间，已经是成员时更新名字
+func JoinRoom(code string, userID int64, memberName string) (*RoomView, error) {
+	room, err := getRoom(code)
+	if err != nil {
+		return nil, err
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.join(userID, memberName)
+	room.broadcastRoom()
+	return room.view(), nil
+}
+
+func (r *ListeningRoom) join(userID int64, memberName string) {
+	if m := r.member(userID); m != nil {
+		if memberName != "" {
+			m.Name = memberName
+		}
+		return
+	}
+	if memberName == "" {
+		memberName = defaultMemberName(userID)
+	}
+	r.members = append(r.members, &RoomMember{UserID: userID, Name: memberName, JoinedAt: time.Now()})
+}
+
+// 离开房间；主持人离开时转交给最早加入的在线成员，最后一个成员离开时关闭房间
+func LeaveRoom(code string, userID int64) error {
+	room, err := getRoom(code)
+	if err != nil {
+		return err
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if room.member(userID) == nil {
+		return ErrNotRoomMember
+	}
+
+	members := room.members[:0]
+	for _, m := range room.members {
+		if m.UserID != userID {
+			members = append(members, m)
+		}
+	}
+	room.members = members
+	for c := range room.conns {
+		if c.userID == userID {
+			c.trySend(roomMessage{Type: roomMsgClosed, Message: "left the room"})
+			c.close()
+			delete(room.conns, c)
+		}
+	}
+
+	if len(room.members) == 0 {
+		room.close()
+		return nil
+	}
+	if room.hostID == userID {
+		room.handoffToNext()
+	}
+	room.broadcastRoom()
+	return nil
+}
+
+// 查询房间
+func GetRoom(code string) (*RoomView, error) {
+	room, err := getRoom(code)
+	if err != nil {
+		return nil, err
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return room.view(), nil
+}
+
+// 执行房间中的操作并通知所有成员
+func RoomAct(code string, userID int64, action RoomAction) (*RoomView, error) {
+	room, err := getRoom(code)
+	if err != nil {
+		return nil, err
+	}
+
+	// 查询歌曲放在加锁之前
+	var music *Music
+	if (action.Type == RoomActionPlay || action.Type == RoomActionSuggest) && action.MusicID != 0 {
+		if music, err = GetMusicByID(action.MusicID); err != nil {
+			return nil, fmt.Errorf("%w: music not found", ErrInvalidRoomAction)
+		}
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if room.closed {
+		return nil, ErrRoomNotFound
+	}
+	if room.member(userID) == nil {
+		return nil, ErrNotRoomMember
+	}
+	if err := room.apply(userID, action, music); err != nil {
+		return nil, err
+	}
+	return room.view(), nil
+}
+
+func (r *ListeningRoom) apply(userID int64, action RoomAction, music *Music) error {
+	switch action.Type {
+	case RoomActionPlay, RoomActionPause, RoomActionSeek, RoomActionNext, RoomActionHandoff:
+		if userID != r.hostID {
+			return ErrNotRoomHost
+		}
+	}
+
+	switch action.Type {
+	case RoomActionPlay:
+		if music != nil {
+			r.setTrack(music)
+			r.removeSuggestedMusic(music.Id)
+		} else if r.playback.Music == nil {
+			return fmt.Errorf("%w: nothing to play", ErrInvalidRoomAction)
+		} else {
+			r.setPosition(r.currentPosition(), true)
+		}
+		r.broadcastPlayback()
+		r.broadcastRoom()
+	case RoomActionPause:
+		r.setPosition(r.currentPosition(), false)
+		r.broadcastPlayback()
+	case RoomActionSeek:
+		if action.PositionMs < 0 {
+			return fmt.Errorf("%w: negative position", ErrInvalidRoomAction)
+		}
+		r.setPosition(action.PositionMs, r.playback.Playing)
+		r.broadcastPlayback()
+	case RoomActionNext:
+		r.next()
+		r.broadcastPlayback()
+		r.broadcastRoom()
+	case RoomActionHandoff:
+		if r.member(action.UserID) == nil {
+			return fmt.Errorf("%w: user %d is not in the room", ErrInvalidRoomAction, action.UserID)
+		}
+		r.hostID = action.UserID
+		r.hostSeen = time.Now()
+		r.broadcastRoom()
+	case RoomActionSuggest:
+		if music == nil {
+			return fmt.Errorf("%w: musicId required", ErrInvalidRoomAction)
+		}
+		r.suggest(userID, *music)
+		r.broadcastRoom()
+	case RoomActionVote:
+		s := r.suggestion(action.SuggestionID)
+		if s == nil {
+			return fmt.Errorf("%w: suggestion not found", ErrInvalidRoomAction)
+		}
+		s.setVote(userID, action.Vote > 0)
+		r.broadcastRoom()
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidRoomAction, action.Type)
+	}
+	return nil
+}
+
+// ==== 播放 ====
+
+// 当前的播放进度
+func (r *ListeningRoom) currentPosition() int64 {
+	p := r.playback
+	if !p.Playing {
+		return p.PositionMs
+	}
+	return p.PositionMs + nowMs() - p.UpdatedAt
+}
+
+func (r *ListeningRoom) setPosition(positionMs int64, playing bool) {
+	r.playback.PositionMs = positionMs
+	r.playback.Playing = playing
+	r.playback.UpdatedAt = nowMs()
+}
+
+func (r *ListeningRoom) setTrack(music *Music) {
+	r.playback.Music = music
+	r.setPosition(0, true)
+}
+
+// 播放票数最多的推荐，没有推荐时停止播放
+func (r *ListeningRoom) next() {
+	r.sortSuggestions()
+	if len(r.suggestions) == 0 {
+		r.playback.Music = nil
+		r.setPosition(0, false)
+		return
+	}
+	top := r.suggestions[0]
+	r.suggestions = r.suggestions[1:]
+	r.setTrack(&top.Music)
+}
+
+// ==== 推荐 ====
+
+func (s *RoomSuggestion) setVote(userID int64, up bool) {
+	voters := s.Voters[:0]
+	for _, id := range s.Voters {
+		if id != userID {
+			voters = append(voters, id)
+		}
+	}
+	if up {
+		voters = append(voters, userID)
+	}
+	s.Voters = voters
+	s.Votes = len(voters)
+}
+
+func (r *ListeningRoom) suggestion(id int64) *RoomSuggestion {
+	for _, s := range r.suggestions {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func (r *ListeningRoom) suggest(userID int64, music Music) {
+	for _, s := range r.suggestions {
+		if s.Music.Id == music.Id {
+			s.setVote(userID, true)
+			return
+		}
+	}
+	if len(r.suggestions) >= roomMaxSuggestions {
+		// 丢弃票数最少、最晚推荐的一首
+		r.sortSuggestions()
+		r.suggestions = r.suggestions[:len(r.suggestions)-1]
+	}
+	r.nextID++
+	r.suggestions = append(r.suggestions, &RoomSuggestion{
+		ID:          r.nextID,
+		Music:       music,
+		SuggestedBy: userID,
+		Votes:       1,
+		Voters:      []int64{userID},
+		CreatedAt:   time.Now(),
+	})
+}
+
+func (r *ListeningRoom) removeSuggestedMusic(musicID int64) {
+	out := r.suggestions[:0]
+	for _, s := range r.suggestions {
+		if s.Music.Id != musicID {
+			out = append(out, s)
+		}
+	}
+	r.suggestions = out
+}
+
+func (r *ListeningRoom) sortSuggestions() {
+	sort.SliceStable(r.suggestions, func(i, j int) bool {
+		if r.suggestions[i].Votes != r.suggestions[j].Votes {
+			return r.suggestions[i].Votes > r.suggestions[j].Votes
+		}
+		return r.suggestions[i].ID < r.suggestions[j].ID
+	})
+}
+
+// ==== 成员与连接 ====
+
+func (r *ListeningRoom) member(userID int64) *RoomMember {
+	for _, m := range r.members {
+		if m.UserID == userID {
+			return m
+		}
+	}
+	return nil
+}
+
+func (r *ListeningRoom) online(userID int64) bool {
+	for c := range r.conns {
+		if c.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// 把主持人转交给最早加入的在线成员，都不在线时转交给最早加入的成员
+func (r *ListeningRoom) handoffToNext() {
+	var next *RoomMember
+	for _, m := range r.members {
+		if m.UserID == r.hostID {
+			continue
+		}
+		if r.online(m.UserID) {
+			next = m
+			break
+		}
+		if next == nil {
+			next = m
+		}
+	}
+	if next != nil {
+		r.hostID = next.UserID
+		r.hostSeen = time.Now()
+	}
+}
+
+func (r *ListeningRoom) view() *RoomView {
+	v := &RoomView{
+		Code:        r.code,
+		Name:        r.name,
+		HostID:      r.hostID,
+		CreatedAt:   r.createdAt,
+		Members:     make([]RoomMember, 0, len(r.members)),
+		Playback:    r.playback,
+		Suggestions: make([]RoomSuggestion, 0, len(r.suggestions)),
+		ServerTime:  nowMs(),
+	}
+	for _, m := range r.members {
+		member := *m
+		member.Online = r.online(m.UserID)
+		v.Members = append(v.Members, member)
+	}
+	r.sortSuggestions()
+	for _, s := range r.suggestions {
+		suggestion := *s
+		suggestion.Voters = append([]int64{}, s.Voters...)
+		v.Suggestions = append(v.Suggestions, suggestion)
+	}
+	return v
+}
+
+// 向所有连接发送消息；发送缓冲已满的连接会被断开
+func (r *ListeningRoom) broadcast(msg roomMessage) {
+	for c := range r.conns {
+		if !c.trySend(msg) {
+			c.close()
+		}
+	}
+}
+
+func (r *ListeningRoom) broadcastRoom() {
+	r.broadcast(roomMessage{Type: roomMsgRoom, Room: r.view()})
+}
+
+func (r *ListeningRoom) broadcastPlayback() {
+	p := r.playback
+	r.broadcast(roomMessage{Type: roomMsgPlayback, Playback: &p, ServerTime: nowMs()})
+}
+
+func (r *ListeningRoom) close() {
+	r.closed = true
+	for c := range r.conns {
+		c.trySend(roomMessage{Type: roomMsgClosed, Message: "room closed"})
+		c.close()
+	}
+	r.conns = map[*roomConn]bool{}
+	rooms.Lock()
+	delete(rooms.m, r.code)
+	rooms.Unlock()
+}
+
+// 定期广播播放进度；歌曲播放完时自动切到下一首，主持人断线过久时自动转交，长时间没有连接时关闭房间
+func (r *ListeningRoom) syncLoop() {
+	ticker := time.NewTicker(roomSyncPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		if len(r.conns) == 0 && now.Sub(r.lastActive) > roomIdleTimeout {
+			r.close()
+			r.mu.Unlock()
+			return
+		}
+		if len(r.conns) > 0 {
+			r.lastActive = now
+		}
+
+		if r.online(r.hostID) {
+			r.hostSeen = now
+		} else if now.Sub(r.hostSeen) > roomHostGrace && len(r.conns) > 0 {
+			r.handoffToNext()
+			r.broadcastRoom()
+		}
+
+		if p := r.playback; p.Playing && p.Music != nil && p.Music.DurationMs > 0 && r.currentPosition() >= p.Music.DurationMs {
+			r.next()
+			r.broadcastRoom()
+		}
+		if r.playback.Playing {
+			r.broadcastPlayback()
+		}
+		r.mu.Unlock()
+	}
+}
+
+// 升级为 WebSocket 连接并加入房间，阻塞到连接断开
+func ServeRoomSocket(w http.ResponseWriter, req *http.Request, code string, userID int64, memberName string) {
+	room, err := getRoom(code)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	conn, err := upgradeWS(w, req)
+	if err != nil {
+		return
+	}
+	c := &roomConn{wsConn: conn, userID: userID}
+
+	room.mu.Lock()
+	if room.closed {
+		room.mu.Unlock()
+		c.trySend(roomMessage{Type: roomMsgClosed, Message: "room closed"})
+		c.close()
+		return
+	}
+	room.join(userID, memberName)
+	room.conns[c] = true
+	room.lastActive = time.Now()
+	room.broadcastRoom()
+	p := room.playback
+	c.trySend(roomMessage{Type: roomMsgPlayback, Playback: &p, ServerTime: nowMs()})
+	room.mu.Unlock()
+
+	defer func() {
+		room.mu.Lock()
+		if room.conns[c] {
+			delete(room.conns, c)
+			room.lastActive = time.Now()
+			room.broadcastRoom()
+		}
+		room.mu.Unlock()
+		c.close()
+	}()
+	c.readLoop(fmt.Sprintf("room %s: user %d", room.code, userID), func(data []byte) {
+		var msg roomMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.trySend(roomMessage{Type: roomMsgError, Message: "invalid message: " + err.Error()})
+			return
+		}
+		switch msg.Type {
+		case roomMsgTime:
+			// 客户端用 (clientTime + 收到回复的时间) / 2 与 serverTime 的差估算时钟偏差
+			c.trySend(roomMessage{Type: roomMsgTime, ID: msg.ID, ClientTime: msg.ClientTime, ServerTime: nowMs()})
+		case roomMsgAction:
+			if msg.Action == nil {
+				c.trySend(roomMessage{Type: roomMsgError, ID: msg.ID, Message: "action required"})
+				return
+			}
+			if _, err := RoomAct(room.code, userID, *msg.Action); err != nil {
+				c.trySend(roomMessage{Type: roomMsgError, ID: msg.ID, Message: err.Error()})
+				return
+			}
+			c.trySend(roomMessage{Type: roomMsgAck, ID: msg.ID})
+		default:
+			c.trySend(roomMessage{Type: roomMsgError, ID: msg.ID, Message: "unknown message type: " + msg.Type})
+		}
+	})
+}
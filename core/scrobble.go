@@ -0,0 +1,634 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 支持的上报服务
+const (
+	ScrobbleListenBrainz = "listenbrainz"
+	ScrobbleLastFM       = "lastfm"
+)
+
+// 上报队列状态
+const (
+	ScrobblePending = "pending"
+	ScrobbleSent    = "sent"
+	ScrobbleFailed  = "failed"
+)
+
+const (
+	scrobbleBatchSize   = 50                  // Last.fm 单次最多 50 条
+	scrobbleMaxAttempts = 10                  // 超过后标记为失败，需手动重试
+	scrobbleMaxAge      = 14 * 24 * time.Hour // Last.fm 不接受两周前的记录
+)
+
+// 持久化的上报重试队列，每条播放记录最多入队一次
+type ScrobbleQueue struct {
+	ID            int64      `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	PlayHistoryID int64      `json:"playHistoryId" gorm:"column:play_history_id;not null;uniqueIndex"`
+	Service       string     `json:"service" gorm:"column:service;type:varchar(32);not null"`
+	Status        string     `json:"status" gorm:"column:status;type:varchar(16);not null;index"`
+	Attempts      int        `json:"attempts" gorm:"column:attempts;not null;default:0"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt" gorm:"column:next_attempt_at;not null"`
+	LastError     string     `json:"lastError" gorm:"column:last_error;type:text"`
+	CreatedAt     time.Time  `json:"createdAt" gorm:"column:created_at;autoCreateTime"`
+	SentAt        *time.Time `json:"sentAt" gorm:"column:sent_at"`
+}
+
+func (ScrobbleQueue) TableName() string {
+	return "scrobble_queue"
+}
+
+type ScrobbleConfig struct {
+	UserID     int64 // 上报凭据所属的用户，只上报该用户的播放
+	Service    string
+	URL        string
+	Token      string // ListenBrainz 用户 token
+	APIKey     string // Last.fm
+	APISecret  string // Last.fm
+	SessionKey string // Last.fm
+	Interval   time.Duration
+	Timeout    time.Duration
+}
+
+// 从环境变量读取上报配置，SCROBBLE_SERVICE 为空时不启用
+func LoadScrobbleConfig() ScrobbleConfig {
+	cfg := ScrobbleConfig{
+		UserID:     DefaultUserID,
+		Service:    strings.ToLower(os.Getenv("SCROBBLE_SERVICE")),
+		URL:        os.Getenv("SCROBBLE_URL"),
+		Token:      os.Getenv("SCROBBLE_TOKEN"),
+		APIKey:     os.Getenv("LASTFM_API_KEY"),
+		APISecret:  os.Getenv("LASTFM_API_SECRET"),
+		SessionKey: os.Getenv("LASTFM_SESSION_KEY"),
+		Interval:   time.Minute,
+		Timeout:    15 * time.Second,
+	}
+	if cfg.URL == "" {
+		switch cfg.Service {
+		case ScrobbleListenBrainz:
+			cfg.URL = "https://api.listenbrainz.org/1/submit-listens"
+		case ScrobbleLastFM:
+			cfg.URL = "https://ws.audioscrobbler.com/2.0/"
+		}
+	}
+	if v := os.Getenv("SCROBBLE_USER_ID"); v != "" {
+		if id, err := strconv.ParseInt(v, 10, 64); err != nil || id <= 0 {
+			log.Printf("scrobble: invalid SCROBBLE_USER_ID %q, using %d", v, cfg.UserID)
+		} else {
+			cfg.UserID = id
+		}
+	}
+	if t := os.Getenv("SCROBBLE_INTERVAL"); t != "" {
+		if parsed, err := parseScrobbleInterval(t); err != nil {
+			log.Printf("scrobble: invalid SCROBBLE_INTERVAL %q, using %s: %v", t, cfg.Interval, err)
+		} else {
+			cfg.Interval = parsed
+		}
+	}
+	return cfg
+}
+
+// 上报间隔：纯数字按秒计算，也可以写成 30s、5m 这样的时长
+func parseScrobbleInterval(v string) (time.Duration, error) {
+	v = strings.TrimSpace(v)
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if d, err = time.ParseDuration(v); err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("interval must be positive")
+	}
+	return d, nil
+}
+
+func (cfg ScrobbleConfig) Enabled() bool {
+	return cfg.Service == ScrobbleListenBrainz || cfg.Service == ScrobbleLastFM
+}
+
+// 一条待上报或导出的收听
+type Listen struct {
+	PlayHistoryID int64
+	ListenedAt    time.Time
+	Artist        string
+	Track         string
+	Album         string
+	DurationMs    int64
+}
+
+// 是否达到上报门槛（Last.fm 规则）：歌曲长于 30 秒，且收听了一半或 4 分钟以上
+// 自动记录的播放没有收听时长，按整首听完计算
+func qualifiesForScrobble(ph *PlayHistory) bool {
+	duration := ph.Music.DurationMs
+	listened := ph.ListenedMs
+	if listened == 0 && ph.Source == PlaySourceAuto {
+		listened = duration
+	}
+	if duration > 0 && duration <= 30_000 {
+		return false
+	}
+	threshold := int64(240_000)
+	if duration > 0 && duration/2 < threshold {
+		threshold = duration / 2
+	}
+	return listened >= threshold
+}
+
+func listenFromHistory(ph *PlayHistory) Listen {
+	return Listen{
+		PlayHistoryID: ph.ID,
+		ListenedAt:    ph.PlayedAt,
+		Artist:        ph.Music.Singer,
+		Track:         ph.Music.Title,
+		Album:         ph.Music.Album,
+		DurationMs:    ph.Music.DurationMs,
+	}
+}
+
+// 查询用户在时间范围内的收听，onlyQualified 为 true 时只返回达到上报门槛的记录
+func ListListens(r StatsRange, onlyQualified bool) ([]Listen, error) {
+	query := DB.Preload("Music").Where("user_id = ?", r.UserID).Order("played_at ASC")
+	if !r.From.IsZero() {
+		query = query.Where("played_at >= ?", r.From)
+	}
+	if !r.To.IsZero() {
+		query = query.Where("played_at < ?", r.To)
+	}
+	var history []PlayHistory
+	if err := query.Find(&history).Error; err != nil {
+		return nil, err
+	}
+
+	listens := make([]Listen, 0, len(history))
+	for i := range history {
+		if onlyQualified && !qualifiesForScrobble(&history[i]) {
+			continue
+		}
+		listens = append(listens, listenFromHistory(&history[i]))
+	}
+	return listens, nil
+}
+
+// ==== 上报 ====
+
+// Scrobbler 定时把达到门槛的播放记录加入队列并上报
+type Scrobbler struct {
+	cfg    ScrobbleConfig
+	client *http.Client
+	flush  chan struct{}
+}
+
+var scrobbler *Scrobbler
+
+// StartScrobbler 按配置启动后台上报任务，未配置时不做任何事
+func StartScrobbler() {
+	cfg := LoadScrobbleConfig()
+	if !cfg.Enabled() {
+		return
+	}
+	scrobbler = &Scrobbler{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		flush:  make(chan struct{}, 1),
+	}
+	log.Printf("scrobbling plays of user %d to %s (%s)", cfg.UserID, cfg.Service, cfg.URL)
+	go scrobbler.run()
+}
+
+// 立即执行一轮上报
+func FlushScrobbles() error {
+	if scrobbler == nil {
+		return errors.New("scrobbling is not configured")
+	}
+	select {
+	case scrobbler.flush <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *Scrobbler) run() {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.enqueue(); err != nil {
+			log.Printf("scrobble: failed to enqueue plays: %v", err)
+		}
+		if err := s.submitPending(); err != nil {
+			log.Printf("scrobble: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.flush:
+		}
+	}
+}
+
+// 把上报用户新的、达到门槛的播放记录加入队列
+func (s *Scrobbler) enqueue() error {
+	var history []PlayHistory
+	err := DB.Preload("Music").
+		Where("user_id = ? AND played_at > ? AND source <> ?", s.cfg.UserID, time.Now().Add(-scrobbleMaxAge), PlaySourceImport). // 导入的记录本就来自外部服务
+		Where("NOT EXISTS (SELECT 1 FROM scrobble_queue q WHERE q.play_history_id = play_history.id)").
+		Order("played_at ASC").
+		Find(&history).Error
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range history {
+		ph := &history[i]
+		// 自动记录的播放要等整首歌的时长过去后才算听完
+		if ph.Source == PlaySourceAuto && ph.PlayedAt.Add(time.Duration(ph.Music.DurationMs)*time.Millisecond).After(now) {
+			continue
+		}
+		if !qualifiesForScrobble(ph) {
+			continue
+		}
+		item := ScrobbleQueue{
+			PlayHistoryID: ph.ID,
+			Service:       s.cfg.Service,
+			Status:        ScrobblePending,
+			NextAttemptAt: now,
+		}
+		if err := DB.Create(&item).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 分批上报到期的待上报记录
+func (s *Scrobbler) submitPending() error {
+	for {
+		var items []ScrobbleQueue
+		err := DB.Where("status = ? AND service = ? AND next_attempt_at <= ?", ScrobblePending, s.cfg.Service, time.Now()).
+			Order("id ASC").Limit(scrobbleBatchSize).Find(&items).Error
+		if err != nil || len(items) == 0 {
+			return err
+		}
+
+		ids := make([]int64, len(items))
+		for i, it := range items {
+			ids[i] = it.PlayHistoryID
+		}
+		var history []PlayHistory
+		if err := DB.Preload("Music").Where("id IN ?", ids).Order("played_at ASC").Find(&history).Error; err != nil {
+			return err
+		}
+		listens := make([]Listen, len(history))
+		sentIDs := make([]int64, len(history))
+		for i := range history {
+			listens[i] = listenFromHistory(&history[i])
+			sentIDs[i] = history[i].ID
+		}
+
+		// 对应的播放记录已被删除
+		if len(listens) < len(items) {
+			found := make(map[int64]bool, len(history))
+			for _, ph := range history {
+				found[ph.ID] = true
+			}
+			for _, it := range items {
+				if !found[it.PlayHistoryID] {
+					DB.Model(&it).Updates(map[string]interface{}{"status": ScrobbleFailed, "last_error": "play history deleted"})
+				}
+			}
+		}
+		if len(listens) == 0 {
+			continue
+		}
+
+		err = s.submit(listens)
+		if err == nil {
+			now := time.Now()
+			DB.Model(&ScrobbleQueue{}).Where("play_history_id IN ?", sentIDs).
+				Updates(map[string]interface{}{"status": ScrobbleSent, "sent_at": now, "last_error": ""})
+			continue
+		}
+
+		// 失败：指数退避重试，服务端明确拒绝的请求不再重试
+		var rejected *scrobbleRejectedError
+		permanent := errors.As(err, &rejected)
+		for _, it := range items {
+			if !containsInt64(sentIDs, it.PlayHistoryID) {
+				continue
+			}
+			it.Attempts++
+			it.LastError = err.Error()
+			backoff := time.Duration(1<<min(it.Attempts, 9)) * time.Minute
+			it.NextAttemptAt = time.Now().Add(min(backoff, 6*time.Hour))
+			if permanent || it.Attempts >= scrobbleMaxAttempts {
+				it.Status = ScrobbleFailed
+			}
+			DB.Save(&it)
+		}
+		return fmt.Errorf("failed to submit %d listens: %w", len(listens), err)
+	}
+}
+
+// 服务端拒绝了请求（4xx，不含 429），重试也不会成功
+type scrobbleRejectedError struct {
+	Status int
+	Body   string
+}
+
+func (e *scrobbleRejectedError) Error() string {
+	return fmt.Sprintf("rejected by server: status=%d body=%s", e.Status, e.Body)
+}
+
+func (s *Scrobbler) submit(listens []Listen) error {
+	var req *http.Request
+	var err error
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+
+	switch s.cfg.Service {
+	case ScrobbleListenBrainz:
+		body, merr := json.Marshal(listenBrainzSubmission(listens))
+		if merr != nil {
+			return merr
+		}
+		req, err = http.NewRequestWithContext(ctx, "POST", s.cfg.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Token "+s.cfg.Token)
+	case ScrobbleLastFM:
+		form := lastFMScrobbleForm(listens, s.cfg.APIKey, s.cfg.SessionKey, s.cfg.APISecret)
+		req, err = http.NewRequestWithContext(ctx, "POST", s.cfg.URL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	default:
+		return errors.New("unknown scrobble service: " + s.cfg.Service)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return &scrobbleRejectedError{Status: resp.StatusCode, Body: string(body)}
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("scrobble api error: status=%d body=%s", resp.StatusCode, string(body))
+	}
+
+	// Last.fm 出错时也可能返回 200 并在 JSON 中携带 error
+	if s.cfg.Service == ScrobbleLastFM {
+		var lf struct {
+			Error   int    `json:"error"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &lf) == nil && lf.Error != 0 {
+			// 11、16 为服务暂时不可用，可重试
+			if lf.Error == 11 || lf.Error == 16 {
+				return fmt.Errorf("lastfm error %d: %s", lf.Error, lf.Message)
+			}
+			return &scrobbleRejectedError{Status: resp.StatusCode, Body: string(body)}
+		}
+	}
+	return nil
+}
+
+func containsInt64(list []int64, v int64) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ==== 上报格式 ====
+
+type listenBrainzTrack struct {
+	ArtistName     string                 `json:"artist_name"`
+	TrackName      string                 `json:"track_name"`
+	ReleaseName    string                 `json:"release_name,omitempty"`
+	AdditionalInfo map[string]interface{} `json:"additional_info,omitempty"`
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64             `json:"listened_at"`
+	TrackMetadata listenBrainzTrack `json:"track_metadata"`
+}
+
+type listenBrainzPayload struct {
+	ListenType string               `json:"listen_type"`
+	Payload    []listenBrainzListen `json:"payload"`
+}
+
+func toListenBrainz(l Listen) listenBrainzListen {
+	info := map[string]interface{}{"submission_client": "NMP"}
+	if l.DurationMs > 0 {
+		info["duration_ms"] = l.DurationMs
+	}
+	return listenBrainzListen{
+		ListenedAt: l.ListenedAt.Unix(),
+		TrackMetadata: listenBrainzTrack{
+			ArtistName:     l.Artist,
+			TrackName:      l.Track,
+			ReleaseName:    l.Album,
+			AdditionalInfo: info,
+		},
+	}
+}
+
+// ListenBrainz submit-listens 请求体
+func listenBrainzSubmission(listens []Listen) listenBrainzPayload {
+	p := listenBrainzPayload{ListenType: "import"}
+	if len(listens) == 1 {
+		p.ListenType = "single"
+	}
+	for _, l := range listens {
+		p.Payload = append(p.Payload, toListenBrainz(l))
+	}
+	return p
+}
+
+// Last.fm track.scrobble 表单，包含 api_sig 签名
+func lastFMScrobbleForm(listens []Listen, apiKey, sessionKey, secret string) url.Values {
+	form := url.Values{}
+	form.Set("method", "track.scrobble")
+	form.Set("api_key", apiKey)
+	form.Set("sk", sessionKey)
+	for i, l := range listens {
+		form.Set(fmt.Sprintf("artist[%d]", i), l.Artist)
+		form.Set(fmt.Sprintf("track[%d]", i), l.Track)
+		form.Set(fmt.Sprintf("timestamp[%d]", i), strconv.FormatInt(l.ListenedAt.Unix(), 10))
+		if l.Album != "" {
+			form.Set(fmt.Sprintf("album[%d]", i), l.Album)
+		}
+		if l.DurationMs > 0 {
+			form.Set(fmt.Sprintf("duration[%d]", i), strconv.FormatInt(l.DurationMs/1000, 10))
+		}
+	}
+	form.Set("api_sig", lastFMSignature(form, secret))
+	form.Set("format", "json")
+	return form
+}
+
+// Last.fm 签名：参数按名称排序后拼接 name+value，末尾加上 secret 取 md5
+func lastFMSignature(params url.Values, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "format" && k != "callback" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteString(params.Get(k))
+	}
+	sb.WriteString(secret)
+	sum := md5.Sum([]byte(sb.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// ==== 导出 ====
+
+// Last.fm user.getRecentTracks 中单条记录的结构
+type lastFMTrack struct {
+	Artist struct {
+		Text string `json:"#text"`
+	} `json:"artist"`
+	Album struct {
+		Text string `json:"#text"`
+	} `json:"album"`
+	Name string `json:"name"`
+	Date struct {
+		UTS  string `json:"uts"`
+		Text string `json:"#text"`
+	} `json:"date"`
+}
+
+const lastFMDateLayout = "02 Jan 2006, 15:04"
+
+// 将收听记录导出为 ListenBrainz / Last.fm 兼容的 JSON 或 CSV
+func ExportListens(w io.Writer, listens []Listen, service, format string) error {
+	switch {
+	case service == ScrobbleListenBrainz && format == "json":
+		out := make([]listenBrainzListen, len(listens))
+		for i, l := range listens {
+			out[i] = toListenBrainz(l)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+
+	case service == ScrobbleLastFM && format == "json":
+		out := make([]lastFMTrack, len(listens))
+		for i, l := range listens {
+			out[i].Artist.Text = l.Artist
+			out[i].Album.Text = l.Album
+			out[i].Name = l.Track
+			out[i].Date.UTS = strconv.FormatInt(l.ListenedAt.Unix(), 10)
+			out[i].Date.Text = l.ListenedAt.UTC().Format(lastFMDateLayout)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+
+	case service == ScrobbleListenBrainz && format == "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"listened_at", "artist_name", "track_name", "release_name", "duration_ms"})
+		for _, l := range listens {
+			cw.Write([]string{
+				strconv.FormatInt(l.ListenedAt.Unix(), 10), l.Artist, l.Track, l.Album,
+				strconv.FormatInt(l.DurationMs, 10),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case service == ScrobbleLastFM && format == "csv":
+		// 与常见 Last.fm 导出工具的列一致
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"uts", "utc_time", "artist", "artist_mbid", "album", "album_mbid", "track", "track_mbid"})
+		for _, l := range listens {
+			cw.Write([]string{
+				strconv.FormatInt(l.ListenedAt.Unix(), 10), l.ListenedAt.UTC().Format(lastFMDateLayout),
+				l.Artist, "", l.Album, "", l.Track, "",
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unsupported export format: %s/%s", service, format)
+}
+
+// ==== 队列状态 ====
+
+type ScrobbleStatus struct {
+	Enabled bool             `json:"enabled"`
+	Service string           `json:"service"`
+	URL     string           `json:"url"`
+	Counts  map[string]int64 `json:"counts"` // 各状态的条数
+	Failed  []ScrobbleQueue  `json:"failed"` // 最近的失败记录
+}
+
+func GetScrobbleStatus() (*ScrobbleStatus, error) {
+	cfg := LoadScrobbleConfig()
+	st := &ScrobbleStatus{
+		Enabled: scrobbler != nil,
+		Service: cfg.Service,
+		URL:     cfg.URL,
+		Counts:  map[string]int64{ScrobblePending: 0, ScrobbleSent: 0, ScrobbleFailed: 0},
+		Failed:  []ScrobbleQueue{},
+	}
+
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := DB.Model(&ScrobbleQueue{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		st.Counts[r.Status] = r.Count
+	}
+	err := DB.Where("status = ?", ScrobbleFailed).Order("id DESC").Limit(20).Find(&st.Failed).Error
+	return st, err
+}
+
+// 将失败的记录重新加入待上报队列
+func RetryFailedScrobbles() (int64, error) {
+	result := DB.Model(&ScrobbleQueue{}).Where("status = ?", ScrobbleFailed).
+		Updates(map[string]interface{}{"status": ScrobblePending, "attempts": 0, "next_attempt_at": time.Now()})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if scrobbler != nil {
+		FlushScrobbles()
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScrobbleInterval(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"60", time.Minute, false},
+		{" 15 ", 15 * time.Second, false},
+		{"30s", 30 * time.Second, false},
+		{"5m", 5 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"0", 0, true},
+		{"-10", 0, true},
+		{"-1m", 0, true},
+		{"30ss", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseScrobbleInterval(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseScrobbleInterval(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseScrobbleInterval(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
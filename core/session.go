@@ -0,0 +1,111 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionCookieName = "nmp_session"
+	sessionHeaderName = "X-Session-Id"
+	privateSessionTTL = 24 * time.Hour // 隐私收听在最后一次设置后保持的时长
+	userHeaderName    = "X-User-Id"
+	deviceHeaderName  = "X-Device-Id"
+)
+
+// 未指定用户时使用的默认用户（与登录接口返回的 userId 一致）
+const DefaultUserID int64 = 1
+
+// 获取请求所属的用户：依次读取请求头 X-User-Id 和查询参数 userId，都没有时为默认用户
+// 服务本身不做身份验证，两者都由客户端自行填写，只能部署在负责认证的反向代理之后，
+// 由代理根据登录状态覆盖（并去掉客户端传入的）X-User-Id 和 userId
+func currentUserID(c *gin.Context) int64 {
+	for _, v := range []string{c.GetHeader(userHeaderName), c.Query("userId")} {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil && id > 0 {
+			return id
+		}
+	}
+	return DefaultUserID
+}
+
+// 获取请求所属的设备：依次读取请求头 X-Device-Id 和查询参数 deviceId，都没有时为 "default"
+func currentDeviceID(c *gin.Context) string {
+	for _, v := range []string{c.GetHeader(deviceHeaderName), c.Query("deviceId")} {
+		if v != "" && len(v) <= 64 {
+			return v
+		}
+	}
+	return defaultDeviceID
+}
+
+// 获取请求所属的会话 ID：依次读取请求头、查询参数和 Cookie，都没有时生成新会话并写入 Cookie
+// 使用 Cookie 是因为 <audio> 标签发起的音频请求无法附带自定义请求头
+func sessionID(c *gin.Context) string {
+	if id := c.GetHeader(sessionHeaderName); id != "" {
+		return id
+	}
+	if id := c.Query("session"); id != "" {
+		return id
+	}
+	if id, err := c.Cookie(sessionCookieName); err == nil && id != "" {
+		return id
+	}
+
+	id := newSessionID()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 处于隐私收听模式的会话及其过期时间
+var privateSessions = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// 开启或关闭会话的隐私收听模式，开启期间不记录播放历史
+func SetPrivateSession(id string, enabled bool) {
+	privateSessions.Lock()
+	defer privateSessions.Unlock()
+
+	now := time.Now()
+	for k, exp := range privateSessions.m {
+		if now.After(exp) {
+			delete(privateSessions.m, k)
+		}
+	}
+	if enabled {
+		privateSessions.m[id] = now.Add(privateSessionTTL)
+	} else {
+		delete(privateSessions.m, id)
+	}
+}
+
+// 会话是否处于隐私收听模式
+func IsPrivateSession(id string) bool {
+	if id == "" {
+		return false
+	}
+	privateSessions.Lock()
+	defer privateSessions.Unlock()
+
+	exp, ok := privateSessions.m[id]
+	return ok && time.Now().Before(exp)
+}
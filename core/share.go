@@ -0,0 +1,252 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 分享的对象
+const (
+	ShareKindPlaylist = "playlist"
+	ShareKindMusic    = "music"
+)
+
+const shareTokenBytes = 16
+
+var (
+	ErrShareNotFound  = errors.New("share link not found")
+	ErrShareExpired   = errors.New("share link has expired")
+	ErrShareRevoked   = errors.New("share link has been revoked")
+	ErrShareExhausted = errors.New("share link has reached its access limit")
+	ErrInvalidShare   = errors.New("invalid share link")
+)
+
+// 分享链接：持有 token 的任何人都可以不登录查看歌单或歌曲，并播放其中的歌曲
+type ShareLink struct {
+	ID           int64      `json:"id" gorm:"primaryKey;autoIncrement;column:id"`
+	Token        string     `json:"token" gorm:"column:token;type:varchar(32);not null;uniqueIndex"`
+	Kind         string     `json:"kind" gorm:"column:kind;type:varchar(16);not null"` // 见 ShareKind*
+	TargetID     int64      `json:"targetId" gorm:"column:target_id;not null;index"`
+	CreatedBy    int64      `json:"createdBy" gorm:"column:created_by;not null;index"`
+	CreatedAt    time.Time  `json:"createdAt" gorm:"column:created_at;autoCreateTime"`
+	ExpiresAt    *time.Time `json:"expiresAt" gorm:"column:expires_at"` // 为空表示永不过期
+	RevokedAt    *time.Time `json:"revokedAt" gorm:"column:revoked_at"`
+	MaxAccess    *int64     `json:"maxAccess" gorm:"column:max_access"` // 最多打开的次数，为空表示不限
+	AccessCount  int64      `json:"accessCount" gorm:"column:access_count;not null;default:0"`
+	LastAccessAt *time.Time `json:"lastAccessAt" gorm:"column:last_access_at"`
+}
+
+func (ShareLink) TableName() string {
+	return "share_links"
+}
+
+// 链接当前是否可用，不可用时返回原因；opening 为 false 时（播放歌曲）不检查访问次数，
+// 以免最后一次打开链接的人无法播放
+func (s *ShareLink) check(now time.Time, opening bool) error {
+	if s.RevokedAt != nil {
+		return ErrShareRevoked
+	}
+	if s.ExpiresAt != nil && !now.Before(*s.ExpiresAt) {
+		return ErrShareExpired
+	}
+	if opening && s.MaxAccess != nil && s.AccessCount >= *s.MaxAccess {
+		return ErrShareExhausted
+	}
+	return nil
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// 创建分享链接；分享歌单需要是歌单的创建者或编辑者
+func CreateShareLink(userID int64, kind string, targetID int64, expiresAt *time.Time, maxAccess *int64) (*ShareLink, error) {
+	switch kind {
+	case ShareKindPlaylist:
+		if err := RequirePlaylistRole(targetID, userID, PlaylistRoleEditor); err != nil {
+			return nil, err
+		}
+	case ShareKindMusic:
+		if _, err := GetMusicByID(targetID); err != nil {
+			return nil, fmt.Errorf("%w: music not found", ErrInvalidShare)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown kind %q", ErrInvalidShare, kind)
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expiresAt is in the past", ErrInvalidShare)
+	}
+	if maxAccess != nil && *maxAccess <= 0 {
+		return nil, fmt.Errorf("%w: maxAccess must be positive", ErrInvalidShare)
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		return nil, err
+	}
+	link := ShareLink{
+		Token:     token,
+		Kind:      kind,
+		TargetID:  targetID,
+		CreatedBy: userID,
+		ExpiresAt: expiresAt,
+		MaxAccess: maxAccess,
+	}
+	if err := DB.Create(&link).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// 用户创建的分享链接，最新的在前
+func GetShareLinks(userID int64) ([]ShareLink, error) {
+	links := []ShareLink{}
+	err := DB.Where("created_by = ?", userID).Order("created_at DESC, id DESC").Find(&links).Error
+	return links, err
+}
+
+// 撤销分享链接；创建者和被分享歌单的创建者可以撤销
+func RevokeShareLink(userID int64, token string) (*ShareLink, error) {
+	var link ShareLink
+	if err := DB.Where("token = ?", token).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	if link.CreatedBy != userID {
+		if link.Kind != ShareKindPlaylist {
+			return nil, ErrPlaylistForbidden
+		}
+		if err := RequirePlaylistRole(link.TargetID, userID, PlaylistRoleOwner); err != nil {
+			return nil, err
+		}
+	}
+	if link.RevokedAt == nil {
+		now := time.Now()
+		if err := DB.Model(&link).Update("revoked_at", now).Error; err != nil {
+			return nil, err
+		}
+		link.RevokedAt = &now
+	}
+	return &link, nil
+}
+
+// 查询可用的分享链接
+func getShareLink(token string, opening bool) (*ShareLink, error) {
+	var link ShareLink
+	if err := DB.Where("token = ?", token).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	if err := link.check(time.Now(), opening); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// 通过分享链接看到的内容，Playlist 与 Music 只有一个不为空
+type ShareView struct {
+	Kind      string     `json:"kind"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	Playlist  *Playlist  `json:"playlist,omitempty"` // 与 GetPlaylistByID 的结构相同
+	Music     *Music     `json:"music,omitempty"`
+}
+
+// 打开分享链接并计入访问次数；播放其中的歌曲不计入
+func OpenShareLink(token string) (*ShareView, error) {
+	link, err := getShareLink(token, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// 条件更新，避免并发访问时超出次数限制
+	now := time.Now()
+	result := DB.Model(&ShareLink{}).
+		Where("id = ? AND (max_access IS NULL OR access_count < max_access)", link.ID).
+		Updates(map[string]any{"access_count": gorm.Expr("access_count + 1"), "last_access_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrShareExhausted
+	}
+
+	view := &ShareView{Kind: link.Kind, ExpiresAt: link.ExpiresAt}
+	switch link.Kind {
+	case ShareKindPlaylist:
+		view.Playlist, err = GetPlaylistByID(link.TargetID)
+	default:
+		view.Music, err = GetMusicByID(link.TargetID)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// 歌单（不在回收站中）是否包含该歌曲；智能歌单的歌曲与 GetPlaylistByID 一样按创建者计算
+func sharedPlaylistContains(playlistID, musicID int64) (bool, error) {
+	var p Playlist
+	if err := DB.First(&p, playlistID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if p.Kind == PlaylistKindSmart {
+		if err := fillSmartPlaylist(&p); err != nil {
+			return false, err
+		}
+		for _, it := range p.Items {
+			if it.MusicID == musicID {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	var count int64
+	err := DB.Model(&PlaylistItem{}).Where("playlist_id = ? AND music_id = ?", playlistID, musicID).Count(&count).Error
+	return count > 0, err
+}
+
+// 通过分享链接播放歌曲：歌曲必须是被分享的歌曲，或在被分享的歌单中
+func GetSharedMusic(token string, musicID int64) (*Music, error) {
+	link, err := getShareLink(token, false)
+	if err != nil {
+		return nil, err
+	}
+	switch link.Kind {
+	case ShareKindPlaylist:
+		ok, err := sharedPlaylistContains(link.TargetID, musicID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrShareNotFound
+		}
+	default:
+		if link.TargetID != musicID {
+			return nil, ErrShareNotFound
+		}
+	}
+	m, err := GetMusicByID(musicID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrShareNotFound
+	}
+	return m, err
+}
@@ -0,0 +1,482 @@
+package core
+
+import (
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	similarTopK          = 50               // 每首歌保存的相似歌曲数
+	similarSessionGap    = 30 * time.Minute // 相邻两次播放间隔超过该值时视为新的收听会话
+	similarSessionTracks = 50               // 单个会话最多统计的歌曲数，避免一整天的循环播放产生过多组合
+	similarDefaultPeriod = 10 * time.Minute
+
+	similarLabelWeight    = 0.4
+	similarPlaylistWeight = 0.3
+	similarSessionWeight  = 0.3
+)
+
+// 预计算的相似歌曲，每首歌保存得分最高的 similarTopK 首
+type MusicSimilarity struct {
+	MusicID       int64     `json:"-" gorm:"primaryKey;column:music_id;autoIncrement:false"`
+	SimilarID     int64     `json:"similarId" gorm:"primaryKey;column:similar_id;autoIncrement:false"`
+	Score         float64   `json:"score" gorm:"column:score;not null;index"`
+	LabelScore    float64   `json:"labelScore" gorm:"column:label_score;not null"`       // 标签 Jaccard 相似度
+	PlaylistScore float64   `json:"playlistScore" gorm:"column:playlist_score;not null"` // 同歌单出现的余弦相似度
+	SessionScore  float64   `json:"sessionScore" gorm:"column:session_score;not null"`   // 同一收听会话出现的余弦相似度
+	UpdatedAt     time.Time `json:"updatedAt" gorm:"column:updated_at"`
+	Music         Music     `json:"music" gorm:"foreignKey:SimilarID;references:Id"`
+}
+
+func (MusicSimilarity) TableName() string {
+	return "music_similarity"
+}
+
+// 两首歌在同一收听会话中出现的次数（MusicA <= MusicB），MusicA == MusicB 时为该歌曲出现过的会话数
+// 按新增的播放记录累加，不必每次重新扫描全部历史
+type MusicColisten struct {
+	MusicA int64 `gorm:"primaryKey;column:music_a;autoIncrement:false"`
+	MusicB int64 `gorm:"primaryKey;column:music_b;autoIncrement:false"`
+	Count  int64 `gorm:"column:count;not null"`
+}
+
+func (MusicColisten) TableName() string {
+	return "music_colisten"
+}
+
+// 相似度计算的进度：已统计到的播放记录 ID
+type SimilarityState struct {
+	ID         int64     `gorm:"primaryKey;column:id"`
+	LastPlayID int64     `gorm:"column:last_play_id;not null"`
+	UpdatedAt  time.Time `gorm:"column:updated_at"`
+}
+
+func (SimilarityState) TableName() string {
+	return "similarity_state"
+}
+
+// ==== 待更新的歌曲 ====
+
+// 标签或歌单发生变化、需要重新计算相似度的歌曲
+var similarityDirty = struct {
+	sync.Mutex
+	ids map[int64]bool
+}{ids: map[int64]bool{}}
+
+// 标记歌曲需要重新计算相似度，由后台任务统一处理
+func MarkSimilarityDirty(ids ...int64) {
+	similarityDirty.Lock()
+	defer similarityDirty.Unlock()
+	for _, id := range ids {
+		similarityDirty.ids[id] = true
+	}
+}
+
+func takeSimilarityDirty() map[int64]bool {
+	similarityDirty.Lock()
+	defer similarityDirty.Unlock()
+	ids := similarityDirty.ids
+	similarityDirty.ids = map[int64]bool{}
+	return ids
+}
+
+// ==== 收听会话统计 ====
+
+type colistenKey struct{ a, b int64 }
+
+func newColistenKey(a, b int64) colistenKey {
+	if a > b {
+		a, b = b, a
+	}
+	return colistenKey{a, b}
+}
+
+// 会话中出现过的歌曲两两组合（包括与自身的组合）
+func sessionPairs(ids []int64) map[colistenKey]bool {
+	pairs := make(map[colistenKey]bool, len(ids)*(len(ids)+1)/2)
+	for i := range ids {
+		for j := i; j < len(ids); j++ {
+			pairs[newColistenKey(ids[i], ids[j])] = true
+		}
+	}
+	return pairs
+}
+
+// 把 lastPlayID 之后的新播放累加到 music_colisten，返回受影响的歌曲
+// 新播放可能和已统计过的播放处于同一会话，因此会把前后的旧播放一起载入，只累加新增的组合
+func updateColisten(tx *gorm.DB, lastPlayID int64) (int64, map[int64]bool, error) {
+	// 先确定本次统计到哪条播放，之后插入的播放留到下次，避免读取 fresh 后插入的播放被跳过
+	var maxID int64
+	if err := tx.Model(&PlayHistory{}).Select("COALESCE(MAX(id), 0)").Scan(&maxID).Error; err != nil {
+		return lastPlayID, nil, err
+	}
+	var fresh []PlayHistory
+	err := tx.Select("id, user_id, music_id, played_at").
+		Where("id > ? AND id <= ? AND (end_reason IS NULL OR end_reason <> ?)", lastPlayID, maxID, PlayEndSkipped).
+		Order("id ASC").Find(&fresh).Error
+	if err != nil {
+		return lastPlayID, nil, err
+	}
+	touched := map[int64]bool{}
+	if len(fresh) == 0 {
+		return max(maxID, lastPlayID), touched, nil
+	}
+
+	// 按用户确定需要重新划分会话的时间范围
+	type span struct{ from, to time.Time }
+	spans := map[int64]*span{}
+	for _, ph := range fresh {
+		s := spans[ph.UserID]
+		if s == nil {
+			spans[ph.UserID] = &span{ph.PlayedAt, ph.PlayedAt}
+			continue
+		}
+		if ph.PlayedAt.Before(s.from) {
+			s.from = ph.PlayedAt
+		}
+		if ph.PlayedAt.After(s.to) {
+			s.to = ph.PlayedAt
+		}
+	}
+
+	delta := map[colistenKey]int64{}
+	for userID, s := range spans {
+		var plays []PlayHistory
+		err := tx.Select("id, music_id, played_at").
+			Where("user_id = ? AND id <= ? AND played_at >= ? AND played_at <= ?", userID, maxID, s.from.Add(-similarSessionGap), s.to.Add(similarSessionGap)).
+			Where("end_reason IS NULL OR end_reason <> ?", PlayEndSkipped).
+			Order("played_at ASC, id ASC").Find(&plays).Error
+		if err != nil {
+			return lastPlayID, nil, err
+		}
+
+		var all, old []int64
+		seenAll, seenOld := map[int64]bool{}, map[int64]bool{}
+		hasNew := false
+		flush := func() {
+			if hasNew {
+				oldPairs := sessionPairs(old)
+				for k := range sessionPairs(all) {
+					if !oldPairs[k] {
+						delta[k]++
+						touched[k.a], touched[k.b] = true, true
+					}
+				}
+			}
+			all, old, hasNew = nil, nil, false
+			seenAll, seenOld = map[int64]bool{}, map[int64]bool{}
+		}
+		for i, ph := range plays {
+			if i > 0 && (ph.PlayedAt.Sub(plays[i-1].PlayedAt) > similarSessionGap || len(all) >= similarSessionTracks) {
+				flush()
+			}
+			if !seenAll[ph.MusicID] {
+				seenAll[ph.MusicID] = true
+				all = append(all, ph.MusicID)
+			}
+			if ph.ID <= lastPlayID {
+				if !seenOld[ph.MusicID] {
+					seenOld[ph.MusicID] = true
+					old = append(old, ph.MusicID)
+				}
+			} else {
+				hasNew = true
+			}
+		}
+		flush()
+	}
+
+	rows := make([]MusicColisten, 0, len(delta))
+	for k, n := range delta {
+		rows = append(rows, MusicColisten{MusicA: k.a, MusicB: k.b, Count: n})
+	}
+	if len(rows) > 0 {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "music_a"}, {Name: "music_b"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("music_colisten.count + excluded.count")}),
+		}).CreateInBatches(rows, 500).Error
+		if err != nil {
+			return lastPlayID, nil, err
+		}
+	}
+	return maxID, touched, nil
+}
+
+// ==== 相似度计算 ====
+
+// 计算相似度所需的全部数据
+type similarityData struct {
+	songs          []Music
+	tokens         map[int64]map[string]bool // 标签集合，delabels 以 "!" 前缀区分
+	trackPlaylists map[int64][]int64
+	playlistTracks map[int64][]int64
+	colisten       map[colistenKey]int64
+}
+
+func loadSimilarityData(tx *gorm.DB) (*similarityData, error) {
+	d := &similarityData{
+		tokens:         map[int64]map[string]bool{},
+		trackPlaylists: map[int64][]int64{},
+		playlistTracks: map[int64][]int64{},
+		colisten:       map[colistenKey]int64{},
+	}
+	if err := tx.Find(&d.songs).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range d.songs {
+		t := make(map[string]bool, len(m.Labels)+len(m.DeLabels))
+		for _, l := range m.Labels {
+			t[l] = true
+		}
+		for _, l := range m.DeLabels {
+			t["!"+l] = true
+		}
+		d.tokens[m.Id] = t
+	}
+
+	// 只统计用户歌单，系统生成的歌单本身就来自推荐结果
+	var items []PlaylistItem
+	err := tx.Select("playlist_music.playlist_id, playlist_music.music_id").
+		Joins("JOIN playlists ON playlists.id = playlist_music.playlist_id").
+		Where("playlists.kind IN ? AND playlists.deleted_at IS NULL", []string{PlaylistKindUser, PlaylistKindFavorites}).
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range items {
+		d.trackPlaylists[it.MusicID] = append(d.trackPlaylists[it.MusicID], it.PlaylistID)
+		d.playlistTracks[it.PlaylistID] = append(d.playlistTracks[it.PlaylistID], it.MusicID)
+	}
+
+	var rows []MusicColisten
+	if err := tx.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		d.colisten[colistenKey{r.MusicA, r.MusicB}] = r.Count
+	}
+	return d, nil
+}
+
+// 计算一首歌与曲库中其他歌曲的相似度，按得分倒序
+func (d *similarityData) similarTo(id int64) []MusicSimilarity {
+	coPlaylist := map[int64]int{}
+	for _, pid := range d.trackPlaylists[id] {
+		for _, other := range d.playlistTracks[pid] {
+			coPlaylist[other]++
+		}
+	}
+	self := d.tokens[id]
+	selfSessions := d.colisten[colistenKey{id, id}]
+	now := time.Now()
+
+	var out []MusicSimilarity
+	for _, m := range d.songs {
+		if m.Id == id {
+			continue
+		}
+		s := MusicSimilarity{MusicID: id, SimilarID: m.Id, UpdatedAt: now}
+
+		if other := d.tokens[m.Id]; len(self) > 0 && len(other) > 0 {
+			inter := 0
+			for t := range self {
+				if other[t] {
+					inter++
+				}
+			}
+			s.LabelScore = float64(inter) / float64(len(self)+len(other)-inter)
+		}
+		if co := coPlaylist[m.Id]; co > 0 {
+			s.PlaylistScore = float64(co) / math.Sqrt(float64(len(d.trackPlaylists[id])*len(d.trackPlaylists[m.Id])))
+		}
+		if co := d.colisten[newColistenKey(id, m.Id)]; co > 0 && selfSessions > 0 {
+			if n := d.colisten[colistenKey{m.Id, m.Id}]; n > 0 {
+				s.SessionScore = math.Min(1, float64(co)/math.Sqrt(float64(selfSessions*n)))
+			}
+		}
+
+		s.Score = similarLabelWeight*s.LabelScore + similarPlaylistWeight*s.PlaylistScore + similarSessionWeight*s.SessionScore
+		if s.Score > 0 {
+			out = append(out, s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].SimilarID < out[j].SimilarID
+	})
+	return out
+}
+
+// 串行化相似度的刷新，避免后台任务和手动刷新同时累加同一批收听会话
+// 刷新在单个事务中写入，查询只会读到已提交的结果，因此 GetSimilarMusic 不需要加锁
+var similarityMu sync.Mutex
+
+// 刷新相似歌曲：累加新的收听会话，并重新计算受影响的歌曲
+// full 为 true 时重新统计全部播放记录并重算整个曲库
+func RefreshSimilarity(full bool) error {
+	similarityMu.Lock()
+	defer similarityMu.Unlock()
+
+	dirty := takeSimilarityDirty()
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var state SimilarityState
+		if err := tx.FirstOrCreate(&state, SimilarityState{ID: 1}).Error; err != nil {
+			return err
+		}
+		if full {
+			if err := tx.Where("1 = 1").Delete(&MusicColisten{}).Error; err != nil {
+				return err
+			}
+			state.LastPlayID = 0
+		}
+
+		lastPlayID, touched, err := updateColisten(tx, state.LastPlayID)
+		if err != nil {
+			return err
+		}
+		for id := range touched {
+			dirty[id] = true
+		}
+
+		d, err := loadSimilarityData(tx)
+		if err != nil {
+			return err
+		}
+
+		// 还没有计算过的歌曲（如新入库的歌曲）
+		var computed []int64
+		if err := tx.Model(&MusicSimilarity{}).Distinct().Pluck("music_id", &computed).Error; err != nil {
+			return err
+		}
+		hasRows := make(map[int64]bool, len(computed))
+		for _, id := range computed {
+			hasRows[id] = true
+		}
+		for _, m := range d.songs {
+			if full || !hasRows[m.Id] {
+				dirty[m.Id] = true
+			}
+		}
+
+		// 相似度是对称的：变化的歌曲会影响与它相关的每一首歌的排行，包括之前把它列为相似歌曲的歌曲
+		affected := map[int64][]MusicSimilarity{}
+		if !full && len(dirty) > 0 {
+			var referrers []int64
+			if err := tx.Model(&MusicSimilarity{}).Where("similar_id IN ?", mapKeys(dirty)).Distinct().Pluck("music_id", &referrers).Error; err != nil {
+				return err
+			}
+			for _, id := range referrers {
+				affected[id] = nil
+			}
+		}
+		for id := range dirty {
+			list := d.similarTo(id)
+			affected[id] = list
+			if full {
+				continue
+			}
+			for _, s := range list {
+				if _, ok := affected[s.SimilarID]; !ok {
+					affected[s.SimilarID] = nil
+				}
+			}
+		}
+
+		ids := make([]int64, 0, len(affected))
+		var rows []MusicSimilarity
+		for id, list := range affected {
+			if list == nil {
+				list = d.similarTo(id)
+			}
+			if len(list) > similarTopK {
+				list = list[:similarTopK]
+			}
+			ids = append(ids, id)
+			rows = append(rows, list...)
+		}
+
+		if len(ids) > 0 {
+			if err := tx.Where("music_id IN ? OR similar_id NOT IN (?)", ids, tx.Model(&Music{}).Select("id")).Delete(&MusicSimilarity{}).Error; err != nil {
+				return err
+			}
+		}
+		if len(rows) > 0 {
+			if err := tx.Omit("Music").CreateInBatches(rows, 500).Error; err != nil {
+				return err
+			}
+		}
+
+		state.LastPlayID = lastPlayID
+		state.UpdatedAt = time.Now()
+		return tx.Save(&state).Error
+	})
+	if err != nil {
+		// 保留未处理的歌曲，下次再试
+		MarkSimilarityDirty(mapKeys(dirty)...)
+	}
+	return err
+}
+
+func mapKeys(m map[int64]bool) []int64 {
+	out := make([]int64, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+// 查询相似歌曲；还没有预计算结果的歌曲（如刚入库）即时计算
+func GetSimilarMusic(musicID int64, limit int) ([]MusicSimilarity, error) {
+	list := []MusicSimilarity{}
+	err := DB.Preload("Music").Where("music_id = ?", musicID).Order("score DESC, similar_id ASC").Limit(limit).Find(&list).Error
+	if err != nil || len(list) > 0 {
+		return list, err
+	}
+
+	d, err := loadSimilarityData(DB)
+	if err != nil {
+		return nil, err
+	}
+	list = d.similarTo(musicID)
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	byID := make(map[int64]Music, len(d.songs))
+	for _, m := range d.songs {
+		byID[m.Id] = m
+	}
+	for i := range list {
+		list[i].Music = byID[list[i].SimilarID]
+	}
+	MarkSimilarityDirty(musicID)
+	return list, nil
+}
+
+// 启动相似歌曲的后台计算，间隔由 SIMILARITY_INTERVAL（秒）配置，默认 10 分钟
+func StartSimilarityWorker() {
+	interval := similarDefaultPeriod
+	if v, err := strconv.Atoi(os.Getenv("SIMILARITY_INTERVAL")); err == nil && v > 0 {
+		interval = time.Duration(v) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := RefreshSimilarity(false); err != nil {
+				log.Printf("similarity: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
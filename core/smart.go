@@ -0,0 +1,644 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 智能歌单规则中的字段
+const (
+	SmartFieldTitle      = "title"
+	SmartFieldSinger     = "singer"
+	SmartFieldAlbum      = "album"
+	SmartFieldLabel      = "label"      // 歌曲的标签
+	SmartFieldDuration   = "durationMs" // 时长（毫秒）
+	SmartFieldPlays      = "plays"      // 播放次数，可用 days 限定最近几天
+	SmartFieldLastPlayed = "lastPlayed" // 最近一次播放距今的天数，没有播放过时不满足任何比较
+	SmartFieldStarred    = "starred"    // 是否收藏
+	SmartFieldLiked      = "liked"      // 是否喜欢
+	SmartFieldDisliked   = "disliked"   // 是否不喜欢
+)
+
+// 智能歌单规则中的比较方式
+const (
+	SmartOpEq         = "eq"
+	SmartOpNeq        = "neq"
+	SmartOpGt         = "gt"
+	SmartOpGte        = "gte"
+	SmartOpLt         = "lt"
+	SmartOpLte        = "lte"
+	SmartOpContains   = "contains"   // 文本包含，不区分大小写
+	SmartOpStartsWith = "startsWith" // 文本前缀，不区分大小写
+	SmartOpHas        = "has"        // 标签包含
+)
+
+const (
+	smartMaxDepth = 8
+	smartMaxRules = 100
+	smartMaxLimit = 1000
+)
+
+var ErrInvalidSmartRule = errors.New("invalid smart playlist rule")
+
+// 智能歌单的规则树：all / any / not 组合子规则，否则为一个条件
+//
+//	{"all": [
+//	  {"field": "label", "op": "has", "value": "Rock"},
+//	  {"field": "plays", "op": "gt", "value": 5, "days": 30},
+//	  {"not": {"field": "plays", "op": "gt", "value": 0, "days": 7}}
+//	]}
+type SmartRule struct {
+	All []SmartRule `json:"all,omitempty"`
+	Any []SmartRule `json:"any,omitempty"`
+	Not *SmartRule  `json:"not,omitempty"`
+
+	Field string `json:"field,omitempty"`
+	Op    string `json:"op,omitempty"`
+	Value any    `json:"value"`          // 不能 omitempty，否则 false 会丢失
+	Days  int    `json:"days,omitempty"` // plays：只统计最近几天的播放
+}
+
+// 智能歌单的定义，保存在 Playlist.Smart 中
+type SmartPlaylistDef struct {
+	Rule  SmartRule `json:"rule"`
+	Sort  string    `json:"sort,omitempty"`  // 见 smartSortKeys，前缀 - 表示倒序；random 每天打乱一次
+	Limit int       `json:"limit,omitempty"` // 最多歌曲数，0 表示不限
+}
+
+var smartSortKeys = map[string]bool{
+	"title": true, "singer": true, "album": true, "durationMs": true,
+	"plays": true, "lastPlayed": true, "added": true, "random": true,
+}
+
+// ==== 校验 ====
+
+func (d *SmartPlaylistDef) validate() error {
+	count := 0
+	if err := d.Rule.validate("rule", 0, &count); err != nil {
+		return err
+	}
+	if key := strings.TrimPrefix(d.Sort, "-"); key != "" && !smartSortKeys[key] {
+		return fmt.Errorf("%w: unknown sort %q", ErrInvalidSmartRule, d.Sort)
+	}
+	if d.Limit < 0 || d.Limit > smartMaxLimit {
+		return fmt.Errorf("%w: limit must be between 0 and %d", ErrInvalidSmartRule, smartMaxLimit)
+	}
+	return nil
+}
+
+func (r *SmartRule) validate(path string, depth int, count *int) error {
+	*count++
+	if *count > smartMaxRules {
+		return fmt.Errorf("%w: more than %d rules", ErrInvalidSmartRule, smartMaxRules)
+	}
+	if depth > smartMaxDepth {
+		return fmt.Errorf("%w: %s: nested too deeply", ErrInvalidSmartRule, path)
+	}
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidSmartRule, path, fmt.Sprintf(format, args...))
+	}
+
+	kinds := 0
+	for _, set := range []bool{len(r.All) > 0, len(r.Any) > 0, r.Not != nil, r.Field != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return invalid("exactly one of all, any, not or field is required")
+	}
+	for i := range r.All {
+		if err := r.All[i].validate(fmt.Sprintf("%s.all[%d]", path, i), depth+1, count); err != nil {
+			return err
+		}
+	}
+	for i := range r.Any {
+		if err := r.Any[i].validate(fmt.Sprintf("%s.any[%d]", path, i), depth+1, count); err != nil {
+			return err
+		}
+	}
+	if r.Not != nil {
+		return r.Not.validate(path+".not", depth+1, count)
+	}
+	if r.Field == "" {
+		return nil
+	}
+
+	if r.Days != 0 && (r.Field != SmartFieldPlays || r.Days < 0) {
+		return invalid("days only applies to plays and must be positive")
+	}
+	switch r.Field {
+	case SmartFieldTitle, SmartFieldSinger, SmartFieldAlbum:
+		if _, ok := r.Value.(string); !ok {
+			return invalid("value must be a string")
+		}
+		switch r.Op {
+		case SmartOpEq, SmartOpNeq, SmartOpContains, SmartOpStartsWith:
+		default:
+			return invalid("unsupported op %q for %s", r.Op, r.Field)
+		}
+	case SmartFieldLabel:
+		if _, ok := r.Value.(string); !ok {
+			return invalid("value must be a string")
+		}
+		if r.Op != SmartOpHas {
+			return invalid("unsupported op %q for %s", r.Op, r.Field)
+		}
+	case SmartFieldDuration, SmartFieldPlays, SmartFieldLastPlayed:
+		if _, ok := r.Value.(float64); !ok {
+			return invalid("value must be a number")
+		}
+		switch r.Op {
+		case SmartOpEq, SmartOpNeq, SmartOpGt, SmartOpGte, SmartOpLt, SmartOpLte:
+		default:
+			return invalid("unsupported op %q for %s", r.Op, r.Field)
+		}
+	case SmartFieldStarred, SmartFieldLiked, SmartFieldDisliked:
+		if _, ok := r.Value.(bool); !ok {
+			return invalid("value must be true or false")
+		}
+		if r.Op != SmartOpEq {
+			return invalid("unsupported op %q for %s", r.Op, r.Field)
+		}
+	default:
+		return invalid("unknown field %q", r.Field)
+	}
+	return nil
+}
+
+// 依次访问规则树中的每个条件
+func (r *SmartRule) walk(fn func(*SmartRule)) {
+	if r.Field != "" {
+		fn(r)
+	}
+	for i := range r.All {
+		r.All[i].walk(fn)
+	}
+	for i := range r.Any {
+		r.Any[i].walk(fn)
+	}
+	if r.Not != nil {
+		r.Not.walk(fn)
+	}
+}
+
+// ==== 求值 ====
+
+// 求值所需的用户数据
+type smartContext struct {
+	now        time.Time
+	plays      map[int]map[int64]int // 窗口天数 -> 歌曲 -> 播放次数
+	lastPlayed map[int64]time.Time
+	starred    map[int64]bool
+	feedback   map[int64]int
+}
+
+func loadSmartContext(userID int64, def *SmartPlaylistDef, now time.Time) (*smartContext, error) {
+	ctx := &smartContext{
+		now:        now,
+		plays:      map[int]map[int64]int{},
+		lastPlayed: map[int64]time.Time{},
+		starred:    map[int64]bool{},
+	}
+	sortKey := strings.TrimPrefix(def.Sort, "-")
+	windows := map[int]bool{}
+	needLast := sortKey == "lastPlayed"
+	if sortKey == "plays" {
+		windows[0] = true
+	}
+	def.Rule.walk(func(r *SmartRule) {
+		switch r.Field {
+		case SmartFieldPlays:
+			windows[r.Days] = true
+		case SmartFieldLastPlayed:
+			needLast = true
+		}
+	})
+	if len(windows) > 0 || needLast {
+		if err := loadSmartPlays(ctx, userID, windows, needLast); err != nil {
+			return nil, err
+		}
+	}
+
+	starred, err := starredMusicIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range starred {
+		ctx.starred[id] = true
+	}
+	if ctx.feedback, err = GetMusicFeedback(userID); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// 在数据库中按歌曲分组统计播放次数，每个窗口一列；
+// 规则只用到最近几天的播放时只扫描最大窗口内的记录
+func loadSmartPlays(ctx *smartContext, userID int64, windows map[int]bool, needLast bool) error {
+	days := make([]int, 0, len(windows))
+	for d := range windows {
+		days = append(days, d)
+		ctx.plays[d] = map[int64]int{}
+	}
+	sort.Ints(days)
+
+	cols := []string{"music_id", "MAX(played_at) AS last_played"}
+	var args []any
+	for i, d := range days {
+		if d == 0 {
+			cols = append(cols, fmt.Sprintf("COUNT(*) AS plays_%d", i))
+			continue
+		}
+		cols = append(cols, fmt.Sprintf("COUNT(CASE WHEN played_at > ? THEN 1 END) AS plays_%d", i))
+		args = append(args, ctx.now.AddDate(0, 0, -d))
+	}
+	q := DB.Model(&PlayHistory{}).Select(strings.Join(cols, ", "), args...).Where("user_id = ?", userID)
+	if !needLast && days[0] > 0 {
+		q = q.Where("played_at > ?", ctx.now.AddDate(0, 0, -days[len(days)-1]))
+	}
+	rows, err := q.Group("music_id").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var musicID int64
+	var last dbTime
+	counts := make([]int64, len(days))
+	dest := []any{&musicID, &last}
+	for i := range counts {
+		dest = append(dest, &counts[i])
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if needLast {
+			ctx.lastPlayed[musicID] = last.Time
+		}
+		for i, d := range days {
+			if counts[i] > 0 {
+				ctx.plays[d][musicID] = int(counts[i])
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// 能满足规则的歌曲范围：只有播放过、收藏或标记过的歌曲才可能满足时返回这些歌曲，
+// 否则 ok 为 false，需要检查整个曲库
+func (r *SmartRule) candidates(ctx *smartContext) (ids map[int64]bool, ok bool) {
+	switch {
+	case len(r.All) > 0:
+		for i := range r.All {
+			sub, subOK := r.All[i].candidates(ctx)
+			if !subOK {
+				continue
+			}
+			if !ok {
+				ids, ok = sub, true
+				continue
+			}
+			for id := range ids {
+				if !sub[id] {
+					delete(ids, id)
+				}
+			}
+		}
+		return ids, ok
+	case len(r.Any) > 0:
+		ids = map[int64]bool{}
+		for i := range r.Any {
+			sub, subOK := r.Any[i].candidates(ctx)
+			if !subOK {
+				return nil, false
+			}
+			for id := range sub {
+				ids[id] = true
+			}
+		}
+		return ids, true
+	case r.Not != nil:
+		return nil, false
+	}
+
+	ids = map[int64]bool{}
+	switch r.Field {
+	case SmartFieldPlays:
+		if compareNumber(r.Op, 0, r.number()) {
+			return nil, false // 没播放过的歌曲也满足
+		}
+		for id := range ctx.plays[r.Days] {
+			ids[id] = true
+		}
+	case SmartFieldLastPlayed:
+		for id := range ctx.lastPlayed {
+			ids[id] = true
+		}
+	case SmartFieldStarred:
+		if !r.flag() {
+			return nil, false
+		}
+		for id := range ctx.starred {
+			ids[id] = true
+		}
+	case SmartFieldLiked, SmartFieldDisliked:
+		if !r.flag() {
+			return nil, false
+		}
+		want := FeedbackLike
+		if r.Field == SmartFieldDisliked {
+			want = FeedbackDislike
+		}
+		for id, v := range ctx.feedback {
+			if v == want {
+				ids[id] = true
+			}
+		}
+	default:
+		return nil, false
+	}
+	return ids, true
+}
+
+// 取可能满足规则的歌曲，规则不能缩小范围时读取整个曲库
+func smartCandidateSongs(def *SmartPlaylistDef, ctx *smartContext) ([]Music, error) {
+	set, ok := def.Rule.candidates(ctx)
+	if !ok {
+		return GetAllSongs()
+	}
+	ids := make([]int64, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	songs := []Music{}
+	for start := 0; start < len(ids); start += 500 {
+		end := min(start+500, len(ids))
+		var batch []Music
+		if err := DB.Where("id IN ?", ids[start:end]).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		songs = append(songs, batch...)
+	}
+	return songs, nil
+}
+
+func compareNumber(op string, a, b float64) bool {
+	switch op {
+	case SmartOpEq:
+		return a == b
+	case SmartOpNeq:
+		return a != b
+	case SmartOpGt:
+		return a > b
+	case SmartOpGte:
+		return a >= b
+	case SmartOpLt:
+		return a < b
+	case SmartOpLte:
+		return a <= b
+	}
+	return false
+}
+
+func compareText(op, a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	switch op {
+	case SmartOpEq:
+		return a == b
+	case SmartOpNeq:
+		return a != b
+	case SmartOpContains:
+		return strings.Contains(a, b)
+	case SmartOpStartsWith:
+		return strings.HasPrefix(a, b)
+	}
+	return false
+}
+
+// 条件的值，已在 validate 中检查过类型
+func (r *SmartRule) text() string {
+	v, _ := r.Value.(string)
+	return v
+}
+
+func (r *SmartRule) number() float64 {
+	v, _ := r.Value.(float64)
+	return v
+}
+
+func (r *SmartRule) flag() bool {
+	v, _ := r.Value.(bool)
+	return v
+}
+
+func (r *SmartRule) match(m Music, ctx *smartContext) bool {
+	switch {
+	case len(r.All) > 0:
+		for i := range r.All {
+			if !r.All[i].match(m, ctx) {
+				return false
+			}
+		}
+		return true
+	case len(r.Any) > 0:
+		for i := range r.Any {
+			if r.Any[i].match(m, ctx) {
+				return true
+			}
+		}
+		return false
+	case r.Not != nil:
+		return !r.Not.match(m, ctx)
+	}
+
+	switch r.Field {
+	case SmartFieldTitle:
+		return compareText(r.Op, m.Title, r.text())
+	case SmartFieldSinger:
+		return compareText(r.Op, m.Singer, r.text())
+	case SmartFieldAlbum:
+		return compareText(r.Op, m.Album, r.text())
+	case SmartFieldLabel:
+		for _, l := range m.Labels {
+			if strings.EqualFold(l, r.text()) {
+				return true
+			}
+		}
+		return false
+	case SmartFieldDuration:
+		return compareNumber(r.Op, float64(m.DurationMs), r.number())
+	case SmartFieldPlays:
+		return compareNumber(r.Op, float64(ctx.plays[r.Days][m.Id]), r.number())
+	case SmartFieldLastPlayed:
+		last, ok := ctx.lastPlayed[m.Id]
+		if !ok {
+			return false
+		}
+		return compareNumber(r.Op, ctx.now.Sub(last).Hours()/24, r.number())
+	case SmartFieldStarred:
+		return ctx.starred[m.Id] == r.flag()
+	case SmartFieldLiked:
+		return (ctx.feedback[m.Id] == FeedbackLike) == r.flag()
+	case SmartFieldDisliked:
+		return (ctx.feedback[m.Id] == FeedbackDislike) == r.flag()
+	}
+	return false
+}
+
+// 按定义筛选、排序并截取歌曲；seed 用于 random 排序，同一天内结果稳定
+func evaluateSmartPlaylist(userID int64, def *SmartPlaylistDef, seed int64) ([]Music, error) {
+	now := time.Now()
+	ctx, err := loadSmartContext(userID, def, now)
+	if err != nil {
+		return nil, err
+	}
+	songs, err := smartCandidateSongs(def, ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := []Music{}
+	for _, m := range songs {
+		if def.Rule.match(m, ctx) {
+			out = append(out, m)
+		}
+	}
+
+	desc := strings.HasPrefix(def.Sort, "-")
+	key := strings.TrimPrefix(def.Sort, "-")
+	if key == "random" {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d:%s", seed, now.Format("2006-01-02"))
+		sort.Slice(out, func(i, j int) bool { return out[i].Id < out[j].Id })
+		rand.New(rand.NewSource(int64(h.Sum64()))).Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	} else {
+		less := func(a, b Music) int {
+			switch key {
+			case "title":
+				return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+			case "singer":
+				return strings.Compare(strings.ToLower(a.Singer), strings.ToLower(b.Singer))
+			case "album":
+				return strings.Compare(strings.ToLower(a.Album), strings.ToLower(b.Album))
+			case "durationMs":
+				return compareInt64(a.DurationMs, b.DurationMs)
+			case "plays":
+				return compareInt64(int64(ctx.plays[0][a.Id]), int64(ctx.plays[0][b.Id]))
+			case "lastPlayed":
+				return ctx.lastPlayed[a.Id].Compare(ctx.lastPlayed[b.Id])
+			}
+			return 0
+		}
+		sort.SliceStable(out, func(i, j int) bool {
+			c := less(out[i], out[j])
+			if c == 0 {
+				c = compareInt64(out[i].Id, out[j].Id) // 默认及相同时按加入曲库的先后
+			}
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		})
+	}
+
+	if def.Limit > 0 && len(out) > def.Limit {
+		out = out[:def.Limit]
+	}
+	return out, nil
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// 填充智能歌单的歌曲（GetPlaylistByID 调用）
+func fillSmartPlaylist(p *Playlist) error {
+	if p.Smart == nil {
+		p.Items = []PlaylistItem{}
+		return nil
+	}
+	songs, err := evaluateSmartPlaylist(p.OwnerUserID, p.Smart, p.ID)
+	if err != nil {
+		return err
+	}
+	p.Items = make([]PlaylistItem, 0, len(songs))
+	for i, m := range songs {
+		p.Items = append(p.Items, PlaylistItem{PlaylistID: p.ID, MusicID: m.Id, TrackOrder: i + 1, Music: m})
+	}
+	return nil
+}
+
+// ==== 创建与修改 ====
+
+// 新建智能歌单，userID 为创建者，歌曲按创建者的播放记录和反馈计算
+func CreateSmartPlaylist(userID int64, name, description string, def SmartPlaylistDef) (*Playlist, error) {
+	if err := def.validate(); err != nil {
+		return nil, err
+	}
+	p := Playlist{Name: name, Description: description, OwnerUserID: userID, Kind: PlaylistKindSmart, Smart: &def}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&p).Error; err != nil {
+			return err
+		}
+		return recordPlaylistActivity(tx, &PlaylistActivity{PlaylistID: p.ID, UserID: userID, Action: PlaylistActionCreate})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetPlaylistByID(p.ID)
+}
+
+// 修改智能歌单的名称、描述和规则，userID 为操作者
+func UpdateSmartPlaylist(playlistID, userID int64, name, description string, def SmartPlaylistDef) (*Playlist, error) {
+	if err := def.validate(); err != nil {
+		return nil, err
+	}
+	activity := &PlaylistActivity{PlaylistID: playlistID, UserID: userID, Action: PlaylistActionRules}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var p Playlist
+		if err := tx.First(&p, playlistID).Error; err != nil {
+			return ErrPlaylistNotFound
+		}
+		if p.Kind != PlaylistKindSmart {
+			return fmt.Errorf("%w: not a smart playlist", ErrInvalidSmartRule)
+		}
+		if name != "" {
+			p.Name = name
+		}
+		if description != "" {
+			p.Description = description
+		}
+		p.Smart = &def
+		if err := tx.Model(&p).Select("name", "description", "smart_rule").Updates(&p).Error; err != nil {
+			return err
+		}
+		return recordPlaylistActivity(tx, activity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	broadcastPlaylistActivity(activity, nil)
+	return GetPlaylistByID(playlistID)
+}
+
+// 预览规则的结果，不保存
+func PreviewSmartPlaylist(userID int64, def SmartPlaylistDef) ([]Music, error) {
+	if err := def.validate(); err != nil {
+		return nil, err
+	}
+	return evaluateSmartPlaylist(userID, &def, 0)
+}
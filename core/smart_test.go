@@ -0,0 +1,118 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSmartRuleCandidates(t *testing.T) {
+	ctx := &smartContext{
+		now: time.Now(),
+		plays: map[int]map[int64]int{
+			0: {1: 5, 2: 1, 3: 2},
+			7: {1: 2},
+		},
+		lastPlayed: map[int64]time.Time{1: time.Now(), 2: time.Now(), 3: time.Now()},
+		starred:    map[int64]bool{3: true, 4: true},
+		feedback:   map[int64]int{2: FeedbackLike, 5: FeedbackDislike},
+	}
+	tests := []struct {
+		name string
+		rule string
+		want []int64 // nil 表示需要检查整个曲库
+	}{
+		{"text", `{"field":"title","op":"contains","value":"a"}`, nil},
+		{"played", `{"field":"plays","op":"gt","value":0}`, []int64{1, 2, 3}},
+		{"played recently", `{"field":"plays","op":"gte","value":1,"days":7}`, []int64{1}},
+		{"zero plays match", `{"field":"plays","op":"lt","value":3}`, nil},
+		{"last played", `{"field":"lastPlayed","op":"gt","value":30}`, []int64{1, 2, 3}},
+		{"starred", `{"field":"starred","op":"eq","value":true}`, []int64{3, 4}},
+		{"not starred", `{"field":"starred","op":"eq","value":false}`, nil},
+		{"liked", `{"field":"liked","op":"eq","value":true}`, []int64{2}},
+		{"disliked", `{"field":"disliked","op":"eq","value":true}`, []int64{5}},
+		{"all intersects", `{"all":[{"field":"plays","op":"gt","value":0},{"field":"starred","op":"eq","value":true},{"field":"album","op":"eq","value":"x"}]}`, []int64{3}},
+		{"all unrestricted", `{"all":[{"field":"album","op":"eq","value":"x"}]}`, nil},
+		{"any unites", `{"any":[{"field":"liked","op":"eq","value":true},{"field":"starred","op":"eq","value":true}]}`, []int64{2, 3, 4}},
+		{"any with unrestricted", `{"any":[{"field":"liked","op":"eq","value":true},{"field":"singer","op":"eq","value":"x"}]}`, nil},
+		{"not", `{"not":{"field":"plays","op":"gt","value":0}}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r SmartRule
+			if err := json.Unmarshal([]byte(tt.rule), &r); err != nil {
+				t.Fatal(err)
+			}
+			set, ok := r.candidates(ctx)
+			if ok != (tt.want != nil) {
+				t.Fatalf("ok = %v, want %v", ok, tt.want != nil)
+			}
+			if !ok {
+				return
+			}
+			got := []int64{}
+			for id := range set {
+				got = append(got, id)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("candidates = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSmartPlays(t *testing.T) {
+	openTestRepo(t)
+	now := time.Now()
+	plays := []PlayHistory{
+		{UserID: 1, MusicID: 1, PlayedAt: now.AddDate(0, 0, -1)},
+		{UserID: 1, MusicID: 1, PlayedAt: now.AddDate(0, 0, -10)},
+		{UserID: 1, MusicID: 1, PlayedAt: now.AddDate(0, 0, -100)},
+		{UserID: 1, MusicID: 2, PlayedAt: now.AddDate(0, 0, -20)},
+		{UserID: 2, MusicID: 3, PlayedAt: now.AddDate(0, 0, -1)},
+	}
+	if err := DB.Create(&plays).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		windows  []int
+		needLast bool
+		want     map[int]map[int64]int
+		wantLast []int64
+	}{
+		{"all time", []int{0}, false, map[int]map[int64]int{0: {1: 3, 2: 1}}, []int64{}},
+		{"recent windows", []int{7, 30}, false, map[int]map[int64]int{7: {1: 1}, 30: {1: 2, 2: 1}}, []int64{}},
+		{"last played", []int{7}, true, map[int]map[int64]int{7: {1: 1}}, []int64{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &smartContext{now: now, plays: map[int]map[int64]int{}, lastPlayed: map[int64]time.Time{}}
+			windows := map[int]bool{}
+			for _, d := range tt.windows {
+				windows[d] = true
+			}
+			if err := loadSmartPlays(ctx, 1, windows, tt.needLast); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ctx.plays, tt.want) {
+				t.Errorf("plays = %v, want %v", ctx.plays, tt.want)
+			}
+			last := []int64{}
+			for id := range ctx.lastPlayed {
+				last = append(last, id)
+			}
+			sort.Slice(last, func(i, j int) bool { return last[i] < last[j] })
+			if !reflect.DeepEqual(last, tt.wantLast) {
+				t.Errorf("lastPlayed songs = %v, want %v", last, tt.wantLast)
+			}
+			if tt.needLast && !ctx.lastPlayed[1].Equal(plays[0].PlayedAt) {
+				t.Errorf("lastPlayed[1] = %v, want %v", ctx.lastPlayed[1], plays[0].PlayedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// SQLite 后端，适合单用户、离线安装和 CI：SQLite 没有数组类型，
+// 歌曲的标签保存在 music_labels 连接表中，由 gorm 回调在读写 Music 时同步，调用方仍然使用 Music.Labels / DeLabels
+type sqliteRepository struct {
+	gormRepository
+}
+
+// 歌曲标签连接表，Negative 为 true 表示“不适合”标签（Music.DeLabels）
+type MusicLabel struct {
+	MusicID  int64  `gorm:"primaryKey;autoIncrement:false;column:music_id"`
+	Negative bool   `gorm:"primaryKey;column:negative"`
+	Label    string `gorm:"primaryKey;column:label;type:varchar(64);index"`
+	Position int    `gorm:"column:position;not null"` // 标签在 Labels / DeLabels 中的顺序
+}
+
+func (MusicLabel) TableName() string {
+	return "music_labels"
+}
+
+// 一次读取标签时 IN 中的最多歌曲数，低于 SQLite 的参数个数限制
+const musicLabelBatchSize = 500
+
+var musicType = reflect.TypeOf(Music{})
+
+// 打开 SQLite 数据库，path 为文件路径或 :memory:，可以带 ?参数
+func openSQLite(path string) (*sqliteRepository, error) {
+	if path == "" {
+		return nil, fmt.Errorf("%w: sqlite database path is empty", ErrUnsupportedDatabaseURL)
+	}
+	memory := strings.HasPrefix(path, ":memory:") || strings.Contains(path, "mode=memory")
+	if strings.HasPrefix(path, ":memory:") {
+		path = "file::memory:?cache=shared" + strings.Replace(strings.TrimPrefix(path, ":memory:"), "?", "&", 1)
+	}
+	if !memory && !strings.HasPrefix(path, "file:") {
+		if dir := filepath.Dir(strings.SplitN(path, "?", 2)[0]); dir != "." {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 等待其他连接释放写锁；事务一开始就拿写锁，避免读锁升级时死锁
+	// key 已出现在 path 中时保留调用方的设置
+	params := []struct{ key, param string }{
+		{"busy_timeout", "_pragma=busy_timeout(5000)"},
+		{"_txlock", "_txlock=immediate"},
+	}
+	if !memory {
+		params = append(params, struct{ key, param string }{"journal_mode", "_pragma=journal_mode(WAL)"})
+	}
+	for _, p := range params {
+		if strings.Contains(path, p.key) {
+			continue
+		}
+		if strings.Contains(path, "?") {
+			path += "&" + p.param
+		} else {
+			path += "?" + p.param
+		}
+	}
+
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if memory {
+		// 内存数据库在最后一个连接关闭时就会丢失，只使用一个常驻连接
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+		sqlDB.SetConnMaxIdleTime(0)
+	}
+	if err := sqlDB.Ping(); err != nil {
+		return nil, err
+	}
+	if err := registerMusicLabelCallbacks(db); err != nil {
+		return nil, err
+	}
+	return &sqliteRepository{gormRepository{db: db}}, nil
+}
+
+func (r *sqliteRepository) Backend() string {
+	return BackendSQLite
+}
+
+func (r *sqliteRepository) Migrate(models ...interface{}) error {
+	return r.db.AutoMigrate(append(models, &MusicLabel{})...)
+}
+
+func (r *sqliteRepository) SearchMusicByLabels(labels []string, limit int) ([]Music, error) {
+	var songs []Music
+	err := r.db.Where("id IN (?)",
+		r.db.Model(&MusicLabel{}).Select("music_id").Where("negative = ? AND label IN ?", false, labels),
+	).Limit(limit).Find(&songs).Error
+	return songs, err
+}
+
+func (r *sqliteRepository) SearchMusicByText(text string) ([]Music, error) {
+	var songs []Music
+	pattern := fmt.Sprintf("%%%s%%", text) // 构造模糊匹配模式
+
+	// SQLite 的 LIKE 默认不区分 ASCII 字母的大小写
+	err := r.db.Where("title LIKE ? OR singer LIKE ?", pattern, pattern).Find(&songs).Error
+
+	return songs, err
+}
+
+func (r *sqliteRepository) SetMusicLabels(tx *gorm.DB, musicID int64, labels, delabels []string) error {
+	if err := tx.Where("music_id = ?", musicID).Delete(&MusicLabel{}).Error; err != nil {
+		return err
+	}
+	rows := musicLabelRows(&Music{Id: musicID, Labels: labels, DeLabels: delabels})
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.Create(&rows).Error
+}
+
+func (r *sqliteRepository) TimePart(part, column string) string {
+	// 时间以带时区的文本保存，strftime 先换算为 UTC，再用 localtime 换回服务器所在时区
+	switch part {
+	case TimePartHour:
+		return "CAST(strftime('%H', " + column + ", 'localtime') AS INTEGER)"
+	case TimePartWeekday:
+		return "CAST(strftime('%w', " + column + ", 'localtime') AS INTEGER)"
+	case TimePartMonth:
+		return "CAST(strftime('%m', " + column + ", 'localtime') AS INTEGER)"
+	default:
+		return "strftime('%Y-%m-%d', " + column + ", 'localtime')"
+	}
+}
+
+func (r *sqliteRepository) JoinMusicLabels(q *gorm.DB) *gorm.DB {
+	return q.Joins("JOIN music_labels AS l ON l.music_id = music.id AND l.negative = ?", false)
+}
+
+// ==== 标签连接表的同步 ====
+
+// 让 Music 的 labels / delabels 列不参与建表和读写，改由下面的回调维护
+// gorm 按连接缓存解析后的 schema，这里修改的只是 SQLite 连接上的 Music schema
+func registerMusicLabelCallbacks(db *gorm.DB) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&Music{}); err != nil {
+		return err
+	}
+	for _, name := range []string{"Labels", "DeLabels"} {
+		field := stmt.Schema.LookUpField(name)
+		if field == nil {
+			return errors.New("music schema has no field " + name)
+		}
+		field.IgnoreMigration = true
+		field.Creatable, field.Updatable, field.Readable = false, false, false
+	}
+
+	if err := db.Callback().Query().After("gorm:query").Register("nmp:load_music_labels", loadMusicLabels); err != nil {
+		return err
+	}
+	if err := db.Callback().Create().After("gorm:create").Register("nmp:save_music_labels", saveMusicLabels); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Register("nmp:delete_music_labels", deleteMusicLabels)
+}
+
+// 查询结果中的歌曲，dest 可以是 Music、*Music 或它们的切片
+func musicInResult(v reflect.Value) []*Music {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	var out []*Music
+	add := func(e reflect.Value) {
+		for e.Kind() == reflect.Ptr {
+			if e.IsNil() {
+				return
+			}
+			e = e.Elem()
+		}
+		if e.Type() == musicType && e.CanAddr() {
+			out = append(out, e.Addr().Interface().(*Music))
+		}
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			add(v.Index(i))
+		}
+	case reflect.Struct:
+		add(v)
+	}
+	return out
+}
+
+func isMusicStatement(db *gorm.DB) bool {
+	return db.Error == nil && db.Statement.Schema != nil && db.Statement.Schema.Table == Music{}.TableName()
+}
+
+func musicLabelRows(m *Music) []MusicLabel {
+	rows := make([]MusicLabel, 0, len(m.Labels)+len(m.DeLabels))
+	for _, labels := range []struct {
+		negative bool
+		values   []string
+	}{{false, m.Labels}, {true, m.DeLabels}} {
+		seen := map[string]bool{}
+		for _, l := range labels.values {
+			if seen[l] {
+				continue
+			}
+			seen[l] = true
+			rows = append(rows, MusicLabel{MusicID: m.Id, Negative: labels.negative, Label: l, Position: len(seen)})
+		}
+	}
+	return rows
+}
+
+// 查询歌曲后从连接表填充 Labels / DeLabels
+func loadMusicLabels(db *gorm.DB) {
+	// 没有查到歌曲时保留 dest 原样，FirstOrCreate 接着会用它新建歌曲
+	if !isMusicStatement(db) || db.Statement.RowsAffected == 0 {
+		return
+	}
+	songs := musicInResult(db.Statement.ReflectValue)
+	byID := make(map[int64][]*Music, len(songs))
+	ids := make([]int64, 0, len(songs))
+	for _, m := range songs {
+		m.Labels, m.DeLabels = nil, nil
+		if m.Id == 0 {
+			continue
+		}
+		if _, ok := byID[m.Id]; !ok {
+			ids = append(ids, m.Id)
+		}
+		byID[m.Id] = append(byID[m.Id], m)
+	}
+
+	tx := db.Session(&gorm.Session{NewDB: true})
+	for start := 0; start < len(ids); start += musicLabelBatchSize {
+		end := min(start+musicLabelBatchSize, len(ids))
+		var rows []MusicLabel
+		err := tx.Where("music_id IN ?", ids[start:end]).Order("music_id, negative, position").Find(&rows).Error
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		for _, row := range rows {
+			for _, m := range byID[row.MusicID] {
+				if row.Negative {
+					m.DeLabels = append(m.DeLabels, row.Label)
+				} else {
+					m.Labels = append(m.Labels, row.Label)
+				}
+			}
+		}
+	}
+}
+
+// 新建歌曲后把 Labels / DeLabels 写入连接表
+func saveMusicLabels(db *gorm.DB) {
+	if !isMusicStatement(db) {
+		return
+	}
+	var rows []MusicLabel
+	for _, m := range musicInResult(db.Statement.ReflectValue) {
+		rows = append(rows, musicLabelRows(m)...)
+	}
+	if len(rows) > 0 {
+		db.AddError(db.Session(&gorm.Session{NewDB: true}).CreateInBatches(rows, musicLabelBatchSize).Error)
+	}
+}
+
+// 删除歌曲后清理连接表中已不存在的歌曲
+func deleteMusicLabels(db *gorm.DB) {
+	if !isMusicStatement(db) || db.Statement.RowsAffected == 0 {
+		return
+	}
+	db.AddError(db.Session(&gorm.Session{NewDB: true}).
+		Where("music_id NOT IN (?)", db.Session(&gorm.Session{NewDB: true}).Model(&Music{}).Select("id")).
+		Delete(&MusicLabel{}).Error)
+}
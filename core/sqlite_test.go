@@ -0,0 +1,217 @@
+package core
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 打开内存中的 SQLite 数据库并替换全局的 Repo 和 DB，测试结束后恢复
+func openTestRepo(t *testing.T, models ...interface{}) Repository {
+	t.Helper()
+	repo, err := openRepository("sqlite::memory:")
+	if err != nil {
+		t.Fatalf("openRepository: %v", err)
+	}
+	if err := repo.Migrate(append([]interface{}{&Music{}, &PlayHistory{}}, models...)...); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	oldRepo, oldDB := Repo, DB
+	Repo, DB = repo, repo.DB()
+	t.Cleanup(func() {
+		Repo, DB = oldRepo, oldDB
+		if sqlDB, err := repo.DB().DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return repo
+}
+
+func TestDatabaseBackend(t *testing.T) {
+	tests := []struct {
+		dsn     string
+		backend string
+		path    string
+	}{
+		{"postgres://user:pass@db:5432/nmp", BackendPostgres, ""},
+		{"PostgreSQL://db/nmp", BackendPostgres, ""},
+		{"host=db user=nmp dbname=nmp sslmode=disable", BackendPostgres, ""},
+		{"sqlite://./data/nmp.db", BackendSQLite, "./data/nmp.db"},
+		{"sqlite:///abs/nmp.db", BackendSQLite, "/abs/nmp.db"},
+		{"sqlite::memory:", BackendSQLite, ":memory:"},
+		{"SQLite:nmp.db?_txlock=deferred", BackendSQLite, "nmp.db?_txlock=deferred"},
+		{"file:nmp.db", BackendSQLite, "file:nmp.db"},
+		{"mysql://db/nmp", "", ""},
+		{"nmp.db", "", ""},
+	}
+	for _, tt := range tests {
+		backend, path := databaseBackend(tt.dsn)
+		if backend != tt.backend || path != tt.path {
+			t.Errorf("databaseBackend(%q) = %q, %q; want %q, %q", tt.dsn, backend, path, tt.backend, tt.path)
+		}
+	}
+}
+
+func TestDBTimeScan(t *testing.T) {
+	utc := time.Date(2024, 3, 1, 12, 0, 5, 500000000, time.UTC)
+	tests := []struct {
+		in      interface{}
+		want    time.Time
+		wantErr bool
+	}{
+		{nil, time.Time{}, false},
+		{utc, utc, false},
+		{"2024-03-01 20:00:05.5+08:00", utc, false},
+		{[]byte("2024-03-01T12:00:05.5Z"), utc, false},
+		{"2024-03-01 12:00:05", time.Date(2024, 3, 1, 12, 0, 5, 0, time.Local), false},
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), false},
+		{"yesterday", time.Time{}, true},
+		{int64(1709294400), time.Time{}, true},
+	}
+	for _, tt := range tests {
+		var got dbTime
+		err := got.Scan(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Scan(%v) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !got.Equal(tt.want) {
+			t.Errorf("Scan(%v) = %v, want %v", tt.in, got.Time, tt.want)
+		}
+	}
+}
+
+func TestSQLiteMusicLabels(t *testing.T) {
+	repo := openTestRepo(t)
+
+	songs := []Music{
+		{Title: "A", Singer: "S", AudioURL: "a.mp3", Labels: []string{"Rock", "Night", "Rock"}, DeLabels: []string{"Sleep"}},
+		{Title: "B", Singer: "S", AudioURL: "b.mp3", Labels: []string{"Pop"}},
+		{Title: "C", Singer: "S", AudioURL: "c.mp3"},
+	}
+	if err := DB.Create(&songs).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var got Music
+	if err := DB.First(&got, songs[0].Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string(got.Labels), []string{"Rock", "Night"}) || !reflect.DeepEqual([]string(got.DeLabels), []string{"Sleep"}) {
+		t.Errorf("labels = %v / %v, want [Rock Night] / [Sleep]", got.Labels, got.DeLabels)
+	}
+
+	found, err := repo.SearchMusicByLabels([]string{"Pop", "Sleep"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Id != songs[1].Id {
+		t.Errorf("SearchMusicByLabels = %+v, want only song B", found)
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		return repo.SetMusicLabels(tx, songs[0].Id, []string{"Chill"}, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = Music{}
+	if err := DB.First(&got, songs[0].Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string(got.Labels), []string{"Chill"}) || len(got.DeLabels) != 0 {
+		t.Errorf("after SetMusicLabels labels = %v / %v, want [Chill] / []", got.Labels, got.DeLabels)
+	}
+
+	var counts []struct {
+		Label string
+		Count int
+	}
+	err = repo.JoinMusicLabels(DB.Model(&Music{})).
+		Select("l.label AS label, COUNT(*) AS count").Group("l.label").Order("l.label").Scan(&counts).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 || counts[0].Label != "Chill" || counts[1].Label != "Pop" {
+		t.Errorf("JoinMusicLabels counts = %+v, want Chill and Pop", counts)
+	}
+
+	if err := DB.Delete(&Music{}, songs[0].Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	var left int64
+	if err := DB.Model(&MusicLabel{}).Where("music_id = ?", songs[0].Id).Count(&left).Error; err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d label rows left after deleting the song", left)
+	}
+}
+
+func TestSQLiteTimePart(t *testing.T) {
+	repo := openTestRepo(t)
+	playedAt := time.Date(2024, 3, 2, 23, 30, 0, 0, time.UTC)
+	if err := DB.Create(&PlayHistory{MusicID: 1, PlayedAt: playedAt}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 按服务器所在时区取值
+	local := playedAt.Local()
+	tests := []struct {
+		part string
+		want string
+	}{
+		{TimePartHour, strconv.Itoa(local.Hour())},
+		{TimePartWeekday, strconv.Itoa(int(local.Weekday()))},
+		{TimePartMonth, strconv.Itoa(int(local.Month()))},
+		{TimePartDay, local.Format("2006-01-02")},
+	}
+	for _, tt := range tests {
+		var got string
+		err := DB.Model(&PlayHistory{}).Select("CAST(" + repo.TimePart(tt.part, "played_at") + " AS TEXT)").Scan(&got).Error
+		if err != nil {
+			t.Fatalf("%s: %v", tt.part, err)
+		}
+		if got != tt.want {
+			t.Errorf("TimePart(%s) = %q, want %q", tt.part, got, tt.want)
+		}
+	}
+}
+
+func TestSQLiteRecordPlay(t *testing.T) {
+	repo := openTestRepo(t)
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	window := 5 * time.Minute
+	tests := []struct {
+		name   string
+		play   PlayHistory
+		wantOK bool
+	}{
+		{"first auto", PlayHistory{UserID: 1, MusicID: 7, Source: PlaySourceAuto, PlayedAt: base}, true},
+		{"auto within window", PlayHistory{UserID: 1, MusicID: 7, Source: PlaySourceAuto, PlayedAt: base.Add(time.Minute)}, false},
+		{"event within window", PlayHistory{UserID: 1, MusicID: 7, Source: PlaySourceEvent, PlayedAt: base.Add(2 * time.Minute)}, true},
+		{"second event", PlayHistory{UserID: 1, MusicID: 7, Source: PlaySourceEvent, PlayedAt: base.Add(3 * time.Minute)}, true},
+		{"other user", PlayHistory{UserID: 2, MusicID: 7, Source: PlaySourceAuto, PlayedAt: base.Add(time.Minute)}, true},
+		{"other song", PlayHistory{UserID: 1, MusicID: 8, Source: PlaySourceAuto, PlayedAt: base.Add(time.Minute)}, true},
+		{"auto after window", PlayHistory{UserID: 1, MusicID: 7, Source: PlaySourceAuto, PlayedAt: base.Add(6 * time.Minute)}, true},
+	}
+	for _, tt := range tests {
+		ok, err := repo.RecordPlay(&tt.play, window)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok != tt.wantOK {
+			t.Errorf("%s: recorded = %v, want %v", tt.name, ok, tt.wantOK)
+		}
+	}
+	var count int64
+	if err := DB.Model(&PlayHistory{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 6 {
+		t.Errorf("play_history has %d rows, want 6", count)
+	}
+}
@@ -0,0 +1,337 @@
+package core
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 单次播放的收听时长：有上报时长时取上报值，否则按整首歌计算（自动记录的播放）
+const listenedMsExpr = "CASE WHEN play_history.listened_ms > 0 THEN play_history.listened_ms ELSE COALESCE(music.duration_ms, 0) END"
+
+// 收听时长求和（Postgres 的 SUM(bigint) 返回 numeric，这里转回 bigint）
+const sumListenedMs = "CAST(COALESCE(SUM(" + listenedMsExpr + "), 0) AS BIGINT)"
+
+// 统计的用户和时间范围，时间为零值表示不限
+type StatsRange struct {
+	UserID int64     `json:"-"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
+
+// 解析统计时间范围，支持 RFC3339 和 2006-01-02 两种格式；只给日期时 to 包含当天
+func ParseStatsRange(from, to string) (StatsRange, error) {
+	var r StatsRange
+	var err error
+	if from != "" {
+		if r.From, err = parseStatsTime(from); err != nil {
+			return r, err
+		}
+	}
+	if to != "" {
+		if r.To, err = parseStatsTime(to); err != nil {
+			return r, err
+		}
+		if len(to) == len("2006-01-02") {
+			r.To = r.To.AddDate(0, 0, 1)
+		}
+	}
+	if !r.From.IsZero() && !r.To.IsZero() && !r.From.Before(r.To) {
+		return r, errors.New("from must be before to")
+	}
+	return r, nil
+}
+
+func parseStatsTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// 播放记录联表歌曲，并限定用户和时间范围
+func statsQuery(r StatsRange) *gorm.DB {
+	q := DB.Table("play_history").Joins("JOIN music ON music.id = play_history.music_id").
+		Where("play_history.user_id = ?", r.UserID)
+	if !r.From.IsZero() {
+		q = q.Where("play_history.played_at >= ?", r.From)
+	}
+	if !r.To.IsZero() {
+		q = q.Where("play_history.played_at < ?", r.To)
+	}
+	return q
+}
+
+// 收听概况
+type ListeningSummary struct {
+	Plays      int64 `json:"plays"`
+	ListenedMs int64 `json:"listenedMs"`
+	Tracks     int64 `json:"tracks"`  // 听过的不同歌曲数
+	Singers    int64 `json:"singers"` // 听过的不同歌手数
+	Completed  int64 `json:"completed"`
+	Skipped    int64 `json:"skipped"`
+}
+
+func GetListeningSummary(r StatsRange) (*ListeningSummary, error) {
+	var s ListeningSummary
+	err := statsQuery(r).Select(
+		"COUNT(*) AS plays, " +
+			sumListenedMs + " AS listened_ms, " +
+			"COUNT(DISTINCT play_history.music_id) AS tracks, " +
+			"COUNT(DISTINCT music.singer) AS singers, " +
+			"COUNT(CASE WHEN play_history.completed THEN 1 END) AS completed, " +
+			"COUNT(CASE WHEN play_history.end_reason = 'skipped' THEN 1 END) AS skipped",
+	).Scan(&s).Error
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// 歌曲排行
+type TrackStat struct {
+	MusicID    int64 `json:"-"`
+	Plays      int64 `json:"plays"`
+	ListenedMs int64 `json:"listenedMs"`
+	Music      Music `json:"music" gorm:"-"`
+}
+
+func GetTopTracks(r StatsRange, limit int) ([]TrackStat, error) {
+	stats := []TrackStat{}
+	err := statsQuery(r).
+		Select("play_history.music_id AS music_id, COUNT(*) AS plays, " + sumListenedMs + " AS listened_ms").
+		Group("play_history.music_id").
+		Order("plays DESC, listened_ms DESC").
+		Limit(limit).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(stats))
+	for i, s := range stats {
+		ids[i] = s.MusicID
+	}
+	var songs []Music
+	if err := DB.Where("id IN ?", ids).Find(&songs).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]Music, len(songs))
+	for _, m := range songs {
+		byID[m.Id] = m
+	}
+	for i := range stats {
+		stats[i].Music = byID[stats[i].MusicID]
+	}
+	return stats, nil
+}
+
+// 歌手或标签排行
+type NameStat struct {
+	Name       string `json:"name"`
+	Plays      int64  `json:"plays"`
+	ListenedMs int64  `json:"listenedMs"`
+}
+
+func GetTopSingers(r StatsRange, limit int) ([]NameStat, error) {
+	stats := []NameStat{}
+	err := statsQuery(r).
+		Select("music.singer AS name, COUNT(*) AS plays, " + sumListenedMs + " AS listened_ms").
+		Group("music.singer").
+		Order("plays DESC, listened_ms DESC").
+		Limit(limit).
+		Scan(&stats).Error
+	return stats, err
+}
+
+func GetTopLabels(r StatsRange, limit int) ([]NameStat, error) {
+	stats := []NameStat{}
+	err := Repo.JoinMusicLabels(statsQuery(r)).
+		Select("l.label AS name, COUNT(*) AS plays, " + sumListenedMs + " AS listened_ms").
+		Group("l.label").
+		Order("plays DESC, listened_ms DESC").
+		Limit(limit).
+		Scan(&stats).Error
+	return stats, err
+}
+
+// 按时段统计的收听量
+type BucketStat struct {
+	Bucket     int   `json:"bucket"`
+	Plays      int64 `json:"plays"`
+	ListenedMs int64 `json:"listenedMs"`
+}
+
+// 收听时段分布：hourly 为 0~23 点，weekday 为 0（周日）~6（周六）
+type ListeningDistribution struct {
+	Hourly  []BucketStat `json:"hourly"`
+	Weekday []BucketStat `json:"weekday"`
+}
+
+func GetListeningDistribution(r StatsRange) (*ListeningDistribution, error) {
+	hourly, err := bucketStats(r, TimePartHour, 24)
+	if err != nil {
+		return nil, err
+	}
+	weekday, err := bucketStats(r, TimePartWeekday, 7)
+	if err != nil {
+		return nil, err
+	}
+	return &ListeningDistribution{Hourly: hourly, Weekday: weekday}, nil
+}
+
+// 按播放时间的某一部分（见 TimePart*）分桶统计，并补齐没有播放的桶
+func bucketStats(r StatsRange, part string, n int) ([]BucketStat, error) {
+	var rows []BucketStat
+	err := statsQuery(r).
+		Select(Repo.TimePart(part, "play_history.played_at") + " AS bucket, COUNT(*) AS plays, " + sumListenedMs + " AS listened_ms").
+		Group("bucket").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([]BucketStat, n)
+	for i := range buckets {
+		buckets[i].Bucket = i
+	}
+	for _, row := range rows {
+		if row.Bucket >= 0 && row.Bucket < n {
+			buckets[row.Bucket] = row
+		}
+	}
+	return buckets, nil
+}
+
+// 连续收听天数
+type ListeningStreaks struct {
+	Current      int    `json:"current"` // 截至今天（或昨天）的连续天数
+	Longest      int    `json:"longest"`
+	LongestStart string `json:"longestStart"`
+	LongestEnd   string `json:"longestEnd"`
+	ActiveDays   int    `json:"activeDays"`
+}
+
+func GetListeningStreaks(r StatsRange) (*ListeningStreaks, error) {
+	var keys []string
+	err := statsQuery(r).
+		Distinct(Repo.TimePart(TimePartDay, "play_history.played_at")+" AS day").
+		Order("day ASC").
+		Pluck("day", &keys).Error
+	if err != nil {
+		return nil, err
+	}
+	days := make([]time.Time, 0, len(keys))
+	for _, k := range keys {
+		day, err := time.ParseInLocation("2006-01-02", k, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, day)
+	}
+	return computeStreaks(days, time.Now()), nil
+}
+
+func computeStreaks(days []time.Time, now time.Time) *ListeningStreaks {
+	s := &ListeningStreaks{ActiveDays: len(days)}
+	if len(days) == 0 {
+		return s
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	dayKey := func(t time.Time) string { return t.Format("2006-01-02") }
+	run, runStart := 1, 0
+	for i := 1; i <= len(days); i++ {
+		if i < len(days) && dayKey(days[i-1].AddDate(0, 0, 1)) == dayKey(days[i]) {
+			run++
+			continue
+		}
+		if run > s.Longest {
+			s.Longest = run
+			s.LongestStart, s.LongestEnd = dayKey(days[runStart]), dayKey(days[i-1])
+		}
+		if i == len(days) {
+			// 最后一段若以今天或昨天结束，则为当前连续天数
+			last := dayKey(days[i-1])
+			if last == dayKey(now) || last == dayKey(now.AddDate(0, 0, -1)) {
+				s.Current = run
+			}
+		}
+		run, runStart = 1, i
+	}
+	return s
+}
+
+// 年度报告
+type YearInReview struct {
+	Year         int                    `json:"year"`
+	Summary      *ListeningSummary      `json:"summary"`
+	TopTracks    []TrackStat            `json:"topTracks"`
+	TopSingers   []NameStat             `json:"topSingers"`
+	TopLabels    []NameStat             `json:"topLabels"`
+	Monthly      []BucketStat           `json:"monthly"` // 1~12 月，bucket 为月份
+	PeakHour     int                    `json:"peakHour"`
+	PeakWeekday  int                    `json:"peakWeekday"`
+	Streaks      *ListeningStreaks      `json:"streaks"`
+	NewTracks    int64                  `json:"newTracks"` // 当年第一次听到的歌曲数
+	Distribution *ListeningDistribution `json:"distribution"`
+}
+
+func GetYearInReview(userID int64, year int) (*YearInReview, error) {
+	r := StatsRange{
+		UserID: userID,
+		From:   time.Date(year, 1, 1, 0, 0, 0, 0, time.Local),
+		To:     time.Date(year+1, 1, 1, 0, 0, 0, 0, time.Local),
+	}
+	review := &YearInReview{Year: year}
+	var err error
+
+	if review.Summary, err = GetListeningSummary(r); err != nil {
+		return nil, err
+	}
+	if review.TopTracks, err = GetTopTracks(r, 5); err != nil {
+		return nil, err
+	}
+	if review.TopSingers, err = GetTopSingers(r, 5); err != nil {
+		return nil, err
+	}
+	if review.TopLabels, err = GetTopLabels(r, 5); err != nil {
+		return nil, err
+	}
+	if review.Distribution, err = GetListeningDistribution(r); err != nil {
+		return nil, err
+	}
+	review.PeakHour = peakBucket(review.Distribution.Hourly)
+	review.PeakWeekday = peakBucket(review.Distribution.Weekday)
+
+	monthly, err := bucketStats(r, TimePartMonth, 13)
+	if err != nil {
+		return nil, err
+	}
+	review.Monthly = monthly[1:]
+
+	if review.Streaks, err = GetListeningStreaks(r); err != nil {
+		return nil, err
+	}
+
+	err = DB.Table("(?) AS firsts",
+		DB.Table("play_history").Select("music_id, MIN(played_at) AS first_played").Where("user_id = ?", userID).Group("music_id"),
+	).Where("first_played >= ? AND first_played < ?", r.From, r.To).Count(&review.NewTracks).Error
+	if err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// 收听量最多的桶，没有任何播放时返回 -1
+func peakBucket(buckets []BucketStat) int {
+	peak := -1
+	var most int64
+	for _, b := range buckets {
+		if b.Plays > most {
+			peak, most = b.Bucket, b.Plays
+		}
+	}
+	return peak
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	waveformBaseResolution    = 4096 // 缓存的基础分辨率，请求的分辨率由它降采样得到
+	waveformDefaultResolution = 200
+)
+
+// 缓存的波形峰值
+type MusicWaveform struct {
+	MusicID     int64     `gorm:"primaryKey;column:music_id"`
+	ContentHash string    `gorm:"column:content_hash;type:varchar(64)"` // 计算时的音频指纹，音频变化后重新计算
+	Resolution  int       `gorm:"column:resolution;not null"`
+	DurationMs  int64     `gorm:"column:duration_ms"`
+	Peaks       []byte    `gorm:"column:peaks;not null"` // 每个点依次为 min、max 两个 int16
+	CreatedAt   time.Time `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (MusicWaveform) TableName() string {
+	return "music_waveforms"
+}
+
+// 返回给客户端的波形数据
+type Waveform struct {
+	MusicID    int64     `json:"musicId"`
+	Points     int       `json:"points"`
+	DurationMs int64     `json:"durationMs"`
+	Min        []float64 `json:"min"`
+	Max        []float64 `json:"max"`
+}
+
+// 同一首歌的波形只计算一次
+var waveformLocks sync.Map
+
+// 获取歌曲指定分辨率的波形，首次请求时解码音频并缓存到数据库
+func GetMusicWaveform(music *Music, points int) (*Waveform, error) {
+	if points <= 0 {
+		points = waveformDefaultResolution
+	}
+	if points > waveformBaseResolution {
+		points = waveformBaseResolution
+	}
+
+	lock, _ := waveformLocks.LoadOrStore(music.Id, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	var cached MusicWaveform
+	err := DB.Where("music_id = ?", music.Id).First(&cached).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err != nil || (music.ContentHash != "" && cached.ContentHash != music.ContentHash) {
+		fresh, err := computeWaveform(music)
+		if err != nil {
+			return nil, err
+		}
+		if err := DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(fresh).Error; err != nil {
+			return nil, err
+		}
+		cached = *fresh
+	}
+
+	return downsampleWaveform(&cached, points), nil
+}
+
+// 解码音频，计算基础分辨率的 min/max 峰值
+func computeWaveform(music *Music) (*MusicWaveform, error) {
+	fullPath, err := resolveAssetPath(music.AudioURL)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := openAudioDecoder(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	channels := dec.Channels()
+
+	// 先按固定帧数分块记录峰值（音频总长度事先未知），最后再合并为基础分辨率
+	const chunkFrames = 256
+	var (
+		mins, maxs []float64
+		lo, hi     float64
+		frames     int64
+		inChunk    int
+		ch         int
+	)
+	buf := make([]float64, 4096*channels)
+	for {
+		n, err := dec.ReadSamples(buf)
+		for _, s := range buf[:n] {
+			lo, hi = math.Min(lo, s), math.Max(hi, s)
+			ch++
+			if ch == channels {
+				ch = 0
+				frames++
+				inChunk++
+				if inChunk == chunkFrames {
+					mins, maxs = append(mins, lo), append(maxs, hi)
+					lo, hi, inChunk = 0, 0, 0
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if inChunk > 0 {
+		mins, maxs = append(mins, lo), append(maxs, hi)
+	}
+	if len(mins) == 0 {
+		return nil, errors.New("empty audio stream")
+	}
+
+	resolution := waveformBaseResolution
+	if len(mins) < resolution {
+		resolution = len(mins)
+	}
+	peaks := make([]byte, resolution*4)
+	for i := 0; i < resolution; i++ {
+		from, to := i*len(mins)/resolution, (i+1)*len(mins)/resolution
+		lo, hi := 0.0, 0.0
+		for j := from; j < to; j++ {
+			lo, hi = math.Min(lo, mins[j]), math.Max(hi, maxs[j])
+		}
+		binary.LittleEndian.PutUint16(peaks[i*4:], uint16(toInt16(lo)))
+		binary.LittleEndian.PutUint16(peaks[i*4+2:], uint16(toInt16(hi)))
+	}
+
+	return &MusicWaveform{
+		MusicID:     music.Id,
+		ContentHash: music.ContentHash,
+		Resolution:  resolution,
+		DurationMs:  frames * 1000 / int64(dec.SampleRate()),
+		Peaks:       peaks,
+	}, nil
+}
+
+// 将缓存的基础波形降采样到请求的分辨率
+func downsampleWaveform(w *MusicWaveform, points int) *Waveform {
+	if points > w.Resolution {
+		points = w.Resolution
+	}
+	res := &Waveform{
+		MusicID:    w.MusicID,
+		Points:     points,
+		DurationMs: w.DurationMs,
+		Min:        make([]float64, points),
+		Max:        make([]float64, points),
+	}
+	for i := 0; i < points; i++ {
+		from, to := i*w.Resolution/points, (i+1)*w.Resolution/points
+		var lo, hi int16
+		for j := from; j < to; j++ {
+			lo = min(lo, int16(binary.LittleEndian.Uint16(w.Peaks[j*4:])))
+			hi = max(hi, int16(binary.LittleEndian.Uint16(w.Peaks[j*4+2:])))
+		}
+		res.Min[i] = math.Round(float64(lo)/32767*1000) / 1000
+		res.Max[i] = math.Round(float64(hi)/32767*1000) / 1000
+	}
+	return res
+}
+
+func toInt16(v float64) int16 {
+	return int16(math.Max(-1, math.Min(1, v)) * 32767)
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second // 超过该时长没有收到任何消息视为断开
+	wsPingPeriod     = 25 * time.Second // 必须小于 wsPongWait
+	wsMaxMessageSize = 64 << 10
+	wsSendBuffer     = 64 // 发送缓冲满时断开该连接，避免慢客户端拖住其他连接
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     checkWSOrigin,
+}
+
+// 默认只允许同源连接；WS_ALLOWED_ORIGINS 可配置逗号分隔的来源，"*" 表示允许任意来源
+func checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range strings.Split(os.Getenv("WS_ALLOWED_ORIGINS"), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// 一个 WebSocket 连接：写操作只在 writePump 中进行，其他协程通过发送缓冲发消息
+type wsConn struct {
+	conn *websocket.Conn
+
+	mu     sync.Mutex
+	send   chan []byte
+	closed bool
+}
+
+// 升级为 WebSocket 连接并启动写协程
+func upgradeWS(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade 已经返回了错误响应
+		return nil, err
+	}
+	c := &wsConn{conn: conn, send: make(chan []byte, wsSendBuffer)}
+	go c.writePump()
+	return c, nil
+}
+
+// 放入发送缓冲；缓冲已满时返回 false，由调用方决定是否断开
+func (c *wsConn) trySend(v any) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("websocket: %v", err)
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return true
+	}
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// 关闭发送缓冲，writePump 随后发送关闭帧并断开连接
+func (c *wsConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// 读取消息直到连接断开；收到任何消息或 pong 都会延长读超时
+func (c *wsConn) readLoop(name string, handle func(data []byte)) {
+	defer c.conn.Close()
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("%s: %v", name, err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		handle(data)
+	}
+}
+
+// 把发送缓冲中的消息写到连接，并定期发送 ping
+func (c *wsConn) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -14,10 +14,18 @@ func main() {
 	// 生产模式
 	gin.SetMode(gin.ReleaseMode)
 	
-	// 初始化数据库
-	core.InitDB()
+	// 初始化数据库，后台任务都依赖数据库，连接失败时直接退出
+	if err := core.InitDB(); err != nil {
+		log.Fatalf("初始化数据库失败: %v", err)
+	}
 	defer core.CloseDB()
 
+	// 启动后台任务
+	core.StartScrobbler()
+	core.StartDailyMixScheduler()
+	core.StartSimilarityWorker()
+	core.StartPlaylistTrashPurger()
+
 	router := gin.Default()
 	
 	// 注册API路由